@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ianmuscat/dyn/internal/awsauth"
+	"github.com/spf13/viper"
+)
+
+// securityGroup keeps an ingress rule of an AWS security group allowing the
+// public IP, e.g. SSH access to EC2 instances from home. The rule is found by
+// its description, and created when missing.
+type securityGroup struct {
+	creds       *awsauth.Chain
+	region      string
+	groupID     string
+	protocol    string
+	fromPort    int
+	toPort      int
+	description string
+}
+
+func newSecurityGroup(key string) (Action, error) {
+	s := &securityGroup{
+		creds:       newAWSCredentials(key),
+		region:      viper.GetString(key + ".region"),
+		groupID:     viper.GetString(key + ".groupId"),
+		protocol:    viper.GetString(key + ".protocol"),
+		fromPort:    viper.GetInt(key + ".port"),
+		toPort:      viper.GetInt(key + ".toPort"),
+		description: viper.GetString(key + ".description"),
+	}
+	if s.groupID == "" {
+		return nil, fmt.Errorf("securityGroup: missing %s.groupId", key)
+	}
+	if s.region == "" {
+		region, err := awsRegion("")
+		if err != nil {
+			return nil, fmt.Errorf("securityGroup: missing %s.region", key)
+		}
+		s.region = region
+	}
+	if s.protocol == "" {
+		s.protocol = "tcp"
+	}
+	if s.fromPort == 0 {
+		s.fromPort = 22
+	}
+	if s.toPort == 0 {
+		s.toPort = s.fromPort
+	}
+	if s.description == "" {
+		s.description = "dyn"
+	}
+	return s, nil
+}
+
+type ec2SecurityGroupRule struct {
+	ID          string `xml:"securityGroupRuleId"`
+	IsEgress    bool   `xml:"isEgress"`
+	Protocol    string `xml:"ipProtocol"`
+	FromPort    int    `xml:"fromPort"`
+	ToPort      int    `xml:"toPort"`
+	CidrIPv4    string `xml:"cidrIpv4"`
+	CidrIPv6    string `xml:"cidrIpv6"`
+	Description string `xml:"description"`
+}
+
+func (s *securityGroup) Apply(ctx context.Context, ip net.IP) error {
+	cidr, field := ip.String()+"/128", "CidrIpv6"
+	if ip.To4() != nil {
+		cidr, field = ip.String()+"/32", "CidrIpv4"
+	}
+
+	rule, err := s.findRule(ctx, ip.To4() != nil)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Set("GroupId", s.groupID)
+	if rule == nil {
+		params.Set("Action", "AuthorizeSecurityGroupIngress")
+		params.Set("IpPermissions.1.IpProtocol", s.protocol)
+		params.Set("IpPermissions.1.FromPort", strconv.Itoa(s.fromPort))
+		params.Set("IpPermissions.1.ToPort", strconv.Itoa(s.toPort))
+		if field == "CidrIpv4" {
+			params.Set("IpPermissions.1.IpRanges.1.CidrIp", cidr)
+			params.Set("IpPermissions.1.IpRanges.1.Description", s.description)
+		} else {
+			params.Set("IpPermissions.1.Ipv6Ranges.1.CidrIpv6", cidr)
+			params.Set("IpPermissions.1.Ipv6Ranges.1.Description", s.description)
+		}
+		return s.call(ctx, params, nil)
+	}
+
+	if rule.CidrIPv4 == cidr || rule.CidrIPv6 == cidr {
+		return nil
+	}
+
+	prefix := "SecurityGroupRule.1."
+	params.Set("Action", "ModifySecurityGroupRules")
+	params.Set(prefix+"SecurityGroupRuleId", rule.ID)
+	params.Set(prefix+"SecurityGroupRule.IpProtocol", s.protocol)
+	params.Set(prefix+"SecurityGroupRule.FromPort", strconv.Itoa(s.fromPort))
+	params.Set(prefix+"SecurityGroupRule.ToPort", strconv.Itoa(s.toPort))
+	params.Set(prefix+"SecurityGroupRule."+field, cidr)
+	params.Set(prefix+"SecurityGroupRule.Description", s.description)
+	return s.call(ctx, params, nil)
+}
+
+// findRule returns the ingress rule for IPv4 or IPv6 with the action's
+// description, protocol and ports, or nil when there's none
+func (s *securityGroup) findRule(ctx context.Context, ipv4 bool) (*ec2SecurityGroupRule, error) {
+	token := ""
+	for {
+		params := url.Values{}
+		params.Set("Action", "DescribeSecurityGroupRules")
+		params.Set("Filter.1.Name", "group-id")
+		params.Set("Filter.1.Value.1", s.groupID)
+		if token != "" {
+			params.Set("NextToken", token)
+		}
+
+		var resp struct {
+			Rules     []ec2SecurityGroupRule `xml:"securityGroupRuleSet>item"`
+			NextToken string                 `xml:"nextToken"`
+		}
+		err := s.call(ctx, params, &resp)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range resp.Rules {
+			if r.IsEgress || r.Description != s.description || r.Protocol != s.protocol ||
+				r.FromPort != s.fromPort || r.ToPort != s.toPort {
+				continue
+			}
+			if (ipv4 && r.CidrIPv4 != "") || (!ipv4 && r.CidrIPv6 != "") {
+				return &r, nil
+			}
+		}
+
+		if resp.NextToken == "" {
+			return nil, nil
+		}
+		token = resp.NextToken
+	}
+}
+
+// call sends a request to the EC2 Query API, decoding the XML response into
+// out unless it's nil
+func (s *securityGroup) call(ctx context.Context, params url.Values, out interface{}) error {
+	creds, err := s.creds.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("securityGroup: %v", err)
+	}
+
+	params.Set("Version", "2016-11-15")
+	body := []byte(params.Encode())
+	req, err := http.NewRequest("POST", fmt.Sprintf("https://ec2.%s.amazonaws.com/", s.region), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("securityGroup: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signV4(req, body, creds, s.region, "ec2", time.Now())
+
+	data, err := httpDo(ctx, req)
+	if e, ok := err.(*httpError); ok {
+		var ec2Err struct {
+			Code    string `xml:"Errors>Error>Code"`
+			Message string `xml:"Errors>Error>Message"`
+		}
+		if xml.Unmarshal([]byte(e.Body), &ec2Err) == nil && ec2Err.Code != "" {
+			return fmt.Errorf("securityGroup: %s: %s", ec2Err.Code, ec2Err.Message)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("securityGroup: %v", err)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, out)
+}
+
+func init() {
+	registerAction("securityGroup", newSecurityGroup)
+}
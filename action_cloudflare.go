@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	cf "github.com/cloudflare/cloudflare-go"
+	"github.com/ianmuscat/dyn/internal/cfapi"
+	"github.com/spf13/viper"
+)
+
+// cloudflareAccess keeps a Cloudflare IP access rule matching the public IP,
+// e.g. to allowlist it in front of an origin. The rule is found by its notes,
+// and applies to a zone, or to every zone of the account when none is set.
+// Cloudflare doesn't allow changing the address of a rule, so a new rule
+// replaces the old one.
+type cloudflareAccess struct {
+	api   *cf.API
+	zone  string
+	mode  string
+	notes string
+}
+
+func newCloudflareAccess(key string) (Action, error) {
+	client, err := cloudflareClient("cloudflare")
+	if err != nil {
+		return nil, err
+	}
+
+	// The credentials of the provider are used unless set for the action
+	creds := "cloudflare"
+	if viper.IsSet(key + ".apiKey") {
+		creds = key
+	}
+	api, err := newCloudflareAPI("cloudflare", creds, client)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflareAccess: %v", err)
+	}
+
+	a := &cloudflareAccess{
+		api:   api,
+		zone:  viper.GetString(key + ".zone"),
+		mode:  viper.GetString(key + ".mode"),
+		notes: viper.GetString(key + ".notes"),
+	}
+	if a.mode == "" {
+		a.mode = "whitelist"
+	}
+	if a.notes == "" {
+		a.notes = "dyn"
+	}
+	return a, nil
+}
+
+func (a *cloudflareAccess) Apply(ctx context.Context, ip net.IP) error {
+	target := "ip6"
+	if ip.To4() != nil {
+		target = "ip"
+	}
+
+	zoneID := ""
+	if a.zone != "" {
+		var err error
+		zoneID, err = cfapi.ZoneIDByName(ctx, a.api, a.zone)
+		if err != nil {
+			return fmt.Errorf("cloudflareAccess: %v", err)
+		}
+	}
+
+	rules, err := a.rules(ctx, zoneID, target)
+	if err != nil {
+		return fmt.Errorf("cloudflareAccess: %v", err)
+	}
+
+	var stale []cf.AccessRule
+	current := false
+	for _, r := range rules {
+		if net.ParseIP(r.Configuration.Value).Equal(ip) && r.Mode == a.mode {
+			current = true
+			continue
+		}
+		stale = append(stale, r)
+	}
+
+	if !current {
+		rule := cf.AccessRule{
+			Mode:          a.mode,
+			Notes:         a.notes,
+			Configuration: cf.AccessRuleConfiguration{Target: target, Value: ip.String()},
+		}
+		if zoneID != "" {
+			_, err = a.api.CreateZoneAccessRule(ctx, zoneID, rule)
+		} else {
+			_, err = a.api.CreateUserAccessRule(ctx, rule)
+		}
+		if err != nil {
+			return fmt.Errorf("cloudflareAccess: %v", err)
+		}
+	}
+
+	// Old rules are only removed once the new one is in place
+	for _, r := range stale {
+		if zoneID != "" {
+			_, err = a.api.DeleteZoneAccessRule(ctx, zoneID, r.ID)
+		} else {
+			_, err = a.api.DeleteUserAccessRule(ctx, r.ID)
+		}
+		if err != nil {
+			return fmt.Errorf("cloudflareAccess: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// rules returns the access rules for addresses of the target type with the
+// action's notes
+func (a *cloudflareAccess) rules(ctx context.Context, zoneID, target string) ([]cf.AccessRule, error) {
+	filter := cf.AccessRule{
+		Notes:         a.notes,
+		Configuration: cf.AccessRuleConfiguration{Target: target},
+	}
+
+	var rules []cf.AccessRule
+	for page, pages := 1, 1; page <= pages; page++ {
+		var resp *cf.AccessRuleListResponse
+		var err error
+		if zoneID != "" {
+			resp, err = a.api.ListZoneAccessRules(ctx, zoneID, filter, page)
+		} else {
+			resp, err = a.api.ListUserAccessRules(ctx, filter, page)
+		}
+		if err != nil {
+			return nil, err
+		}
+		pages = resp.TotalPages
+
+		// The notes filter matches substrings
+		for _, r := range resp.Result {
+			if r.Notes == a.notes && r.Configuration.Target == target {
+				rules = append(rules, r)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func init() {
+	registerAction("cloudflareAccess", newCloudflareAccess)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const gcpComputeScope = "https://www.googleapis.com/auth/compute"
+
+// gcpFirewall keeps the source ranges of a GCP firewall rule allowing the
+// public IP. Ranges of the other address family are left as they are, so a
+// rule may allow both the IPv4 and IPv6 addresses. The rule must exist.
+//
+// Requests authenticate with a service account key, or with the service
+// account of the instance through the metadata server.
+type gcpFirewall struct {
+	project string
+	rule    string
+	account *gcpServiceAccount
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type gcpServiceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	key *rsa.PrivateKey
+}
+
+func newGCPFirewall(key string) (Action, error) {
+	f := &gcpFirewall{
+		project: viper.GetString(key + ".project"),
+		rule:    viper.GetString(key + ".rule"),
+	}
+	if f.rule == "" {
+		return nil, fmt.Errorf("gcpFirewall: missing %s.rule", key)
+	}
+
+	if file := viper.GetString(key + ".credentialsFile"); file != "" {
+		account, err := loadGCPServiceAccount(file)
+		if err != nil {
+			return nil, fmt.Errorf("gcpFirewall: %v", err)
+		}
+		f.account = account
+		if f.project == "" {
+			f.project = account.ProjectID
+		}
+	}
+	if f.project == "" {
+		return nil, fmt.Errorf("gcpFirewall: missing %s.project", key)
+	}
+
+	return f, nil
+}
+
+// loadGCPServiceAccount reads a service account key file, as downloaded from
+// the console
+func loadGCPServiceAccount(file string) (*gcpServiceAccount, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var a gcpServiceAccount
+	err = json.Unmarshal(data, &a)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if a.ClientEmail == "" || a.PrivateKey == "" {
+		return nil, fmt.Errorf("%s: not a service account key", file)
+	}
+	if a.TokenURI == "" {
+		a.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	block, _ := pem.Decode([]byte(a.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("%s: invalid private key", file)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: private key isn't an RSA key", file)
+	}
+	a.key = key
+
+	return &a, nil
+}
+
+func (f *gcpFirewall) Apply(ctx context.Context, ip net.IP) error {
+	endpoint := fmt.Sprintf("https://compute.googleapis.com/compute/v1/projects/%s/global/firewalls/%s",
+		url.PathEscape(f.project), url.PathEscape(f.rule))
+
+	var rule struct {
+		SourceRanges []string `json:"sourceRanges"`
+	}
+	err := f.do(ctx, "GET", endpoint, nil, &rule)
+	if err != nil {
+		return err
+	}
+
+	cidr := ip.String() + "/128"
+	if ip.To4() != nil {
+		cidr = ip.String() + "/32"
+	}
+
+	// Keep the ranges of the other family, replacing those of this one
+	ranges := []string{cidr}
+	for _, r := range rule.SourceRanges {
+		rangeIP, _, err := net.ParseCIDR(r)
+		if err != nil {
+			rangeIP = net.ParseIP(r)
+		}
+		if rangeIP == nil || (rangeIP.To4() != nil) != (ip.To4() != nil) {
+			ranges = append(ranges, r)
+		}
+	}
+	if len(ranges) == len(rule.SourceRanges) && containsString(rule.SourceRanges, cidr) {
+		return nil
+	}
+
+	// The patch is an asynchronous operation, which fails only on invalid
+	// rules and is applied within seconds
+	body := map[string]interface{}{"sourceRanges": ranges}
+	return f.do(ctx, "PATCH", endpoint, body, nil)
+}
+
+func (f *gcpFirewall) do(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	token, err := f.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := newJSONRequest(method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	err = httpJSON(ctx, req, out)
+	if err != nil {
+		return fmt.Errorf("gcpFirewall: %v", err)
+	}
+	return nil
+}
+
+type gcpToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// accessToken returns a cached OAuth access token, requesting a new one when
+// it is about to expire
+func (f *gcpFirewall) accessToken(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.token != "" && time.Until(f.expires) > 5*time.Minute {
+		return f.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	if f.account != nil {
+		// Service account key, exchanging a signed JWT for a token
+		assertion, err := f.account.assertion(time.Now())
+		if err != nil {
+			return "", fmt.Errorf("gcpFirewall: %v", err)
+		}
+
+		form := url.Values{}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+
+		req, err = http.NewRequest("POST", f.account.TokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		// Service account of the instance, from the metadata server
+		req, err = http.NewRequest("GET",
+			"http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	}
+
+	var t gcpToken
+	err = httpJSON(ctx, req, &t)
+	if err != nil {
+		return "", fmt.Errorf("gcpFirewall: authentication failed: %v", err)
+	}
+
+	f.token = t.AccessToken
+	f.expires = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+
+	return f.token, nil
+}
+
+// assertion returns a JWT signed with the account's key, requesting access to
+// the Compute Engine API for an hour
+func (a *gcpServiceAccount) assertion(now time.Time) (string, error) {
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	claims := map[string]interface{}{
+		"iss":   a.ClientEmail,
+		"scope": gcpComputeScope,
+		"aud":   a.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+
+	var parts []string
+	for _, v := range []interface{}{header, claims} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, base64.RawURLEncoding.EncodeToString(data))
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, ".")))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(parts, ".") + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func init() {
+	registerAction("gcpFirewall", newGCPFirewall)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+// wireguard points the endpoint of a WireGuard peer at the public IP, for
+// tunnels referencing the dynamic address directly. The interface is
+// configured with the wg tool, which needs the privileges to do so.
+type wireguard struct {
+	command string
+	iface   string
+	peer    string
+	port    int
+}
+
+func newWireGuard(key string) (Action, error) {
+	w := &wireguard{
+		command: viper.GetString(key + ".command"),
+		iface:   viper.GetString(key + ".interface"),
+		peer:    viper.GetString(key + ".peer"),
+		port:    viper.GetInt(key + ".port"),
+	}
+	if w.iface == "" || w.peer == "" {
+		return nil, fmt.Errorf("wireguard: %s.interface and %s.peer are required", key, key)
+	}
+	if w.port == 0 {
+		w.port = 51820
+	}
+	if w.command == "" {
+		w.command = "wg"
+	}
+	return w, nil
+}
+
+func (w *wireguard) Apply(ctx context.Context, ip net.IP) error {
+	endpoint := net.JoinHostPort(ip.String(), strconv.Itoa(w.port))
+	_, err := execCommand(ctx, w.command, []string{"set", w.iface, "peer", w.peer, "endpoint", endpoint}, nil, nil)
+	if err != nil {
+		return fmt.Errorf("wireguard: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	registerAction("wireguard", newWireGuard)
+}
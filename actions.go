@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Action is implemented by integrations applying the public IP outside of
+// DNS, e.g. to tunnel endpoints or firewall rules. Actions must be
+// idempotent, as they are applied again after restarts.
+type Action interface {
+	Apply(ctx context.Context, ip net.IP) error
+}
+
+// actions holds the constructors of every available action by name. Each
+// constructor reads its settings from the configuration section under key.
+var actions = map[string]func(key string) (Action, error){}
+
+func registerAction(name string, fn func(key string) (Action, error)) {
+	actions[name] = fn
+}
+
+// namedAction is a configured action
+type namedAction struct {
+	name   string
+	action Action
+}
+
+// newActions constructs every action with a section under actions
+func newActions() ([]namedAction, error) {
+	var names []string
+	for n := range actions {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var as []namedAction
+	for _, name := range names {
+		key := "actions." + name
+		if !viper.IsSet(key) {
+			continue
+		}
+
+		a, err := actions[name](key)
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, namedAction{name: name, action: a})
+	}
+
+	return as, nil
+}
+
+// appliedIPs remembers the IP each action last applied successfully, by
+// action and record type
+type appliedIPs struct {
+	mu  sync.Mutex
+	ips map[string]string
+}
+
+// applyActions applies ip to every action which didn't apply it yet. Failed
+// actions are logged, and tried again on the next check.
+func (d *daemon) applyActions(ctx context.Context, t string, ip net.IP) {
+	for _, a := range d.actions {
+		key := a.name + "/" + t
+
+		d.applied.mu.Lock()
+		done := d.applied.ips[key] == ip.String()
+		d.applied.mu.Unlock()
+		if done {
+			continue
+		}
+
+		if d.dryRun {
+			log.Infof("dry-run: would apply %s to %s", ip, a.name)
+			continue
+		}
+
+		err := a.action.Apply(ctx, ip)
+		if err != nil {
+			log.Errorf("%s: unable to apply %s: %v", a.name, ip, err)
+			continue
+		}
+		log.Infof("%s: applied %s", a.name, ip)
+
+		d.applied.mu.Lock()
+		d.applied.ips[key] = ip.String()
+		d.applied.mu.Unlock()
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// agentClient reports the public IPs detected by an agent to the dyn server
+// at agent.server, which updates the records assigned to the agent with its
+// own provider credentials. Sites then only hold the agent's token.
+type agentClient struct {
+	server string
+	token  string
+	types  []string
+	client *http.Client
+}
+
+// agentReport is the body of the requests agents send to the server
+type agentReport struct {
+	IPv4 string `json:"ipv4,omitempty"`
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
+// newAgentClient returns the client of the server under agent.server, nil
+// when not running as an agent
+func newAgentClient() (*agentClient, error) {
+	server := strings.TrimSuffix(viper.GetString("agent.server"), "/")
+	if server == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(server, "https://") && !viper.GetBool("agent.insecure") {
+		return nil, fmt.Errorf("agent: agent.server must be an https URL, the token would be sent in clear text")
+	}
+
+	a := &agentClient{
+		server: server,
+		token:  viper.GetString("agent.token"),
+		client: &http.Client{Timeout: time.Minute},
+	}
+	if a.token == "" {
+		return nil, fmt.Errorf("agent: missing agent.token")
+	}
+
+	// The address families reported, dns.mode by default
+	mode := viper.GetString("agent.mode")
+	if mode == "" {
+		mode = viper.GetString("dns.mode")
+	}
+	types, err := recordTypes(mode)
+	if err != nil {
+		return nil, fmt.Errorf("agent: %v", err)
+	}
+	a.types = types
+
+	if file := viper.GetString("agent.caFile"); file != "" {
+		transport, err := caTransport(file)
+		if err != nil {
+			return nil, fmt.Errorf("agent: %v", err)
+		}
+		a.client.Transport = transport
+	}
+
+	return a, nil
+}
+
+// report detects the public IPs and sends them to the server, in place of
+// syncing records. Actions still run locally.
+func (d *daemon) report(ctx context.Context) error {
+	var report agentReport
+	var detectErrs []string
+	for _, t := range d.agent.types {
+		dIP, err := d.detectIP(ctx, t)
+		if err != nil {
+			detectErrs = append(detectErrs, fmt.Sprintf("%s: %v", t, err))
+			continue
+		}
+		if dIP == nil {
+			continue
+		}
+
+		if t == "A" {
+			report.IPv4 = dIP.String()
+		} else {
+			report.IPv6 = dIP.String()
+		}
+		d.applyActions(ctx, t, dIP)
+	}
+	if report.IPv4 == "" && report.IPv6 == "" {
+		return cycleError(detectErrs, 0, 0)
+	}
+
+	if d.dryRun {
+		log.Infof("agent: dry run, not reporting %+v", report)
+		return cycleError(detectErrs, 0, 0)
+	}
+
+	req, err := newJSONRequest("POST", d.agent.server+"/report", report)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.agent.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := d.agent.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("agent: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Records int    `json:"records"`
+		Error   string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK {
+		if result.Error == "" {
+			result.Error = resp.Status
+		}
+		return fmt.Errorf("agent: server: %s", result.Error)
+	}
+	if err != nil {
+		return fmt.Errorf("agent: %v", err)
+	}
+
+	log.Debugf("agent: reported %+v, %d records in sync", report, result.Records)
+	return cycleError(detectErrs, 0, 0)
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// daemonStatus is the state of a running daemon, as served by the API
+type daemonStatus struct {
+	IPs       map[string]*savedIP `json:"ips"`
+	Records   []*savedRecord      `json:"records"`
+	LastSync  *time.Time          `json:"lastSync,omitempty"`
+	LastError string              `json:"lastError,omitempty"`
+	NextCheck *time.Time          `json:"nextCheck,omitempty"`
+	Paused    bool                `json:"paused"`
+	IPChanges int                 `json:"ipChanges"` // within the flapping window
+	Flapping  bool                `json:"flapping"`
+	CGNAT     *cgnatReport        `json:"cgnat,omitempty"` // when cgnat.check is set
+}
+
+// control lets the API steer the run loop
+type control struct {
+	sync   chan struct{}     // requests an immediate cycle
+	setIP  chan setIPRequest // pushes an address to the records
+	report chan agentRequest // pushes an address reported by an agent
+
+	mu     sync.Mutex
+	paused bool
+}
+
+// setIPRequest asks the run loop to push ip, replying with the outcome
+type setIPRequest struct {
+	ip   net.IP
+	done chan error
+}
+
+func newControl() *control {
+	return &control{
+		sync:   make(chan struct{}, 1),
+		setIP:  make(chan setIPRequest),
+		report: make(chan agentRequest),
+	}
+}
+
+// requestSync asks the run loop for a cycle, coalescing pending requests
+func (c *control) requestSync() {
+	select {
+	case c.sync <- struct{}{}:
+	default:
+	}
+}
+
+func (c *control) setPaused(paused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = paused
+}
+
+func (c *control) isPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.paused
+}
+
+// currentStatus collects the status of the daemon from its state and health
+func currentStatus(ctl *control) daemonStatus {
+	s := state.snapshot()
+	status := daemonStatus{
+		IPs:      s.IPs,
+		LastSync: s.LastSync,
+		Paused:   ctl.isPaused(),
+		Flapping: s.Flapping,
+		CGNAT:    cgnat.get(),
+	}
+	cutoff := time.Now().Add(-flappingWindow())
+	for _, t := range s.Changes {
+		if t.After(cutoff) {
+			status.IPChanges++
+		}
+	}
+	for _, r := range s.Records {
+		status.Records = append(status.Records, r)
+	}
+	sortSavedRecords(status.Records)
+
+	health.mu.RLock()
+	if health.lastErr != nil {
+		status.LastError = health.lastErr.Error()
+	}
+	if !health.nextCheck.IsZero() {
+		next := health.nextCheck
+		status.NextCheck = &next
+	}
+	health.mu.RUnlock()
+
+	return status
+}
+
+// apiNetwork splits an API address into its network and address. Addresses
+// starting with "unix:" are unix socket paths, others are TCP addresses.
+func apiNetwork(addr string) (string, string) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "unix", strings.TrimPrefix(addr, "unix:")
+	}
+	return "tcp", addr
+}
+
+// serveAPI serves the local API on addr until ctx is done. Requests must
+// carry api.token as a bearer token, which is required on TCP addresses.
+//
+//	GET  /status  status of the daemon and every record
+//	GET  /config  effective configuration, with secrets redacted
+//	POST /sync    run a cycle right away
+//	POST /pause   stop updating records until resumed
+//	POST /resume  resume updating records
+//	POST /ip      push the address in the ip parameter to the records
+func serveAPI(ctx context.Context, addr string, ctl *control) error {
+	token := viper.GetString("api.token")
+	network, address := apiNetwork(addr)
+	if network == "tcp" && token == "" {
+		return fmt.Errorf("api: api.token is required when listening on a TCP address")
+	}
+	if network == "unix" {
+		// Remove a socket left behind by a previous run
+		os.Remove(address)
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("api: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, currentStatus(ctl))
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, redactSettings(viper.AllSettings()))
+	})
+	mux.HandleFunc("/sync", apiPost(func() {
+		log.Info("api: sync requested")
+		ctl.requestSync()
+	}))
+	mux.HandleFunc("/pause", apiPost(func() {
+		log.Info("api: updates paused")
+		ctl.setPaused(true)
+	}))
+	mux.HandleFunc("/resume", apiPost(func() {
+		log.Info("api: updates resumed")
+		ctl.setPaused(false)
+		ctl.requestSync()
+	}))
+
+	mux.HandleFunc("/ip", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		ip := net.ParseIP(r.FormValue("ip"))
+		if ip == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid or missing ip"})
+			return
+		}
+
+		req := setIPRequest{ip: ip, done: make(chan error, 1)}
+		select {
+		case ctl.setIP <- req:
+		case <-r.Context().Done():
+			return
+		}
+
+		err := <-req.done
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "updated", "ip": ip.String()})
+	})
+
+	srv := &http.Server{Handler: apiAuth(token, mux)}
+	closeWhenDone(ctx, srv)
+
+	go func() {
+		log.Infof("api: listening on %s", addr)
+		err := srv.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("api: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// apiAuth rejects requests without the bearer token, unless token is empty
+func apiAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiPost handles an action, which must be requested with POST
+func apiPost(action func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		action()
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+	}
+}
+
+// redactSettings replaces the values of settings which look like secrets,
+// and the URLs of notifiers and pings, which carry their tokens in the path or
+// query
+func redactSettings(settings map[string]interface{}) map[string]interface{} {
+	return redactMap(settings, "")
+}
+
+func redactMap(settings map[string]interface{}, path string) map[string]interface{} {
+	out := make(map[string]interface{}, len(settings))
+	for k, v := range settings {
+		out[k] = redactValue(v, path+k)
+	}
+	return out
+}
+
+// redactValue redacts the setting at path, recursing into sections and
+// lists. Lists take the path of their key.
+func redactValue(v interface{}, path string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return redactMap(v, path+".")
+	case map[interface{}]interface{}:
+		// Sections within lists are decoded with keys of any type
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = e
+		}
+		return redactMap(m, path+".")
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = redactValue(e, path)
+		}
+		return out
+	case []string:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = redactValue(e, path)
+		}
+		return out
+	}
+
+	if v == "" || v == nil {
+		return v
+	}
+	if secretSetting(path) {
+		return "REDACTED"
+	}
+	if s, ok := v.(string); ok {
+		return redactURL(s)
+	}
+	return v
+}
+
+// secretWords mark the keys of settings holding secrets
+var secretWords = []string{"secret", "password", "passwd", "token", "key", "credential", "auth"}
+
+// secretSetting reports whether the setting at path holds a secret
+func secretSetting(path string) bool {
+	path = strings.ToLower(path)
+	key := path[strings.LastIndex(path, ".")+1:]
+	for _, s := range secretWords {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+
+	// Headers sent to APIs and webhooks often carry credentials, e.g. an
+	// Authorization bearer token or a gateway key
+	if strings.Contains("."+path, ".headers.") {
+		return true
+	}
+
+	// Webhooks, ping URLs and ntfy topics are credentials themselves
+	if strings.HasPrefix(path, "notify.") || strings.HasPrefix(path, "ping.") {
+		switch key {
+		case "url", "webhook", "topic":
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL replaces the password of URLs carrying credentials, e.g. the
+// update URL of dyndns2 providers
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	return u.String()
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// apiRequest calls the API of a running daemon at addr, decoding the JSON
+// response into out
+func apiRequest(ctx context.Context, addr, method, path string, out interface{}) error {
+	network, address := apiNetwork(addr)
+	base := "http://" + address
+	if network == "unix" {
+		base = "http://dyn"
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, address)
+			},
+		},
+	}
+
+	req, err := http.NewRequest(method, base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token := viper.GetString("api.token"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("daemon not reachable at %s: %v", addr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("daemon returned %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// remoteStatus prints the status of the daemon serving the API at addr
+func remoteStatus(addr string, asJSON bool) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var status daemonStatus
+	err := apiRequest(ctx, addr, "GET", "/status", &status)
+	if err != nil {
+		log.Error(err)
+		return exitConfigError
+	}
+
+	code := exitOK
+	for _, r := range status.Records {
+		if r.Error != "" {
+			code = exitSyncFailed
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(status)
+		return code
+	}
+
+	for _, t := range []string{"A", "AAAA"} {
+		if ip, ok := status.IPs[t]; ok {
+			fmt.Printf("Public IP (%s):\t%s, unchanged since %s\n", t, ip.IP, ip.Since.Format(time.RFC3339))
+		}
+	}
+	if status.LastSync != nil {
+		fmt.Printf("Last sync:\t%s\n", status.LastSync.Format(time.RFC3339))
+	}
+	if status.NextCheck != nil {
+		fmt.Printf("Next check:\t%s\n", status.NextCheck.Format(time.RFC3339))
+	}
+	if status.Paused {
+		fmt.Printf("Updates:\tpaused\n")
+	}
+	if status.Flapping {
+		fmt.Printf("Flapping:\t%d IP changes recently\n", status.IPChanges)
+	}
+	if c := status.CGNAT; c != nil && c.Behind {
+		fmt.Printf("CGNAT:\tlikely, router WAN IP %s differs from public IP %s\n", c.RouterIP, c.PublicIP)
+	}
+	if status.LastError != "" {
+		fmt.Printf("Last error:\t%s\n", status.LastError)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RECORD\tTYPE\tCONTENT\tCHECKED\tSTATE")
+	for _, r := range status.Records {
+		state := "in sync"
+		if r.Error != "" {
+			state = "error: " + r.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Name, r.Type, r.Content, r.Checked.Format(time.RFC3339), state)
+	}
+	w.Flush()
+
+	return code
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/ianmuscat/dyn/internal/awsauth"
+	"github.com/spf13/viper"
+)
+
+// AWS credentials are resolved and requests signed by internal/awsauth,
+// shared with the Route 53 provider
+type awsCredentials = awsauth.Credentials
+
+var signV4 = awsauth.SignV4
+
+// newAWSCredentials returns the chain resolving AWS credentials, starting
+// with the ones explicitly set under key
+func newAWSCredentials(key string) *awsauth.Chain {
+	return awsauth.NewChain(awsCredentials{
+		AccessKeyID:     viper.GetString(key + ".accessKeyId"),
+		SecretAccessKey: viper.GetString(key + ".secretAccessKey"),
+		SessionToken:    viper.GetString(key + ".sessionToken"),
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ianmuscat/dyn/internal/awsauth"
+	"github.com/spf13/viper"
+)
+
+var (
+	awsSecretsOnce  sync.Once
+	awsSecretsChain *awsauth.Chain
+)
+
+// awsSecretsCreds returns the chain resolving the credentials used to read
+// secrets, explicitly set under aws or from the standard chain. It's created
+// on first use, once the configuration is loaded.
+func awsSecretsCreds() *awsauth.Chain {
+	awsSecretsOnce.Do(func() {
+		awsSecretsChain = newAWSCredentials("aws")
+	})
+	return awsSecretsChain
+}
+
+// awsRegion returns the region of an ARN, or the configured region
+func awsRegion(ref string) (string, error) {
+	if parts := strings.Split(ref, ":"); len(parts) > 3 && parts[0] == "arn" {
+		return parts[3], nil
+	}
+
+	for _, region := range []string{viper.GetString("aws.region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if region != "" {
+			return region, nil
+		}
+	}
+	return "", fmt.Errorf("missing aws.region")
+}
+
+// awsJSONCall calls an action of an AWS JSON 1.1 API, such as Secrets
+// Manager and SSM
+func awsJSONCall(ctx context.Context, region, service, target string, in, out interface{}) error {
+	creds, err := awsSecretsCreds().Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s.%s.amazonaws.com/", service, region), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	signV4(req, body, creds, region, service, time.Now())
+
+	data, err := httpDo(ctx, req)
+	if e, ok := err.(*httpError); ok {
+		var awsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal([]byte(e.Body), &awsErr) == nil && awsErr.Type != "" {
+			return fmt.Errorf("%s: %s", awsErr.Type[strings.LastIndex(awsErr.Type, "#")+1:], awsErr.Message)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+// readSecretsManager reads a Secrets Manager secret referenced by name or ARN.
+// Secrets holding JSON key/value pairs are referenced as <id>#<key>.
+func readSecretsManager(ctx context.Context, ref string) (string, time.Duration, error) {
+	id, field := ref, ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		id, field = ref[:i], ref[i+1:]
+	}
+
+	region, err := awsRegion(id)
+	if err != nil {
+		return "", 0, fmt.Errorf("secretsmanager: %v", err)
+	}
+
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	err = awsJSONCall(ctx, region, "secretsmanager", "secretsmanager.GetSecretValue", map[string]string{"SecretId": id}, &resp)
+	if err != nil {
+		return "", 0, fmt.Errorf("secretsmanager: %v", err)
+	}
+
+	if field == "" {
+		return resp.SecretString, 0, nil
+	}
+
+	var values map[string]interface{}
+	err = json.Unmarshal([]byte(resp.SecretString), &values)
+	if err != nil {
+		return "", 0, fmt.Errorf("secretsmanager: secret %s is not a JSON object", id)
+	}
+	value, ok := values[field]
+	if !ok {
+		return "", 0, fmt.Errorf("secretsmanager: secret %s has no key %s", id, field)
+	}
+	return fmt.Sprint(value), 0, nil
+}
+
+// readSSMParameter reads an SSM Parameter Store parameter referenced by name
+// or ARN, decrypting SecureString parameters
+func readSSMParameter(ctx context.Context, ref string) (string, time.Duration, error) {
+	region, err := awsRegion(ref)
+	if err != nil {
+		return "", 0, fmt.Errorf("ssm: %v", err)
+	}
+
+	var resp struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	err = awsJSONCall(ctx, region, "ssm", "AmazonSSM.GetParameter", map[string]interface{}{"Name": ref, "WithDecryption": true}, &resp)
+	if err != nil {
+		return "", 0, fmt.Errorf("ssm: %v", err)
+	}
+
+	return resp.Parameter.Value, 0, nil
+}
+
+func init() {
+	registerSecretSource("secretsmanager", readSecretsManager)
+	registerSecretSource("ssm", readSSMParameter)
+}
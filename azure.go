@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+func newAzure(key string) (Provider, error) {
+	opts := provider.AzureOptions{
+		SubscriptionID: viper.GetString(key + ".subscriptionId"),
+		ResourceGroup:  viper.GetString(key + ".resourceGroup"),
+		TenantID:       viper.GetString(key + ".tenantId"),
+		ClientID:       viper.GetString(key + ".clientId"),
+		ClientSecret:   viper.GetString(key + ".clientSecret"),
+	}
+	if opts.SubscriptionID == "" || opts.ResourceGroup == "" {
+		return nil, fmt.Errorf("azure: %s.subscriptionId and %s.resourceGroup are required", key, key)
+	}
+	if opts.ClientSecret != "" && (opts.TenantID == "" || opts.ClientID == "") {
+		return nil, fmt.Errorf("azure: service principal authentication requires %s.tenantId and %s.clientId", key, key)
+	}
+
+	return provider.NewAzure(opts)
+}
+
+func init() {
+	registerProvider("azure", newAzure)
+}
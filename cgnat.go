@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/ianmuscat/dyn/pkg/detect"
+)
+
+// cgnatStatus records the outcome of the latest CGNAT check
+type cgnatStatus struct {
+	mu       sync.RWMutex
+	checked  bool
+	behind   bool
+	routerIP net.IP
+	publicIP net.IP
+}
+
+var cgnat cgnatStatus
+
+func (s *cgnatStatus) set(behind bool, routerIP, publicIP net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checked = true
+	s.behind = behind
+	s.routerIP = routerIP
+	s.publicIP = publicIP
+}
+
+// cgnatReport is the outcome of the latest CGNAT check in the status
+type cgnatReport struct {
+	Behind   bool   `json:"behind"`
+	RouterIP string `json:"routerIP"`
+	PublicIP string `json:"publicIP"`
+}
+
+// get returns the outcome of the latest check, nil when none has been made
+func (s *cgnatStatus) get() *cgnatReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.checked {
+		return nil
+	}
+	return &cgnatReport{Behind: s.behind, RouterIP: s.routerIP.String(), PublicIP: s.publicIP.String()}
+}
+
+// checkCGNAT asks the router for its WAN address and compares it with the
+// externally observed public IP. When they differ, there is another layer of
+// NAT between the router and the Internet, typically carrier-grade NAT, and
+// the public IP is not reachable from outside.
+func checkCGNAT(ctx context.Context, publicIP net.IP, method, gateway string) (bool, net.IP, error) {
+	routerIP, err := detect.RouterWANIP(ctx, method, gateway)
+	if err != nil {
+		return false, nil, err
+	}
+
+	behind := !routerIP.Equal(publicIP)
+	cgnat.set(behind, routerIP, publicIP)
+
+	return behind, routerIP, nil
+}
@@ -0,0 +1,692 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Build information, set by the release process
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+type command struct {
+	name  string
+	usage string
+	flags func(fs *flag.FlagSet)
+	run   func(fs *flag.FlagSet) int
+}
+
+var commands = []command{
+	{
+		name:  "run",
+		usage: "Run the daemon, syncing records on every tick (default)",
+		flags: func(fs *flag.FlagSet) {
+			fs.Bool("dry-run", false, "detect and compare, logging changes instead of applying them")
+			fs.Bool("once", false, "run a single cycle and exit, like the sync command")
+		},
+		run: runCommand,
+	},
+	{
+		name:  "sync",
+		usage: "Run a single detection and sync cycle, then exit",
+		flags: func(fs *flag.FlagSet) {
+			fs.Bool("dry-run", false, "detect and compare, logging changes instead of applying them")
+		},
+		run: syncCommand,
+	},
+	{
+		name:  "status",
+		usage: "Show the public IP and the state of every record",
+		flags: func(fs *flag.FlagSet) {
+			fs.Bool("json", false, "print the status of the running daemon as JSON")
+			fs.Bool("local", false, "check the records directly instead of asking the running daemon")
+		},
+		run: statusCommand,
+	},
+	{
+		name:  "serve",
+		usage: "Accept dyndns2 updates from routers and apply them to the records",
+		run:   serveCommand,
+	},
+	{
+		name:  "echo-server",
+		usage: "Serve the address of each caller, for use with the http detector",
+		flags: func(fs *flag.FlagSet) {
+			fs.String("listen", "", "address to listen on (default :8080, or :8443 with TLS)")
+			fs.String("cert", "", "TLS certificate file")
+			fs.String("key", "", "TLS key file")
+		},
+		run: echoCommand,
+	},
+	{
+		name:  "set-ip",
+		usage: "Push the given address to every record, skipping detection",
+		run:   setIPCommand,
+	},
+	{
+		name:  "history",
+		usage: "Show the history of IP changes and record updates",
+		flags: func(fs *flag.FlagSet) {
+			fs.String("format", "table", "output format: table, json or csv")
+			fs.Duration("since", 0, "only show entries newer than this, e.g. 168h")
+			fs.String("name", "", "only show entries of this record")
+		},
+		run: historyCommand,
+	},
+	{
+		name:  "service",
+		usage: "Install, uninstall, start or stop the Windows service",
+		run:   serviceCommand,
+	},
+	{
+		name:  "validate",
+		usage: "Validate the configuration without performing any updates",
+		run:   validateCommand,
+	},
+	{
+		name:  "version",
+		usage: "Print version information",
+		run:   versionCommand,
+	},
+}
+
+// globalFlags are accepted by every command and override the matching
+// configuration keys
+func globalFlags(fs *flag.FlagSet) {
+	fs.StringP("config", "c", "", "configuration file (default searches /etc/dyn, $HOME/.dyn and .)")
+	fs.String("provider", "", "DNS provider")
+	fs.String("detector", "", "public IP detector type")
+	fs.String("mode", "", "IP address families to sync: ipv4, ipv6 or dual")
+	fs.String("tick", "", "interval between checks, e.g. 5m")
+	fs.String("zone", "", "DNS zone")
+	fs.StringSlice("record", nil, "record names within the zone, may be repeated")
+	fs.String("cycle-timeout", "", "time limit of each detection and sync cycle, e.g. 2m")
+	fs.String("state-file", "", "file keeping the state and history across restarts")
+	fs.String("log-level", "", "log level: debug, info, warn or error")
+	fs.String("log-format", "", "log format: text or json")
+	fs.StringArray("set", nil, "set any configuration key, e.g. --set cloudflare.apikey=KEY; may be repeated, and a key given several times is set to the list of its values")
+}
+
+// flagKeys maps global flags onto configuration keys. Keys without a flag of
+// their own are set with --set.
+var flagKeys = map[string]string{
+	"provider":      "provider",
+	"detector":      "detector.type",
+	"mode":          "dns.mode",
+	"tick":          "tick",
+	"zone":          "dns.zone",
+	"record":        "dns.records",
+	"cycle-timeout": "cycleTimeout",
+	"state-file":    "state.file",
+	"log-level":     "log.level",
+	"log-format":    "log.format",
+}
+
+func runCLI(args []string) int {
+	// Default to the run command, so existing invocations keep working
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "--help") {
+		args = append([]string{"run"}, args...)
+	}
+
+	code := exitOK
+	root := newRootCommand(&code)
+	root.SetArgs(args)
+
+	err := root.Execute()
+	if err == errConfiguration {
+		return exitConfigError
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\nRun 'dyn --help' for usage.\n", err)
+		return exitConfigError
+	}
+	return code
+}
+
+// errConfiguration stops the command line once a configuration error has been
+// logged
+var errConfiguration = errors.New("invalid configuration")
+
+// newRootCommand returns the command line of dyn, with a subcommand for each
+// command. The exit code of the command which ran is stored in code.
+func newRootCommand(code *int) *cobra.Command {
+	root := &cobra.Command{
+		Use:           "dyn",
+		Short:         "Keep DNS records pointed at the public IP address",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.Name() == "version" {
+				return nil
+			}
+			err := configure(cmd.Flags())
+			if err != nil {
+				log.Errorf("configuration: %v", err)
+				return errConfiguration
+			}
+			return nil
+		},
+	}
+	globalFlags(root.PersistentFlags())
+
+	for _, c := range commands {
+		run := c.run
+		sub := &cobra.Command{
+			Use:   c.name,
+			Short: c.usage,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				*code = run(cmd.Flags())
+				return nil
+			},
+		}
+		if c.flags != nil {
+			c.flags(sub.Flags())
+		}
+		root.AddCommand(sub)
+	}
+
+	return root
+}
+
+// configure loads the configuration, overriding it with the flags
+func configure(fs *flag.FlagSet) error {
+	// Flags take precedence over the configuration file. They are bound
+	// first, so those affecting the logging and secrets apply while loading.
+	for name, key := range flagKeys {
+		if fs.Changed(name) {
+			viper.BindPFlag(key, fs.Lookup(name))
+		}
+	}
+
+	overrides, _ := fs.GetStringArray("set")
+	err := setOverrides(overrides)
+	if err != nil {
+		return err
+	}
+
+	path, _ := fs.GetString("config")
+	return loadConfig(path)
+}
+
+// cliOverrides are the settings given with --set, by lower-case key
+var cliOverrides = map[string]interface{}{}
+
+// setOverrides sets the configuration keys given as key=value. Values are
+// kept as strings, and converted by the configuration getters like those of
+// environment variables.
+func setOverrides(overrides []string) error {
+	values := map[string][]string{}
+	for _, o := range overrides {
+		i := strings.Index(o, "=")
+		if i <= 0 {
+			return fmt.Errorf("--set %s: expected key=value", o)
+		}
+		key := o[:i]
+		values[key] = append(values[key], o[i+1:])
+	}
+
+	for key, v := range values {
+		var value interface{} = v
+		if len(v) == 1 {
+			value = v[0]
+		}
+		cliOverrides[strings.ToLower(key)] = value
+		viper.Set(key, value)
+	}
+	return nil
+}
+
+func newConfiguredDaemon(fs *flag.FlagSet) (*daemon, bool) {
+	d, err := newDaemon()
+	if err != nil {
+		log.Errorf("configuration: %v", err)
+		return nil, false
+	}
+
+	err = state.open(viper.GetString("state.file"))
+	if err != nil {
+		log.Errorf("state: %v", err)
+		return nil, false
+	}
+
+	if f := fs.Lookup("dry-run"); f != nil {
+		d.dryRun, _ = fs.GetBool("dry-run")
+	}
+
+	return d, true
+}
+
+// serviceStop is closed when the Windows service manager stops the service
+var serviceStop = make(chan struct{})
+
+// shutdownContext returns a context which is cancelled on SIGINT or SIGTERM,
+// or when the service is stopped
+func shutdownContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-term:
+			log.Infof("received %s, shutting down", sig)
+		case <-serviceStop:
+			log.Info("service stopping")
+		case <-ctx.Done():
+		}
+		// A second signal kills the process as usual
+		signal.Stop(term)
+		cancel()
+	}()
+
+	return ctx, cancel
+}
+
+func runCommand(fs *flag.FlagSet) int {
+	if once, _ := fs.GetBool("once"); once {
+		return syncCommand(fs)
+	}
+
+	d, ok := newConfiguredDaemon(fs)
+	if !ok {
+		return exitConfigError
+	}
+
+	sched, err := newSchedule()
+	if err != nil {
+		log.Errorf("configuration: %v", err)
+		return exitConfigError
+	}
+
+	// Fail fast on credentials which can't manage the zones, rather than on
+	// every check
+	vctx, vcancel := context.WithTimeout(context.Background(), time.Minute)
+	err = d.verifyCredentials(vctx)
+	if err != nil {
+		vcancel()
+		log.Errorf("provider: %v", err)
+		return exitConfigError
+	}
+	if d.agent == nil && (!viper.IsSet("preflight") || viper.GetBool("preflight")) {
+		d.preflight(vctx)
+	}
+	vcancel()
+
+	reload := make(chan struct{}, 1)
+	watchConfig(reload)
+
+	// Stop on SIGINT or SIGTERM, letting the current cycle wind down through
+	// its context rather than being killed halfway through an update
+	ctx, cancel := shutdownContext()
+	defer cancel()
+	watchSecrets(ctx, reload)
+
+	// SIGUSR1 runs a cycle right away, for hooks such as pppd's ip-up
+	ctl := newControl()
+	rateLimitResync = ctl.requestSync
+	usr1 := make(chan os.Signal, 1)
+	notifySyncSignal(usr1)
+	go func() {
+		for range usr1 {
+			log.Info("received SIGUSR1, syncing")
+			ctl.requestSync()
+		}
+	}()
+
+	// Servers and watchers follow the configuration and the daemon, and are
+	// started again on reload
+	lead, err := newLeadership()
+	if err != nil {
+		log.Errorf("configuration: %v", err)
+		return exitConfigError
+	}
+	svc, err := startServices(ctx, d, sched, ctl, lead)
+	defer func() {
+		cancel()
+		svc.stop()
+	}()
+	if err != nil {
+		log.Error(err)
+		return exitConfigError
+	}
+
+	// Transient detection and API errors are retried on the next tick, until
+	// too many cycles in a row have failed
+	code, failures := exitOK, 0
+	var failingSince time.Time
+	runCycle := func() bool {
+		if ctl.isPaused() {
+			log.Info("updates are paused, skipping cycle")
+			return true
+		}
+		if !lead.isLeader() {
+			log.Debug("leader: standing by, skipping cycle")
+			return true
+		}
+
+		started := time.Now()
+		err := d.cycle(ctx)
+		if ctx.Err() != nil {
+			return false
+		}
+		if err == nil {
+			sdNotify("STATUS=Last cycle succeeded at " + time.Now().Format(time.RFC3339))
+		} else {
+			sdNotify("STATUS=Last cycle failed: " + err.Error())
+		}
+		if err == nil {
+			if failures > 0 {
+				log.Infof("recovered after %d failed cycles", failures)
+			}
+			failures = 0
+			return true
+		}
+
+		if failures == 0 {
+			failingSince = started
+		}
+		failures++
+		log.Errorf("cycle failed (%d in a row): %v", failures, err)
+		if failures == viper.GetInt("notify.failures") {
+			since := failingSince
+			notify(d.notifiers, event{Type: eventSyncFailed, Error: err.Error(), Since: &since})
+		}
+		threshold := viper.GetInt("failures.threshold")
+		if threshold <= 0 || failures < threshold {
+			return true
+		}
+
+		if viper.GetString("failures.action") == "exit" {
+			log.Errorf("giving up after %d failed cycles", failures)
+			code = exitCode(err)
+			return false
+		}
+		if failures == threshold {
+			log.Errorf("ALERT: %d cycles in a row have failed: %v", failures, err)
+		}
+		return true
+	}
+
+	// Let systemd know the daemon is up, and keep its watchdog fed from the
+	// loop, so a wedged loop gets restarted. WatchdogSec must exceed the
+	// cycle timeout.
+	sdNotify("READY=1")
+	var watchdog <-chan time.Time
+	if interval := sdWatchdog(); interval > 0 {
+		watchdogTicker := time.NewTicker(interval)
+		defer watchdogTicker.Stop()
+		watchdog = watchdogTicker.C
+	}
+
+	// Sync right away instead of waiting a full interval, unless configured
+	// otherwise
+	if viper.GetBool("syncOnStartup") && !runCycle() && ctx.Err() == nil {
+		return code
+	}
+
+	log.Infof("checking %s", sched)
+	next := sched.next(time.Now())
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	health.scheduled(next)
+	for {
+		select {
+		case <-ctx.Done():
+			sdNotify("STOPPING=1")
+			log.Info("stopped")
+			return code
+
+		case <-timer.C:
+			if !runCycle() {
+				return code
+			}
+			next = sched.next(time.Now())
+			timer.Reset(time.Until(next))
+			health.scheduled(next)
+
+		case <-watchdog:
+			sdNotify("WATCHDOG=1")
+
+		case <-ctl.sync:
+			if !runCycle() {
+				return code
+			}
+
+		case req := <-ctl.setIP:
+			log.Infof("api: setting IP to %s", req.ip)
+			if lead.isLeader() {
+				req.done <- d.setIP(ctx, req.ip)
+			} else {
+				req.done <- fmt.Errorf("standing by, another instance is the leader")
+			}
+
+		case req := <-ctl.report:
+			log.Debugf("server: agent %s reported %s", req.agent, req.ip)
+			if lead.isLeader() {
+				n, err := d.syncAgent(ctx, req.agent, req.ip)
+				req.done <- agentResult{records: n, err: err}
+			} else {
+				req.done <- agentResult{err: fmt.Errorf("standing by, another instance is the leader")}
+			}
+
+		case <-reload:
+			// Keep running with the previous configuration when the new one
+			// is invalid
+			sdNotify("RELOADING=1")
+			nd, ns, err := reloadDaemon(d)
+			sdNotify("READY=1")
+			if err != nil {
+				log.Errorf("configuration: not reloaded: %v", err)
+				continue
+			}
+
+			d = nd
+			if ns.String() != sched.String() {
+				sched = ns
+				log.Infof("checking %s", sched)
+				next = sched.next(time.Now())
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(time.Until(next))
+				health.scheduled(next)
+			}
+
+			svc, err = restartServices(ctx, svc, d, sched, ctl)
+			if err != nil {
+				log.Errorf("configuration: services not restarted: %v", err)
+			}
+			lead = svc.lead
+			log.Info("configuration: reloaded")
+		}
+	}
+}
+
+// syncCommand runs a single cycle and reports its outcome through the exit
+// code, for use with cron, systemd timers or DHCP hooks
+func syncCommand(fs *flag.FlagSet) int {
+	d, ok := newConfiguredDaemon(fs)
+	if !ok {
+		return exitConfigError
+	}
+
+	return exitCode(d.cycle(context.Background()))
+}
+
+// statusCommand asks the running daemon for its status through the API, or
+// checks the records itself when the API isn't configured
+func statusCommand(fs *flag.FlagSet) int {
+	local, _ := fs.GetBool("local")
+	if addr := viper.GetString("api.listen"); addr != "" && !local {
+		asJSON, _ := fs.GetBool("json")
+		return remoteStatus(addr, asJSON)
+	}
+
+	d, ok := newConfiguredDaemon(fs)
+	if !ok {
+		return exitConfigError
+	}
+
+	states, err := d.status(context.Background())
+	if err != nil {
+		return exitCode(err)
+	}
+
+	return writeStates(os.Stdout, states)
+}
+
+// writeStates writes a table of record states to out, returning exitOK when
+// every record is in sync
+func writeStates(out io.Writer, states []recordState) int {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RECORD\tTYPE\tCURRENT\tTTL\tPROXIED\tDETECTED\tSTATE")
+	code := exitOK
+	for _, s := range states {
+		state := "in sync"
+		switch {
+		case s.err != nil:
+			state = "error: " + s.err.Error()
+			code = exitSyncFailed
+		case !s.inSync():
+			state = "out of sync"
+			code = exitSyncFailed
+		}
+
+		ttl, proxied := "-", "-"
+		if s.current != nil {
+			ttl = strconv.Itoa(s.ttl)
+			if s.ttl == 0 {
+				ttl = "-"
+			} else if s.ttl == 1 {
+				ttl = "auto"
+			}
+			proxied = strconv.FormatBool(s.proxied)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.name, s.recordType, s.current, ttl, proxied, s.detected, state)
+	}
+	w.Flush()
+
+	return code
+}
+
+func setIPCommand(fs *flag.FlagSet) int {
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: dyn set-ip [flags] <address>")
+		return exitConfigError
+	}
+
+	ip := net.ParseIP(fs.Arg(0))
+	if ip == nil {
+		log.Errorf("invalid IP address '%s'", fs.Arg(0))
+		return exitConfigError
+	}
+
+	d, ok := newConfiguredDaemon(fs)
+	if !ok {
+		return exitConfigError
+	}
+
+	err := d.setIP(context.Background(), ip)
+	if err != nil {
+		log.Error(err)
+		if _, ok := err.(*syncError); ok {
+			return exitSyncFailed
+		}
+		return exitConfigError
+	}
+
+	return exitOK
+}
+
+func historyCommand(fs *flag.FlagSet) int {
+	store := newHistoryStore()
+	if store == nil {
+		log.Error("history: neither history.database nor history.file is configured")
+		return exitConfigError
+	}
+
+	var from time.Time
+	if since, _ := fs.GetDuration("since"); since > 0 {
+		from = time.Now().Add(-since)
+	}
+	name, _ := fs.GetString("name")
+	filtered, err := store.query(from, name)
+	if err != nil {
+		log.Errorf("history: %v", err)
+		return exitConfigError
+	}
+
+	format, _ := fs.GetString("format")
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(filtered)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"time", "event", "type", "record", "old_ip", "new_ip"})
+		for _, e := range filtered {
+			w.Write([]string{e.Time.Format(time.RFC3339), e.Event, e.Type, e.Record, e.OldIP, e.NewIP})
+		}
+		w.Flush()
+		err = w.Error()
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tEVENT\tTYPE\tRECORD\tOLD IP\tNEW IP")
+		for _, e := range filtered {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Event, e.Type, e.Record, e.OldIP, e.NewIP)
+		}
+		err = w.Flush()
+	default:
+		log.Errorf("history: unknown format '%s', expected table, json or csv", format)
+		return exitConfigError
+	}
+	if err != nil {
+		log.Errorf("history: %v", err)
+		return exitConfigError
+	}
+
+	return exitOK
+}
+
+// validateCommand checks the configuration and provider credentials without
+// performing any updates
+func validateCommand(fs *flag.FlagSet) int {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	problems := validateConfig(ctx)
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "invalid: %v\n", p)
+	}
+	if len(problems) > 0 {
+		return exitConfigError
+	}
+
+	fmt.Printf("configuration '%s' is valid\n", viper.ConfigFileUsed())
+	return exitOK
+}
+
+func versionCommand(fs *flag.FlagSet) int {
+	fmt.Printf("dyn %s (commit %s, built %s)\n", version, commit, date)
+	return exitOK
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	cf "github.com/cloudflare/cloudflare-go"
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+func newCloudflare(key string) (Provider, error) {
+	client, err := cloudflareClient(key)
+	if err != nil {
+		return nil, err
+	}
+
+	newAPI := func(creds string) (*cf.API, error) {
+		return newCloudflareAPI(key, creds, client)
+	}
+
+	// Records are listed again once an hour, to notice changes made by
+	// others
+	cacheTTL := time.Hour
+	if viper.IsSet(key + ".cache") {
+		cacheTTL = viper.GetDuration(key + ".cache")
+	}
+
+	// Additional accounts list the zones they hold, or are searched for
+	// zones which aren't listed anywhere
+	var names []string
+	for name := range viper.GetStringMap(key + ".accounts") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var accounts []provider.CloudflareAccount
+	for _, name := range names {
+		account := key + ".accounts." + name
+		api, err := newAPI(account)
+		if err != nil {
+			return nil, fmt.Errorf("cloudflare: account %s: %v", name, err)
+		}
+		accounts = append(accounts, provider.CloudflareAccount{API: api, Zones: viper.GetStringSlice(account + ".zones")})
+	}
+
+	var api *cf.API
+	if len(names) == 0 || viper.IsSet(key+".apiKey") {
+		api, err = newAPI(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return provider.NewCloudflare(api, accounts, cacheTTL), nil
+}
+
+// newCloudflareAPI returns a client of the API configured under key, using
+// the credentials under creds
+func newCloudflareAPI(key, creds string, client *http.Client) (*cf.API, error) {
+	headers := http.Header{}
+	for k, v := range viper.GetStringMapString(key + ".headers") {
+		headers.Set(k, v)
+	}
+
+	api, err := cf.New(viper.GetString(creds+".apiKey"), viper.GetString(creds+".email"),
+		cf.HTTPClient(client), cf.UsingRetryPolicy(0, 0, 0), cf.Headers(headers))
+	if err != nil {
+		return nil, err
+	}
+
+	// The API may be reached through a gateway, or be a mock server in tests
+	if baseURL := strings.TrimSuffix(viper.GetString(key+".baseURL"), "/"); baseURL != "" {
+		api.BaseURL = baseURL
+	}
+	return api, nil
+}
+
+// cloudflareClient returns the HTTP client for the API, trusting the CA
+// certificates under key.caFile in addition to the system ones, e.g. for
+// proxies intercepting TLS
+func cloudflareClient(key string) (*http.Client, error) {
+	// Retries are handled by the transport, which unlike the library's own
+	// policy adds jitter and honors Retry-After
+	transport := newRetryTransport(key + ".retry")
+
+	if file := viper.GetString(key + ".caFile"); file != "" {
+		base, err := caTransport(file)
+		if err != nil {
+			return nil, fmt.Errorf("cloudflare: %v", err)
+		}
+		transport.base = base
+	}
+
+	timeout := 5 * time.Minute
+	if d := viper.GetDuration(key + ".timeout"); d > 0 {
+		timeout = d
+	}
+
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+func init() {
+	registerProvider("cloudflare", newCloudflare)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// loadConfig reads the configuration file at path, or searches the default
+// locations when path is empty
+func loadConfig(path string) error {
+	// Allow all configuration properties to be passed
+	// as environment variables, e.g. DYN_CLOUDFLARE_APIKEY
+	viper.AutomaticEnv()
+	viper.SetEnvPrefix("DYN")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
+	// Set Viper configuration defaults
+	viper.SetDefault("tick", "5m")
+	viper.SetDefault("dns.record", "@")
+	viper.SetDefault("dns.mode", "ipv4")
+	viper.SetDefault("provider", "cloudflare")
+	viper.SetDefault("detector.type", "dns")
+	viper.SetDefault("cgnat.method", "auto")
+	viper.SetDefault("syncOnStartup", true)
+	viper.SetDefault("failures.action", "exit")
+	viper.SetDefault("cycleTimeout", "2m")
+	viper.SetDefault("notify.failures", 3)
+
+	// Load configuration
+	if path != "" {
+		viper.SetConfigFile(path)
+	} else {
+		viper.SetConfigName("config") // name of config file without extension
+		viper.AddConfigPath("/etc/dyn/")
+		viper.AddConfigPath("$HOME/.dyn/")
+		viper.AddConfigPath(".")
+	}
+
+	// The configuration file is optional when searching the default
+	// locations, as everything can be set with environment variables and
+	// flags, e.g. in containers
+	err := readConfig()
+	if _, ok := err.(viper.ConfigFileNotFoundError); ok && path == "" {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = loadSecrets()
+	if err != nil {
+		return err
+	}
+
+	err = configureLogging()
+	if err != nil {
+		return err
+	}
+
+	if viper.ConfigFileUsed() == "" {
+		log.Info("configuration: no configuration file found, using environment variables and flags")
+		return nil
+	}
+	log.Infof("configuration: loading configuration file from '%s'", viper.ConfigFileUsed())
+	return nil
+}
+
+type zoneConfig struct {
+	Name    string         `mapstructure:"name"`
+	Records []recordConfig `mapstructure:"records"`
+	TTL     int            `mapstructure:"ttl"`     // 0 leaves the TTL unchanged
+	Proxied *bool          `mapstructure:"proxied"` // nil leaves the proxy status unchanged
+}
+
+// recordConfig is a configured record. Records are given either by name, or
+// as a map overriding the settings of their zone, the IP mode, and the
+// provider and detector to use.
+type recordConfig struct {
+	Name     string `mapstructure:"name"`
+	TTL      int    `mapstructure:"ttl"`
+	Proxied  *bool  `mapstructure:"proxied"`
+	Mode     string `mapstructure:"mode"`     // empty for dns.mode
+	Provider string `mapstructure:"provider"` // empty for the default provider
+	Detector string `mapstructure:"detector"` // empty for the default detector
+	WAN      string `mapstructure:"wan"`      // uplink whose public IP is detected
+	Agent    string `mapstructure:"agent"`    // reports the address instead of a detector
+	Service  string `mapstructure:"service"`  // Kubernetes Service publishing the address
+	Ingress  string `mapstructure:"ingress"`  // Kubernetes Ingress publishing the address
+
+	types         []string // record types to sync, resolved from the mode
+	createMissing bool     // registered by a container, created when missing
+}
+
+// detected reports whether the record's address comes from a detector,
+// rather than an agent or Kubernetes
+func (r recordConfig) detected() bool {
+	return r.Agent == "" && r.Service == "" && r.Ingress == ""
+}
+
+// detectorName returns the name of the detector of a detected record, empty
+// for the default one and "wan <name>" for the detector of an uplink
+func (r recordConfig) detectorName() string {
+	if r.WAN != "" {
+		return "wan " + r.WAN
+	}
+	return r.Detector
+}
+
+// decodeRecordNames lets records be given by name alone
+func decodeRecordNames(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() == reflect.String && to == reflect.TypeOf(recordConfig{}) {
+		return map[string]interface{}{"name": data}, nil
+	}
+	return data, nil
+}
+
+// loadZones returns the configured zones and their records. A list of zones
+// may be given under zones, otherwise the single zone under dns is used.
+func loadZones() ([]zoneConfig, error) {
+	zones := []zoneConfig{{
+		Name:    viper.GetString("dns.zone"),
+		TTL:     viper.GetInt("dns.ttl"),
+		Proxied: proxiedDefault(),
+	}}
+	if viper.IsSet("zones") {
+		zones = nil
+		err := viper.UnmarshalKey("zones", &zones, viper.DecodeHook(decodeRecordNames))
+		if err != nil {
+			return nil, fmt.Errorf("zones: %v", err)
+		}
+	} else {
+		records, err := recordNames()
+		if err != nil {
+			return nil, err
+		}
+		zones[0].Records = records
+	}
+
+	for i, z := range zones {
+		if z.Name == "" {
+			return nil, fmt.Errorf("zones[%d]: missing name", i)
+		}
+		if len(z.Records) == 0 {
+			zones[i].Records = []recordConfig{{Name: "@"}}
+		}
+
+		// Zones inherit the record settings under dns
+		if z.TTL == 0 {
+			zones[i].TTL = viper.GetInt("dns.ttl")
+		}
+		if z.Proxied == nil {
+			zones[i].Proxied = proxiedDefault()
+		}
+
+		// Records inherit the settings of their zone
+		for j, r := range zones[i].Records {
+			if r.Name == "" {
+				return nil, fmt.Errorf("zones: %s: record %d is missing a name", z.Name, j)
+			}
+			if r.TTL == 0 {
+				zones[i].Records[j].TTL = zones[i].TTL
+			}
+			if r.Proxied == nil {
+				zones[i].Records[j].Proxied = zones[i].Proxied
+			}
+
+			sources := 0
+			for _, s := range []string{r.Detector, r.WAN, r.Agent, r.Service, r.Ingress} {
+				if s != "" {
+					sources++
+				}
+			}
+			if sources > 1 {
+				return nil, fmt.Errorf("zones: %s: only one of detector, wan, agent, service and ingress may be set", r.Name)
+			}
+			// Agent and WAN names are keys under server.agents and wans,
+			// which are lower case
+			zones[i].Records[j].Agent = strings.ToLower(r.Agent)
+			zones[i].Records[j].WAN = strings.ToLower(r.WAN)
+
+			mode := r.Mode
+			if mode == "" {
+				mode = viper.GetString("dns.mode")
+			}
+			types, err := recordTypes(mode)
+			if err != nil {
+				return nil, fmt.Errorf("zones: %s: %v", r.Name, err)
+			}
+			zones[i].Records[j].types = types
+		}
+	}
+
+	return zones, nil
+}
+
+// recordNames returns the configured records, accepting either a list under
+// dns.records or a single dns.record
+func recordNames() ([]recordConfig, error) {
+	if !viper.IsSet("dns.records") {
+		return []recordConfig{{Name: viper.GetString("dns.record")}}, nil
+	}
+
+	// Environment variables hold a space separated list of names
+	if s, ok := viper.Get("dns.records").(string); ok {
+		var records []recordConfig
+		for _, name := range strings.Fields(s) {
+			records = append(records, recordConfig{Name: name})
+		}
+		return records, nil
+	}
+
+	var records []recordConfig
+	err := viper.UnmarshalKey("dns.records", &records, viper.DecodeHook(decodeRecordNames))
+	if err != nil {
+		return nil, fmt.Errorf("dns.records: %v", err)
+	}
+	return records, nil
+}
+
+// proxiedDefault returns dns.proxied, or nil when it isn't set
+func proxiedDefault() *bool {
+	if !viper.IsSet("dns.proxied") {
+		return nil
+	}
+	proxied := viper.GetBool("dns.proxied")
+	return &proxied
+}
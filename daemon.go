@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// daemon holds everything needed to run detection and sync cycles
+type daemon struct {
+	provider  Provider
+	detector  Detector
+	zones     []zoneConfig
+	dryRun    bool
+	timeout   time.Duration
+	notifiers []Notifier
+
+	// Providers and detectors overriding the defaults for some records, by
+	// name
+	providers map[string]Provider
+	detectors map[string]Detector
+
+	actions []namedAction
+	applied *appliedIPs
+
+	// Reports the public IP to a server instead of syncing records, on
+	// agents
+	agent *agentClient
+
+	// Reads the addresses of records publishing Kubernetes Services and
+	// Ingresses, nil when there are none
+	kubernetes *kubernetesClient
+
+	// Lists the containers registering records through their labels, nil
+	// unless docker.enabled is set, and the records last registered
+	docker     *dockerClient
+	containers []zoneConfig
+}
+
+func newDaemon() (*daemon, error) {
+	// Construct the configured public IP detector
+	detector, err := newDetector(viper.GetString("detector.type"))
+	if err != nil {
+		return nil, err
+	}
+
+	notifiers, err := newNotifiers()
+	if err != nil {
+		return nil, err
+	}
+
+	actions, err := newActions()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := time.ParseDuration(viper.GetString("cycleTimeout"))
+	if err != nil {
+		return nil, fmt.Errorf("configuration: invalid cycleTimeout: %v", err)
+	}
+
+	d := &daemon{
+		detector:  detector,
+		timeout:   timeout,
+		notifiers: notifiers,
+		actions:   actions,
+		applied:   &appliedIPs{ips: map[string]string{}},
+	}
+
+	// Agents leave the provider and records to the server
+	d.agent, err = newAgentClient()
+	if err != nil {
+		return nil, err
+	}
+	if d.agent != nil {
+		return d, nil
+	}
+
+	// Construct the configured DNS provider
+	d.provider, err = newProvider(viper.GetString("provider"))
+	if err != nil {
+		return nil, err
+	}
+
+	zones, err := loadZones()
+	if err != nil {
+		return nil, err
+	}
+
+	providers := map[string]Provider{}
+	detectors := map[string]Detector{}
+	for _, z := range zones {
+		for _, r := range z.Records {
+			if _, ok := providers[r.Provider]; r.Provider != "" && !ok {
+				providers[r.Provider], err = newProvider(r.Provider)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", r.Name, err)
+				}
+			}
+			if _, ok := detectors[r.Detector]; r.Detector != "" && !ok {
+				detectors[r.Detector], err = newDetector(r.Detector)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", r.Name, err)
+				}
+			}
+			if name := r.detectorName(); r.WAN != "" && detectors[name] == nil {
+				detectors[name], err = newWANDetector(r.WAN)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", r.Name, err)
+				}
+			}
+			if r.Agent != "" && !viper.IsSet("server.agents."+r.Agent+".token") {
+				return nil, fmt.Errorf("%s: unknown agent %s, missing server.agents.%s.token", r.Name, r.Agent, r.Agent)
+			}
+			if (r.Service != "" || r.Ingress != "") && d.kubernetes == nil {
+				d.kubernetes, err = newKubernetesClient()
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", r.Name, err)
+				}
+			}
+		}
+	}
+
+	d.docker, err = newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+
+	d.zones = zones
+	d.providers = providers
+	d.detectors = detectors
+	return d, nil
+}
+
+// providerFor returns the provider managing r
+func (d *daemon) providerFor(r recordConfig) Provider {
+	if p, ok := d.providers[r.Provider]; ok {
+		return p
+	}
+	return d.provider
+}
+
+// detectorFor returns the detector with the given name, or the default one
+// for an empty name
+func (d *daemon) detectorFor(name string) Detector {
+	if det, ok := d.detectors[name]; ok {
+		return det
+	}
+	return d.detector
+}
+
+// detectorNames returns the names of the detectors records override the
+// default one with, sorted
+func (d *daemon) detectorNames() []string {
+	var names []string
+	for name := range d.detectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// targets returns the configured records of type t using the named detector,
+// empty for the default one, ready to be synced
+func (d *daemon) targets(detector, t string) []dynIP {
+	return d.targetsWhere(t, func(r recordConfig) bool {
+		return r.detected() && r.detectorName() == detector
+	})
+}
+
+// agentTargets returns the configured records of type t whose address is
+// reported by the named agent
+func (d *daemon) agentTargets(agent, t string) []dynIP {
+	return d.targetsWhere(t, func(r recordConfig) bool {
+		return r.Agent == agent
+	})
+}
+
+func (d *daemon) targetsWhere(t string, match func(recordConfig) bool) []dynIP {
+	var targets []dynIP
+	for _, z := range append(d.zones[:len(d.zones):len(d.zones)], d.containers...) {
+		for _, r := range z.Records {
+			if !match(r) || !containsString(r.types, t) {
+				continue
+			}
+			targets = append(targets, dynIP{
+				Provider:      d.providerFor(r),
+				Zone:          z.Name,
+				Name:          r.Name,
+				Type:          t,
+				DryRun:        d.dryRun,
+				TTL:           r.TTL,
+				Proxied:       r.Proxied,
+				CreateMissing: r.createMissing,
+				Hooks:         updateHooks(d.providerFor(r)),
+			})
+		}
+	}
+	return targets
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// withTimeout bounds a cycle, so a hung lookup or API call can't stall the
+// daemon
+func (d *daemon) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d.timeout)
+}
+
+// cycle detects the public IP and syncs every configured record with it
+func (d *daemon) cycle(ctx context.Context) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	err := d.syncAll(ctx)
+	health.record(err)
+	if err == nil {
+		state.synced()
+	}
+	state.save()
+	// Don't report a cycle aborted by shutdown as failed
+	if ctx.Err() != context.Canceled {
+		ping(err)
+		publishMQTT(err)
+		publishKV()
+	}
+	return err
+}
+
+// syncAll syncs the records of every configured type
+func (d *daemon) syncAll(ctx context.Context) error {
+	if d.agent != nil {
+		return d.report(ctx)
+	}
+
+	// Containers that can't be listed keep the records last registered
+	if d.docker != nil {
+		containers, err := d.containerRecords(ctx)
+		if err != nil {
+			log.Error(err)
+		} else {
+			d.containers = containers
+		}
+	}
+
+	// A failed detection of one type doesn't keep the others from syncing
+	var detectErrs []string
+	failed, total := 0, 0
+	for _, t := range []string{"A", "AAAA"} {
+		n, f, err := d.syncType(ctx, t)
+		if err != nil {
+			detectErrs = append(detectErrs, fmt.Sprintf("%s: %v", t, err))
+		}
+		failed += f
+		total += n
+	}
+
+	n, f := d.syncOverridden(ctx)
+	failed += f
+	total += n
+
+	if d.kubernetes != nil {
+		n, f = d.syncKubernetes(ctx)
+		failed += f
+		total += n
+	}
+
+	if viper.GetBool("dns.cleanup") {
+		d.cleanup(ctx)
+	}
+
+	return cycleError(detectErrs, failed, total)
+}
+
+// syncType syncs the records of a single type using the default detector,
+// returning the number of records and how many of them failed to sync
+func (d *daemon) syncType(ctx context.Context, t string) (int, int, error) {
+	targets := d.targets("", t)
+	if len(targets) == 0 {
+		return 0, 0, nil
+	}
+
+	dIP, err := d.detectIP(ctx, t)
+	if err != nil || dIP == nil {
+		return 0, 0, err
+	}
+
+	total, failed := d.syncRecords(ctx, t, dIP, targets)
+	d.applyActions(ctx, t, dIP)
+	return total, failed, nil
+}
+
+// detectIP detects the public IP of type t with the default detector,
+// returning nil when it can't be published yet, or at all
+func (d *daemon) detectIP(ctx context.Context, t string) (net.IP, error) {
+	// Get the current dynamic IP
+	dIP, err := d.detector.Detect(ctx, recordNetwork(t))
+	if err != nil {
+		return nil, &detectionError{Err: err}
+	}
+
+	// Never publish an address which isn't reachable from the Internet, as
+	// it most likely comes from a misbehaving detector. Such addresses are
+	// rejected before they can be kept in the state or debounce the real one
+	if _, ok := d.detector.(overlayDetector); !ok && !viper.GetBool("detector.allowPrivate") {
+		err = checkPublicIP(dIP)
+		if err != nil {
+			log.Warnf("detector: rejecting detected IP: %v", err)
+			return nil, nil
+		}
+	}
+
+	if ok, p := state.confirmIP(t, dIP); !ok {
+		log.Infof("detector: new %s IP %s seen %d times over %s, waiting for it to be stable", t, dIP, p.Checks, time.Since(p.Since).Round(time.Second))
+		return nil, nil
+	}
+
+	if old, changed := state.observeIP(t, dIP); changed {
+		recordHistory(historyEntry{Event: historyIPChanged, Type: t, OldIP: old, NewIP: dIP.String()})
+	}
+	d.checkFlapping(dIP.String())
+
+	// Compare the router's WAN address with the detected address to find out
+	// whether the connection is behind carrier-grade NAT
+	if t == "A" && viper.GetBool("cgnat.check") {
+		behind, routerIP, err := checkCGNAT(ctx, dIP, viper.GetString("cgnat.method"), viper.GetString("cgnat.gateway"))
+		if err != nil {
+			log.Warnf("cgnat: unable to query router: %v", err)
+		} else if behind {
+			log.Warnf("cgnat: router WAN IP (%s) differs from public IP (%s), the connection is likely behind CGNAT and not reachable", routerIP, dIP)
+			if viper.GetBool("cgnat.suppress") {
+				log.Warnf("cgnat: suppressing %s record updates", t)
+				return nil, nil
+			}
+		}
+	}
+
+	return dIP, nil
+}
+
+// syncOverridden syncs the records with their own detector, returning the
+// number of records and how many of them failed to sync. Their addresses
+// aren't debounced or kept in the state, as they may well differ from the
+// public IP.
+func (d *daemon) syncOverridden(ctx context.Context) (int, int) {
+	failed, total := 0, 0
+	for _, name := range d.detectorNames() {
+		for _, t := range []string{"A", "AAAA"} {
+			targets := d.targets(name, t)
+			if len(targets) == 0 {
+				continue
+			}
+
+			ip, err := d.detectors[name].Detect(ctx, recordNetwork(t))
+			if err != nil {
+				log.Errorf("detector %s: %v", name, err)
+				failed += len(targets)
+				total += len(targets)
+				continue
+			}
+
+			if _, ok := d.detectors[name].(overlayDetector); !ok && !viper.GetBool("detector.allowPrivate") {
+				err = checkPublicIP(ip)
+				if err != nil {
+					log.Warnf("detector %s: rejecting detected IP: %v", name, err)
+					continue
+				}
+			}
+
+			n, f := d.syncRecords(ctx, t, ip, targets)
+			failed += f
+			total += n
+		}
+	}
+	return total, failed
+}
+
+// syncRecords points the given records of type t at ip, returning the number
+// of records and how many of them failed to sync
+func (d *daemon) syncRecords(ctx context.Context, t string, dIP net.IP, targets []dynIP) (int, int) {
+	// Sync each configured record, reporting failures per record
+	failed, total := 0, 0
+	changed := event{Type: eventIPChanged, NewIP: dIP.String()}
+	for _, dyn := range targets {
+		total++
+		dyn.IP = dIP
+
+		err := d.syncRecord(ctx, &dyn)
+		if err != nil {
+			failed++
+		}
+		if dyn.Changed {
+			changed.Records = append(changed.Records, dyn.FQDN())
+			old := ""
+			if dyn.RemoteIP != nil {
+				old = dyn.RemoteIP.String()
+			}
+			if changed.OldIP == "" {
+				changed.OldIP = old
+			}
+			recordHistory(historyEntry{Event: historyRecordUpdated, Type: t, Record: dyn.FQDN(), OldIP: old, NewIP: dIP.String()})
+			if viper.GetBool("propagation.check") {
+				d.checkPropagation(dyn.Zone, dyn.FQDN(), t, dIP)
+			}
+		}
+
+		state.observeRecord(dyn.FQDN(), t, d.syncedContent(&dyn, err), dyn.Changed, err)
+	}
+
+	if len(changed.Records) > 0 {
+		notify(d.notifiers, changed)
+	}
+
+	if failed > 0 {
+		log.Warnf("%d of %d %s records failed to sync", failed, total, t)
+	}
+
+	return total, failed
+}
+
+// syncedContent returns the content to save for a synced record: its IP once
+// the record points at it, or nothing to keep the last one written when the
+// record was left alone for drift, or its update deferred or failed
+func (d *daemon) syncedContent(dyn *dynIP, err error) string {
+	if err != nil || d.dryRun || dyn.Deferred {
+		return ""
+	}
+	if !dyn.Changed && !dyn.IP.Equal(dyn.RemoteIP) {
+		return ""
+	}
+	return dyn.IP.String()
+}
+
+// setIP points every configured record of the address' type at ip, skipping
+// detection, for routers and DHCP hooks which already know the new address
+func (d *daemon) setIP(ctx context.Context, ip net.IP) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	t := "AAAA"
+	if ip.To4() != nil {
+		t = "A"
+	}
+
+	if !viper.GetBool("detector.allowPrivate") {
+		err := checkPublicIP(ip)
+		if err != nil {
+			return err
+		}
+	}
+
+	if old, changed := state.observeIP(t, ip); changed {
+		recordHistory(historyEntry{Event: historyIPChanged, Type: t, OldIP: old, NewIP: ip.String()})
+	}
+
+	total, failed := d.syncRecords(ctx, t, ip, d.targets("", t))
+	d.applyActions(ctx, t, ip)
+	var err error
+	if failed > 0 {
+		err = &syncError{Failed: failed, Total: total}
+	}
+
+	health.record(err)
+	if err == nil {
+		state.synced()
+	}
+	state.save()
+	publishMQTT(err)
+	publishKV()
+
+	return err
+}
+
+// syncRecord fetches a record and syncs it with the detected IP, creating it
+// when it's missing and creation is enabled. Errors are logged.
+func (d *daemon) syncRecord(ctx context.Context, dyn *dynIP) error {
+	t := dyn.Type
+	err := dyn.Lookup(ctx)
+	if err == errRecordNotFound {
+		if !viper.GetBool("dns.createMissing") && !dyn.CreateMissing {
+			dyn.Log().Errorf("DNS %s record %s does not exist, create it or set dns.createMissing", t, dyn.FQDN())
+			return err
+		}
+
+		err = dyn.Create(ctx)
+		if err != nil {
+			dyn.Log().Errorf("error creating DNS %s record %s: %s", t, dyn.FQDN(), err)
+		}
+		return err
+	}
+	if err != nil {
+		dyn.Log().Errorf("error getting remote ip for %s: %s", dyn.FQDN(), err)
+		return err
+	}
+
+	if d.checkDrift(dyn) {
+		return nil
+	}
+
+	err = dyn.Sync(ctx)
+	if err != nil {
+		dyn.Log().Errorf("error syncing remote DNS for %s: %s", dyn.FQDN(), err)
+		return err
+	}
+
+	return d.syncDuplicates(ctx, dyn)
+}
+
+// recordState describes a record compared with the detected public IP
+type recordState struct {
+	name       string
+	recordType string
+	current    net.IP
+	ttl        int
+	proxied    bool
+	detected   net.IP
+	err        error
+}
+
+func (s recordState) inSync() bool {
+	return s.current.Equal(s.detected)
+}
+
+// status detects the public IP and compares it with every configured record,
+// without changing anything
+func (d *daemon) status(ctx context.Context) ([]recordState, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	var states []recordState
+	for _, name := range append([]string{""}, d.detectorNames()...) {
+		for _, t := range []string{"A", "AAAA"} {
+			targets := d.targets(name, t)
+			if len(targets) == 0 {
+				continue
+			}
+
+			dIP, err := d.detectorFor(name).Detect(ctx, recordNetwork(t))
+			if err != nil {
+				return nil, &detectionError{Err: err}
+			}
+
+			for _, dyn := range targets {
+				dyn.IP = dIP
+				err = dyn.Lookup(ctx)
+
+				states = append(states, recordState{
+					name:       dyn.FQDN(),
+					recordType: t,
+					current:    dyn.RemoteIP,
+					ttl:        dyn.Record.TTL,
+					proxied:    dyn.Record.Proxied,
+					detected:   dIP,
+					err:        err,
+				})
+			}
+		}
+	}
+
+	return states, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// pendingIP is a newly detected IP waiting to be confirmed
+type pendingIP struct {
+	IP     string    `json:"ip"`
+	Since  time.Time `json:"since"`
+	Checks int       `json:"checks"`
+}
+
+// confirmIP holds back a changed IP until it has been detected consistently
+// for debounce.checks detections in a row and debounce.duration, so a
+// detector briefly returning garbage doesn't thrash the records. It reports
+// whether ip may replace the last detected IP of the record type, which is
+// always the case when it's unchanged or none was detected yet. Pending IPs
+// are kept in the state, so they also apply across "dyn sync" runs.
+func (s *stateStore) confirmIP(recordType string, ip net.IP) (bool, *pendingIP) {
+	checks := viper.GetInt("debounce.checks")
+	duration := viper.GetDuration("debounce.duration")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last, ok := s.IPs[recordType]
+	if !ok || last.IP == ip.String() || (checks <= 1 && duration <= 0) {
+		delete(s.Pending, recordType)
+		return true, nil
+	}
+
+	p, ok := s.Pending[recordType]
+	if !ok || p.IP != ip.String() {
+		p = &pendingIP{IP: ip.String(), Since: time.Now()}
+		s.Pending[recordType] = p
+	}
+	p.Checks++
+
+	if p.Checks >= checks && time.Since(p.Since) >= duration {
+		delete(s.Pending, recordType)
+		return true, nil
+	}
+	return false, p
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// readConfig reads the configuration file, decrypting it in memory first when
+// it's encrypted with SOPS or age, so it can be committed alongside its
+// tokens. Decryption is left to the sops and age commands.
+func readConfig() error {
+	err := viper.ReadInConfig()
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return err
+	}
+
+	// SOPS files are valid YAML or JSON, with their metadata under sops
+	sops := err == nil && viper.IsSet("sops.mac")
+	age := strings.HasSuffix(path, ".age")
+	if !sops && !age {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var plain string
+	if sops {
+		plain, err = execCommand(ctx, "sops", []string{"--decrypt", path}, nil, nil)
+		if err != nil {
+			return fmt.Errorf("configuration: unable to decrypt with sops: %v", err)
+		}
+	} else {
+		plain, err = decryptAge(ctx, path)
+		if err != nil {
+			return fmt.Errorf("configuration: unable to decrypt with age: %v", err)
+		}
+	}
+
+	// The format is that of the file without its .age extension
+	ext := filepath.Ext(strings.TrimSuffix(path, ".age"))
+	viper.SetConfigType(strings.TrimPrefix(ext, "."))
+	return viper.ReadConfig(bytes.NewReader([]byte(plain)))
+}
+
+// decryptAge decrypts an age encrypted file with the identity file set by
+// age.identity (or DYN_AGE_IDENTITY), as the configuration isn't read yet
+func decryptAge(ctx context.Context, path string) (string, error) {
+	identity := viper.GetString("age.identity")
+	if identity == "" {
+		return "", fmt.Errorf("missing DYN_AGE_IDENTITY")
+	}
+
+	// Make sure the file is actually age encrypted, rather than passing
+	// anything with the extension to age
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(data, []byte("age-encryption.org/")) && !bytes.HasPrefix(data, []byte("-----BEGIN AGE ENCRYPTED FILE-----")) {
+		return "", fmt.Errorf("%s is not an age encrypted file", path)
+	}
+
+	return execCommand(ctx, "age", []string{"--decrypt", "--identity", identity, path}, nil, nil)
+}
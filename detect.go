@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ianmuscat/dyn/pkg/detect"
+	"github.com/spf13/viper"
+)
+
+// Detector is defined in pkg/detect, so other programs can embed detectors
+type Detector = detect.Detector
+
+// detectors holds the constructors of every available detector by type. Each
+// constructor reads its settings from the configuration section under key.
+var detectors = map[string]func(key string) (Detector, error){}
+
+func registerDetector(name string, fn func(key string) (Detector, error)) {
+	detectors[name] = fn
+}
+
+func newDetector(name string) (Detector, error) {
+	fn, ok := detectors[name]
+	if !ok {
+		var names []string
+		for n := range detectors {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown detector '%s', expected one of %s", name, strings.Join(names, ", "))
+	}
+
+	return fn("detector." + name)
+}
+
+// checkSources returns an error when the sources of the composite detector
+// name include it, directly or through other composite detectors, which
+// would never finish building
+func checkSources(name string) error {
+	var walk func(path []string) error
+	walk = func(path []string) error {
+		for _, source := range viper.GetStringSlice("detector." + path[len(path)-1] + ".sources") {
+			chain := append(append([]string(nil), path...), source)
+			if source == name {
+				return fmt.Errorf("detector: detector.%s.sources cannot include %s (%s)", name, name, strings.Join(chain, " -> "))
+			}
+			// Other loops are reported when building their own detectors
+			if containsString(path, source) {
+				continue
+			}
+
+			err := walk(chain)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walk([]string{name})
+}
+
+var checkPublicIP = detect.CheckPublicIP
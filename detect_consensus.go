@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/detect"
+	"github.com/spf13/viper"
+)
+
+func newConsensusDetector(key string) (Detector, error) {
+	names := viper.GetStringSlice(key + ".sources")
+	if len(names) == 0 {
+		names = []string{"dns", "http", "stun"}
+	}
+
+	err := checkSources("consensus")
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []detect.Source
+	for _, name := range names {
+		source, err := newDetector(name)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, detect.Source{Name: name, Detector: source})
+	}
+
+	d, err := detect.NewConsensus(sources, viper.GetInt(key+".quorum"))
+	if err != nil {
+		return nil, fmt.Errorf("detector: %s: %v", key, err)
+	}
+	return d, nil
+}
+
+func init() {
+	registerDetector("consensus", newConsensusDetector)
+}
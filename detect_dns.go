@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/detect"
+	"github.com/spf13/viper"
+)
+
+// newDNSOptionsDetector applies the settings under key to opts, overriding
+// the echo service's defaults
+func newDNSOptionsDetector(key string, opts detect.DNSOptions) (Detector, error) {
+	if viper.IsSet(key + ".hostname") {
+		opts.Hostname = viper.GetString(key + ".hostname")
+	}
+	if viper.IsSet(key + ".resolver") {
+		opts.Resolvers = viper.GetStringSlice(key + ".resolver")
+	}
+	if opts.Hostname == "" || len(opts.Resolvers) == 0 {
+		return nil, fmt.Errorf("detector: %s.hostname and %s.resolver must not be empty", key, key)
+	}
+
+	opts.DoH = viper.GetString(key + ".doh")
+	opts.TLS = viper.GetBool(key + ".tls")
+	opts.TLSServerName = viper.GetString(key + ".tlsServerName")
+
+	var err error
+	opts.Binding, err = newBinding(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return detect.NewDNS(opts)
+}
+
+func init() {
+	registerDetector("dns", func(key string) (Detector, error) {
+		return newDNSOptionsDetector(key, detect.OpenDNS)
+	})
+	registerDetector("google", func(key string) (Detector, error) {
+		return newDNSOptionsDetector(key, detect.Google)
+	})
+	registerDetector("akamai", func(key string) (Detector, error) {
+		return newDNSOptionsDetector(key, detect.Akamai)
+	})
+}
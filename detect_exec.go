@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/detect"
+	"github.com/spf13/viper"
+)
+
+func newExecDetector(key string) (Detector, error) {
+	command := viper.GetString(key + ".command")
+	if command == "" {
+		return nil, fmt.Errorf("detector: missing %s.command", key)
+	}
+
+	return detect.NewExec(command, viper.GetStringSlice(key+".args")), nil
+}
+
+func init() {
+	registerDetector("exec", newExecDetector)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/detect"
+	"github.com/spf13/viper"
+)
+
+func newFallbackDetector(key string) (Detector, error) {
+	names := viper.GetStringSlice(key + ".sources")
+	if len(names) == 0 {
+		return nil, fmt.Errorf("detector: %s.sources must not be empty", key)
+	}
+
+	err := checkSources("fallback")
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []detect.Source
+	for _, name := range names {
+		source, err := newDetector(name)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, detect.Source{Name: name, Detector: source})
+	}
+
+	return detect.NewFallback(sources)
+}
+
+func init() {
+	registerDetector("fallback", newFallbackDetector)
+}
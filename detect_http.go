@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/detect"
+	"github.com/spf13/viper"
+)
+
+func newHTTPDetector(key string) (Detector, error) {
+	endpoints := detect.DefaultHTTPEndpoints
+	if viper.IsSet(key + ".endpoints") {
+		endpoints = viper.GetStringSlice(key + ".endpoints")
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("detector: %s.endpoints must not be empty", key)
+	}
+
+	bind, err := newBinding(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return detect.NewHTTP(endpoints, bind), nil
+}
+
+func init() {
+	registerDetector("http", newHTTPDetector)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/detect"
+	"github.com/spf13/viper"
+)
+
+func newInterfaceDetector(key string) (Detector, error) {
+	viper.SetDefault(key+".globalOnly", true)
+	viper.SetDefault(key+".excludeTemporary", true)
+
+	opts := detect.InterfaceOptions{
+		Name:             viper.GetString(key + ".name"),
+		GlobalOnly:       viper.GetBool(key + ".globalOnly"),
+		ExcludeTemporary: viper.GetBool(key + ".excludeTemporary"),
+	}
+	if opts.Name == "" {
+		return nil, fmt.Errorf("detector: missing %s.name", key)
+	}
+
+	return detect.NewInterface(opts), nil
+}
+
+func init() {
+	registerDetector("interface", newInterfaceDetector)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/ianmuscat/dyn/pkg/detect"
+	"github.com/spf13/viper"
+)
+
+// overlayDetector is implemented by detectors reading the host's address on
+// an overlay network, which is published regardless of detector.allowPrivate
+type overlayDetector = detect.OverlayDetector
+
+func newTailscaleDetector(key string) (Detector, error) {
+	return detect.NewTailscale(viper.GetString(key + ".socket")), nil
+}
+
+func newZeroTierDetector(key string) (Detector, error) {
+	return detect.NewZeroTier(detect.ZeroTierOptions{
+		URL:       viper.GetString(key + ".url"),
+		Token:     viper.GetString(key + ".token"),
+		TokenFile: viper.GetString(key + ".tokenFile"),
+		Network:   viper.GetString(key + ".network"),
+	}), nil
+}
+
+func init() {
+	registerDetector("tailscale", newTailscaleDetector)
+	registerDetector("zerotier", newZeroTierDetector)
+}
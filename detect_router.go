@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/detect"
+	"github.com/spf13/viper"
+)
+
+func newRouterDetector(key string) (Detector, error) {
+	d, err := detect.NewRouter(viper.GetString(key+".method"), viper.GetString(key+".gateway"))
+	if err != nil {
+		return nil, fmt.Errorf("detector: %s.method: %v", key, err)
+	}
+
+	return d, nil
+}
+
+func init() {
+	registerDetector("router", newRouterDetector)
+}
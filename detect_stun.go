@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/detect"
+	"github.com/spf13/viper"
+)
+
+func newSTUNDetector(key string) (Detector, error) {
+	servers := detect.DefaultSTUNServers
+	if viper.IsSet(key + ".servers") {
+		servers = viper.GetStringSlice(key + ".servers")
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("detector: %s.servers must not be empty", key)
+	}
+
+	bind, err := newBinding(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return detect.NewSTUN(servers, bind), nil
+}
+
+func init() {
+	registerDetector("stun", newSTUNDetector)
+}
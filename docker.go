@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// dockerHostnameLabel lists the names of the records a container registers,
+// pointed at the public IP like the configured records. dyn.ttl, dyn.proxied
+// and dyn.mode override the settings of its zone.
+const dockerHostnameLabel = "dyn.hostname"
+
+// dockerClient calls the Docker Engine API, on its unix socket or over TCP
+type dockerClient struct {
+	base   string
+	client *http.Client
+}
+
+// newDockerClient returns the client of the Docker daemon at docker.host,
+// DOCKER_HOST or the default socket, nil unless docker.enabled is set
+func newDockerClient() (*dockerClient, error) {
+	if !viper.GetBool("docker.enabled") {
+		return nil, nil
+	}
+
+	host := viper.GetString("docker.host")
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("docker: invalid host %s: %v", host, err)
+	}
+
+	c := &dockerClient{}
+	switch u.Scheme {
+	case "unix":
+		c.base = "http://docker"
+		c.client = &http.Client{Transport: unixTransport(u.Path)}
+	case "tcp", "http":
+		c.base = "http://" + u.Host
+		c.client = &http.Client{}
+	default:
+		return nil, fmt.Errorf("docker: unsupported host %s, expected unix:// or tcp://", host)
+	}
+
+	return c, nil
+}
+
+// send sends a GET request to the API, returning an httpError for
+// unsuccessful responses
+func (c *dockerClient) send(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, &httpError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: string(data)}
+	}
+	return resp, nil
+}
+
+// dockerFilters encodes the filters parameter of list and event requests
+func dockerFilters(filters map[string][]string) string {
+	data, _ := json.Marshal(filters)
+	return url.QueryEscape(string(data))
+}
+
+// containerRecords returns the records registered by the labels of running
+// containers, in the configured zones their names belong to. Names already
+// configured, or outside of every zone, are skipped.
+func (d *daemon) containerRecords(ctx context.Context) ([]zoneConfig, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	resp, err := d.docker.send(ctx, "/containers/json?filters="+dockerFilters(map[string][]string{
+		"label": {dockerHostnameLabel},
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("docker: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var containers []struct {
+		Names  []string          `json:"Names"`
+		Labels map[string]string `json:"Labels"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&containers)
+	if err != nil {
+		return nil, fmt.Errorf("docker: %v", err)
+	}
+
+	configured := map[string]bool{}
+	for _, z := range d.zones {
+		for _, r := range z.Records {
+			fqdn := (&dynIP{Zone: z.Name, Name: r.Name}).FQDN()
+			configured[strings.TrimSuffix(strings.ToLower(fqdn), ".")] = true
+		}
+	}
+
+	zones := map[string]*zoneConfig{}
+	for _, c := range containers {
+		container := strings.TrimPrefix(strings.Join(c.Names, ","), "/")
+		for _, name := range strings.Split(c.Labels[dockerHostnameLabel], ",") {
+			name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+			if name == "" || configured[name] {
+				continue
+			}
+
+			zone := d.zoneOf(name)
+			if zone == nil {
+				log.Warnf("docker: %s: %s isn't in any configured zone", container, name)
+				continue
+			}
+
+			r, err := containerRecord(zone, name, c.Labels)
+			if err != nil {
+				log.Warnf("docker: %s: %v", container, err)
+				continue
+			}
+			configured[name] = true
+
+			if zones[zone.Name] == nil {
+				zones[zone.Name] = &zoneConfig{Name: zone.Name}
+			}
+			zones[zone.Name].Records = append(zones[zone.Name].Records, r)
+		}
+	}
+
+	var names []string
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var result []zoneConfig
+	for _, name := range names {
+		result = append(result, *zones[name])
+	}
+	return result, nil
+}
+
+// zoneOf returns the configured zone name belongs to, the longest one when
+// zones are nested
+func (d *daemon) zoneOf(name string) *zoneConfig {
+	var zone *zoneConfig
+	for i, z := range d.zones {
+		zn := strings.TrimSuffix(strings.ToLower(z.Name), ".")
+		if (name == zn || strings.HasSuffix(name, "."+zn)) && (zone == nil || len(z.Name) > len(zone.Name)) {
+			zone = &d.zones[i]
+		}
+	}
+	return zone
+}
+
+// containerRecord returns the record for name in zone, with the settings of
+// the zone overridden by the container's labels. Such records are created
+// when missing.
+func containerRecord(zone *zoneConfig, name string, labels map[string]string) (recordConfig, error) {
+	r := recordConfig{Name: "@", TTL: zone.TTL, Proxied: zone.Proxied, createMissing: true}
+	if zn := strings.TrimSuffix(zone.Name, "."); len(name) > len(zn) {
+		r.Name = name[:len(name)-len(zn)-1]
+	}
+
+	if s, ok := labels["dyn.ttl"]; ok {
+		ttl, err := strconv.Atoi(s)
+		if err != nil {
+			return r, fmt.Errorf("invalid dyn.ttl label %q", s)
+		}
+		r.TTL = ttl
+	}
+	if s, ok := labels["dyn.proxied"]; ok {
+		proxied, err := strconv.ParseBool(s)
+		if err != nil {
+			return r, fmt.Errorf("invalid dyn.proxied label %q", s)
+		}
+		r.Proxied = &proxied
+	}
+
+	mode := labels["dyn.mode"]
+	if mode == "" {
+		mode = viper.GetString("dns.mode")
+	}
+	types, err := recordTypes(mode)
+	if err != nil {
+		return r, fmt.Errorf("dyn.mode label: %v", err)
+	}
+	r.types = types
+
+	return r, nil
+}
+
+// watchDocker follows the Docker events, calling changed when a container
+// with records starts so they are registered right away rather than on the
+// next check
+func (d *daemon) watchDocker(ctx context.Context, changed func()) {
+	path := "/events?filters=" + dockerFilters(map[string][]string{
+		"type":  {"container"},
+		"event": {"start"},
+		"label": {dockerHostnameLabel},
+	})
+
+	go func() {
+		for {
+			err := d.followDockerEvents(ctx, path, changed)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnf("docker: watching events: %v", err)
+
+			select {
+			case <-time.After(10 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (d *daemon) followDockerEvents(ctx context.Context, path string, changed func()) error {
+	resp, err := d.docker.send(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Actor struct {
+				Attributes map[string]string `json:"Attributes"`
+			} `json:"Actor"`
+		}
+		err = dec.Decode(&event)
+		if err == io.EOF {
+			return fmt.Errorf("connection closed")
+		}
+		if err != nil {
+			return err
+		}
+
+		log.Infof("docker: container %s started, registering %s", event.Actor.Attributes["name"], event.Actor.Attributes[dockerHostnameLabel])
+		changed()
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/spf13/viper"
+)
+
+// observeDrift records the value a record was changed to outside of dyn, or
+// clears it when value is empty, reporting whether it differs from the last
+// one seen
+func (s *stateStore) observeDrift(name, recordType, value string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.Records[name+"/"+recordType]
+	if !ok {
+		return "", false
+	}
+	changed := r.Drifted != value
+	r.Drifted = value
+	return r.Content, changed
+}
+
+// checkDrift detects a record changed by someone else to a value other than
+// the public IP, e.g. by an accidental edit in the provider's dashboard. Such
+// a record was last set by dyn to the IP which is still current. Depending on
+// drift.mode, it's either restored or left alone with an alert. Reports
+// whether the record should be left alone.
+func (d *daemon) checkDrift(dyn *dynIP) bool {
+	mode := viper.GetString("drift.mode")
+	if mode == "" || dyn.RemoteIP == nil {
+		return false
+	}
+
+	name, t := dyn.FQDN(), dyn.Type
+	if dyn.RemoteIP.Equal(dyn.IP) || state.recordContent(name, t) != dyn.IP.String() {
+		state.observeDrift(name, t, "")
+		return false
+	}
+
+	alert := mode == "alert"
+	expected, changed := state.observeDrift(name, t, dyn.RemoteIP.String())
+	if changed {
+		action := "restoring it"
+		if alert {
+			action = "leaving it alone"
+		}
+		dyn.Log().Warnf("ALERT: DNS %s record %s was changed to %s outside of dyn, expected %s, %s", t, name, dyn.RemoteIP, expected, action)
+		notify(d.notifiers, event{Type: eventDrift, OldIP: expected, NewIP: dyn.RemoteIP.String(), Records: []string{name}, Restored: !alert})
+	}
+	return alert
+}
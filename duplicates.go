@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// syncDuplicates handles the other records sharing the name and type of a
+// synced record according to dns.duplicates: first leaves them alone, all
+// points them at the dynamic IP as well and collapse deletes them. Errors are
+// logged.
+func (d *daemon) syncDuplicates(ctx context.Context, dyn *dynIP) error {
+	if len(dyn.Duplicates) == 0 {
+		return nil
+	}
+
+	// Cleaning up implies collapsing duplicates, unless configured otherwise
+	policy := viper.GetString("dns.duplicates")
+	if policy == "" && viper.GetBool("dns.cleanup") {
+		policy = "collapse"
+	}
+
+	t := dyn.Type
+	switch policy {
+	case "all":
+		var failed int
+		for _, r := range dyn.Duplicates {
+			dup := *dyn
+			dup.Record = r
+			dup.RemoteIP = net.ParseIP(r.Content)
+			dup.Duplicates = nil
+			dup.Changed = false
+
+			err := dup.Sync(ctx)
+			if err != nil {
+				dup.Log().Errorf("error syncing duplicate DNS %s record %s: %s", t, dup.FQDN(), err)
+				failed++
+			}
+			dyn.Changed = dyn.Changed || dup.Changed
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d duplicate records failed to sync", failed, len(dyn.Duplicates))
+		}
+		return nil
+
+	case "collapse":
+		deleter, ok := unwrapProvider(dyn.Provider).(Deleter)
+		if !ok {
+			return nil
+		}
+
+		var failed int
+		for _, r := range dyn.Duplicates {
+			if d.dryRun {
+				dyn.Log().Infof("dry-run: would delete duplicate DNS %s record %s (%s)", t, dyn.FQDN(), r.Content)
+				continue
+			}
+
+			err := deleter.DeleteRecord(ctx, r)
+			if err != nil {
+				dyn.Log().Errorf("error deleting duplicate DNS %s record %s (%s): %s", t, dyn.FQDN(), r.Content, err)
+				failed++
+				continue
+			}
+			dyn.Log().Infof("deleted duplicate DNS %s record %s (%s)", t, dyn.FQDN(), r.Content)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d duplicate records failed to delete", failed, len(dyn.Duplicates))
+		}
+		return nil
+	}
+
+	dyn.Log().Warnf("DNS %s record %s has %d other records which are left alone, set dns.duplicates to update or delete them", t, dyn.FQDN(), len(dyn.Duplicates))
+	return nil
+}
+
+// cleanup deletes the address records of managed names whose type isn't
+// synced, e.g. AAAA records left over after switching dns.mode to ipv4, so
+// DNS matches the configuration exactly. Duplicates are collapsed while
+// syncing. Errors are logged.
+func (d *daemon) cleanup(ctx context.Context) {
+	for _, z := range d.zones {
+		for _, rc := range z.Records {
+			p := unwrapProvider(d.providerFor(rc))
+			lister, ok := p.(Lister)
+			deleter, ok2 := p.(Deleter)
+			if !ok || !ok2 {
+				log.Warnf("cleanup: the provider of %s can't list and delete records, skipping", rc.Name)
+				continue
+			}
+
+			for _, t := range []string{"A", "AAAA"} {
+				if containsString(rc.types, t) {
+					continue
+				}
+
+				dyn := dynIP{Zone: z.Name, Name: rc.Name, Type: t}
+				recs, err := lister.GetRecords(ctx, z.Name, dyn.FQDN(), t)
+				if err != nil {
+					log.Errorf("cleanup: error listing DNS %s records of %s: %s", t, dyn.FQDN(), err)
+					continue
+				}
+
+				for _, r := range recs {
+					if d.dryRun {
+						log.Infof("dry-run: would delete stale DNS %s record %s (%s)", t, dyn.FQDN(), r.Content)
+						continue
+					}
+
+					err = deleter.DeleteRecord(ctx, r)
+					if err != nil {
+						log.Errorf("cleanup: error deleting stale DNS %s record %s (%s): %s", t, dyn.FQDN(), r.Content, err)
+						continue
+					}
+					log.Infof("cleanup: deleted stale DNS %s record %s (%s)", t, dyn.FQDN(), r.Content)
+				}
+			}
+		}
+	}
+}
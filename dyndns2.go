@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+func newDyndns2(key string) (Provider, error) {
+	u := viper.GetString(key + ".url")
+	if u == "" {
+		return nil, fmt.Errorf("dyndns2: missing %s.url", key)
+	}
+	if _, err := url.Parse(u); err != nil {
+		return nil, fmt.Errorf("dyndns2: invalid %s.url: %v", key, err)
+	}
+
+	return provider.NewDyndns2(u, viper.GetString(key+".username"), viper.GetString(key+".password"))
+}
+
+func init() {
+	registerProvider("dyndns2", newDyndns2)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+func newDynv6(key string) (Provider, error) {
+	token := viper.GetString(key + ".token")
+	if token == "" {
+		return nil, fmt.Errorf("dynv6: missing %s.token", key)
+	}
+
+	return provider.NewDynv6(token), nil
+}
+
+func init() {
+	registerProvider("dynv6", newDynv6)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// echoCommand serves the address of each caller, so the http detector can use
+// a self-hosted echo service instead of a third party one
+func echoCommand(fs *flag.FlagSet) int {
+	addr := flagOrConfig(fs, "listen", "echo.listen")
+	cert := flagOrConfig(fs, "cert", "echo.tls.cert")
+	key := flagOrConfig(fs, "key", "echo.tls.key")
+	trustProxy := viper.GetBool("echo.trustProxy")
+	if addr == "" {
+		addr = ":8080"
+		if cert != "" {
+			addr = ":8443"
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r, trustProxy)
+		if ip == nil {
+			http.Error(w, "unable to determine address", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		if r.URL.Query().Get("format") == "json" {
+			writeJSON(w, http.StatusOK, map[string]string{"ip": ip.String()})
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, ip)
+	})
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	ctx, cancel := shutdownContext()
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	log.Infof("echo: listening on %s", addr)
+
+	var err error
+	if cert != "" {
+		err = srv.ListenAndServeTLS(cert, key)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Errorf("echo: %v", err)
+		return exitConfigError
+	}
+
+	return exitOK
+}
+
+// clientIP returns the address of the caller, taken from the last
+// X-Forwarded-For entry when running behind a trusted reverse proxy
+func clientIP(r *http.Request, trustProxy bool) net.IP {
+	if trustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			if ip := net.ParseIP(strings.TrimSpace(parts[len(parts)-1])); ip != nil {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
+// flagOrConfig returns the value of a string flag when given, otherwise the
+// configuration key
+func flagOrConfig(fs *flag.FlagSet, name, key string) string {
+	if fs.Changed(name) {
+		v, _ := fs.GetString(name)
+		return v
+	}
+	return viper.GetString(key)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	extcmd "github.com/ianmuscat/dyn/internal/command"
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+// execCommand runs the commands of hooks and actions, as for exec providers
+// and detectors
+var execCommand = extcmd.Run
+
+func newExecProvider(key string) (Provider, error) {
+	opts := provider.ExecOptions{
+		Command: viper.GetString(key + ".command"),
+		Args:    viper.GetStringSlice(key + ".args"),
+		Lookup:  viper.GetBool(key + ".lookup"),
+	}
+	if opts.Command == "" {
+		return nil, fmt.Errorf("exec: missing %s.command", key)
+	}
+
+	return provider.NewExec(opts), nil
+}
+
+func init() {
+	registerProvider("exec", newExecProvider)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// flappingWindow returns the period over which IP changes are counted
+func flappingWindow() time.Duration {
+	window := viper.GetDuration("flapping.window")
+	if window <= 0 {
+		window = time.Hour
+	}
+	return window
+}
+
+// observeFlapping counts the IP changes within window, and reports whether
+// the IP started or stopped flapping, i.e. changing more than limit times
+func (s *stateStore) observeFlapping(limit int, window time.Duration) (int, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-window)
+	recent := s.Changes[:0]
+	for _, t := range s.Changes {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	s.Changes = recent
+
+	flapping := len(recent) > limit
+	started := flapping && !s.Flapping
+	stopped := !flapping && s.Flapping
+	s.Flapping = flapping
+
+	return len(recent), started, stopped
+}
+
+// checkFlapping alerts when the public IP changed more than flapping.changes
+// times within flapping.window, which usually points at an ISP or detector
+// problem rather than legitimate changes
+func (d *daemon) checkFlapping(ip string) {
+	limit := viper.GetInt("flapping.changes")
+	if limit <= 0 {
+		return
+	}
+
+	window := flappingWindow()
+	n, started, stopped := state.observeFlapping(limit, window)
+	if started {
+		log.Warnf("ALERT: public IP changed %d times in the last %s, check the ISP connection and detector", n, window)
+		notify(d.notifiers, event{Type: eventFlapping, NewIP: ip, Changes: n})
+	}
+	if stopped {
+		log.Infof("public IP stable again, %d changes in the last %s", n, window)
+	}
+}
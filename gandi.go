@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+func newGandi(key string) (Provider, error) {
+	token := viper.GetString(key + ".token")
+	if token == "" {
+		return nil, fmt.Errorf("gandi: missing %s.token", key)
+	}
+
+	return provider.NewGandi(token), nil
+}
+
+func init() {
+	registerProvider("gandi", newGandi)
+}
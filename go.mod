@@ -1,9 +1,13 @@
 module github.com/ianmuscat/dyn
 
 require (
-	github.com/cloudflare/cloudflare-go v0.8.5
-	github.com/pkg/errors v0.8.0 // indirect
+	github.com/cloudflare/cloudflare-go v0.14.0
+	github.com/fsnotify/fsnotify v1.4.7
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/sirupsen/logrus v1.2.0
+	github.com/spf13/cobra v0.0.3
+	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.3.1
-	golang.org/x/time v0.0.0-20181108054448-85acf8d2951c // indirect
+	golang.org/x/sys v0.0.0-20211007075335-d3039528d8ac
+	modernc.org/sqlite v1.14.8
 )
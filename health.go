@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// healthStatus records the outcome of recent cycles for the health endpoints
+type healthStatus struct {
+	mu            sync.RWMutex
+	started       time.Time
+	lastDetection time.Time // end of the last cycle which detected the IP
+	lastSync      time.Time // end of the last cycle which synced every record
+	lastErr       error
+	nextCheck     time.Time // when the daemon runs its next cycle
+}
+
+var health = healthStatus{started: time.Now()}
+
+// record updates the status with the outcome of a cycle
+func (s *healthStatus) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.lastErr = err
+	if _, ok := err.(*detectionError); !ok {
+		s.lastDetection = now
+	}
+	if err == nil {
+		s.lastSync = now
+	}
+}
+
+// scheduled records when the next cycle is due
+func (s *healthStatus) scheduled(next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextCheck = next
+}
+
+type healthReport struct {
+	Status        string     `json:"status"`
+	LastDetection *time.Time `json:"lastDetection,omitempty"`
+	LastSync      *time.Time `json:"lastSync,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// report checks that the given time is within maxAge, allowing a grace period
+// of maxAge after startup before anything has completed
+func (s *healthStatus) report(last func(*healthStatus) time.Time, maxAge time.Duration) (int, healthReport) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r := healthReport{Status: "ok"}
+	if !s.lastDetection.IsZero() {
+		t := s.lastDetection
+		r.LastDetection = &t
+	}
+	if !s.lastSync.IsZero() {
+		t := s.lastSync
+		r.LastSync = &t
+	}
+	if s.lastErr != nil {
+		r.Error = s.lastErr.Error()
+	}
+
+	t := last(s)
+	if t.IsZero() {
+		t = s.started
+	}
+	if time.Since(t) > maxAge {
+		r.Status = "stale"
+		return http.StatusServiceUnavailable, r
+	}
+
+	return http.StatusOK, r
+}
+
+// serveHealth serves /healthz, which fails once the IP hasn't been detected
+// for maxAge, and /readyz, which fails once records haven't all been synced
+// for maxAge. The server is shut down when ctx is done.
+func serveHealth(ctx context.Context, addr string, maxAge time.Duration) {
+	handler := func(last func(*healthStatus) time.Time) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			code, report := health.report(last, maxAge)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(report)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", handler(func(s *healthStatus) time.Time { return s.lastDetection }))
+	mux.Handle("/readyz", handler(func(s *healthStatus) time.Time { return s.lastSync }))
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	closeWhenDone(ctx, srv)
+
+	go func() {
+		log.Infof("health: listening on %s", addr)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("health: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	_ "modernc.org/sqlite"
+)
+
+// History event types
+const (
+	historyIPChanged     = "ip_changed"
+	historyRecordUpdated = "record_updated"
+)
+
+// historyEntry is a detected IP change or a record update
+type historyEntry struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Type   string    `json:"type"`
+	Record string    `json:"record,omitempty"`
+	OldIP  string    `json:"oldIp,omitempty"`
+	NewIP  string    `json:"newIp"`
+}
+
+// historyStore keeps the history of IP changes and record updates
+type historyStore interface {
+	add(e historyEntry) error
+	// query returns the entries newer than since, of the named record
+	// unless name is empty, oldest first
+	query(since time.Time, name string) ([]historyEntry, error)
+}
+
+// newHistoryStore returns the configured history store: an SQLite database
+// under history.database, or a file of JSON lines under history.file. It
+// returns nil when neither is set.
+func newHistoryStore() historyStore {
+	if path := viper.GetString("history.database"); path != "" {
+		return &sqliteHistory{
+			path:      path,
+			retention: viper.GetDuration("history.retention"),
+		}
+	}
+	if path := viper.GetString("history.file"); path != "" {
+		return &fileHistory{path: path}
+	}
+	return nil
+}
+
+// recordHistory adds e to the history, when one is configured. Failures are
+// logged, as they shouldn't fail a sync.
+func recordHistory(e historyEntry) {
+	store := newHistoryStore()
+	if store == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	err := store.add(e)
+	if err != nil {
+		log.Errorf("history: %v", err)
+	}
+}
+
+// sqliteHistory keeps the history in an SQLite database, through a driver
+// written in Go so no cgo or sqlite3 command is needed. Entries older than
+// the retention, if any, are deleted as new ones are added.
+type sqliteHistory struct {
+	path      string
+	retention time.Duration
+}
+
+// sqliteTimeLayout is understood by SQLite's date and time functions, and
+// sorts chronologically as text
+const sqliteTimeLayout = "2006-01-02 15:04:05.000"
+
+const sqliteHistorySchema = `CREATE TABLE IF NOT EXISTS history (
+	time   TEXT NOT NULL,
+	event  TEXT NOT NULL,
+	type   TEXT NOT NULL,
+	record TEXT NOT NULL DEFAULT '',
+	old_ip TEXT NOT NULL DEFAULT '',
+	new_ip TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS history_time ON history (time);
+`
+
+func (h *sqliteHistory) add(e historyEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	db, err := h.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, "INSERT INTO history (time, event, type, record, old_ip, new_ip) VALUES (?, ?, ?, ?, ?, ?)",
+		e.Time.UTC().Format(sqliteTimeLayout), e.Event, e.Type, e.Record, e.OldIP, e.NewIP)
+	if err == nil && h.retention > 0 {
+		cutoff := time.Now().Add(-h.retention).UTC().Format(sqliteTimeLayout)
+		_, err = db.ExecContext(ctx, "DELETE FROM history WHERE time < ?", cutoff)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", h.path, err)
+	}
+	return nil
+}
+
+func (h *sqliteHistory) query(since time.Time, name string) ([]historyEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	db, err := h.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	q := "SELECT time, event, type, record, old_ip, new_ip FROM history WHERE 1"
+	var args []interface{}
+	if !since.IsZero() {
+		q += " AND time >= ?"
+		args = append(args, since.UTC().Format(sqliteTimeLayout))
+	}
+	if name != "" {
+		q += " AND record = ?"
+		args = append(args, name)
+	}
+	rows, err := db.QueryContext(ctx, q+" ORDER BY time", args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", h.path, err)
+	}
+	defer rows.Close()
+
+	var entries []historyEntry
+	for rows.Next() {
+		var e historyEntry
+		var t string
+		err = rows.Scan(&t, &e.Event, &e.Type, &e.Record, &e.OldIP, &e.NewIP)
+		if err == nil {
+			e.Time, err = time.Parse(sqliteTimeLayout, t)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", h.path, err)
+		}
+		e.Time = e.Time.Local()
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", h.path, err)
+	}
+	return entries, nil
+}
+
+// open opens the database, creating the history table if needed
+func (h *sqliteHistory) open(ctx context.Context) (*sql.DB, error) {
+	// Wait for a concurrent writer, such as "dyn history" reading while the
+	// daemon adds an entry
+	db, err := sql.Open("sqlite", "file:"+h.path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", h.path, err)
+	}
+
+	_, err = db.ExecContext(ctx, sqliteHistorySchema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %v", h.path, err)
+	}
+	return db, nil
+}
+
+// fileHistory appends the history to a file as JSON lines, which needs
+// nothing besides dyn, at the cost of scanning the whole file on queries
+type fileHistory struct {
+	path string
+}
+
+func (h *fileHistory) add(e historyEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func (h *fileHistory) query(since time.Time, name string) ([]historyEntry, error) {
+	f, err := os.Open(h.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e historyEntry
+		// Skip lines which can't be parsed, such as one truncated by a crash
+		if json.Unmarshal(s.Bytes(), &e) != nil {
+			continue
+		}
+		if e.Time.Before(since) || (name != "" && e.Record != name) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, s.Err()
+}
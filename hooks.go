@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dynsync "github.com/ianmuscat/dyn/pkg/sync"
+	"github.com/spf13/viper"
+)
+
+// Hooks run around record updates
+const (
+	hookPreUpdate  = "preUpdate"
+	hookPostUpdate = "postUpdate"
+)
+
+// updateHooks returns the hooks of records managed by p: the configured
+// commands, and deferral of updates within the rate limit of p
+func updateHooks(p Provider) dynsync.Hooks {
+	h := dynsync.Hooks{
+		BeforeUpdate: func(ctx context.Context, t *dynIP) error {
+			return runHook(ctx, t, hookPreUpdate)
+		},
+		// Failures are logged, as the record has been updated already
+		AfterUpdate: func(ctx context.Context, t *dynIP) {
+			err := runHook(ctx, t, hookPostUpdate)
+			if err != nil {
+				t.Log().Error(err)
+			}
+		},
+	}
+	if rl, ok := p.(*rateLimitedProvider); ok {
+		h.Defer = rl.deferUpdate
+	}
+	return h
+}
+
+// runHook runs the command under hooks.<name>, if any, for an update of the
+// record from its remote IP to the dynamic IP. Hooks get the record in DYN_*
+// environment variables, e.g. to restart VPN tunnels or update firewall rules,
+// and are killed after hooks.timeout.
+func runHook(ctx context.Context, t *dynIP, name string) error {
+	key := "hooks." + name
+	command := viper.GetString(key + ".command")
+	if command == "" {
+		return nil
+	}
+
+	timeout := viper.GetDuration("hooks.timeout")
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	old := ""
+	if t.RemoteIP != nil {
+		old = t.RemoteIP.String()
+	}
+	env := []string{
+		"DYN_HOOK=" + name,
+		"DYN_ZONE=" + t.Zone,
+		"DYN_RECORD=" + t.FQDN(),
+		"DYN_TYPE=" + t.Type,
+		"DYN_OLD_IP=" + old,
+		"DYN_NEW_IP=" + t.IP.String(),
+	}
+
+	out, err := execCommand(ctx, command, viper.GetStringSlice(key+".args"), env, nil)
+	if err != nil {
+		return fmt.Errorf("%s hook: %v", name, err)
+	}
+	if out != "" {
+		t.Log().Infof("%s hook: %s", name, out)
+	}
+	return nil
+}
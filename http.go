@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+// The helpers for HTTP APIs live in internal/httpapi, shared with the
+// providers and detectors of pkg
+type httpError = httpapi.Error
+
+var (
+	isHTTPStatus   = httpapi.IsStatus
+	httpDo         = httpapi.Do
+	httpJSON       = httpapi.JSON
+	newJSONRequest = httpapi.NewJSONRequest
+	unixTransport  = httpapi.UnixTransport
+)
+
+// caTransport returns a transport trusting the CA certificates in file in
+// addition to the system ones, e.g. for proxies intercepting TLS or private
+// CAs
+func caTransport(file string) (*http.Transport, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return transport, nil
+}
@@ -0,0 +1,338 @@
+// Package awsauth resolves AWS credentials and signs requests to AWS APIs,
+// for the Route 53 provider along with secrets and actions using AWS
+package awsauth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials authenticate requests to AWS APIs
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// Chain resolves AWS credentials the same way the AWS SDKs do:
+// explicit credentials, environment variables, the shared credentials file,
+// ECS container credentials and finally the EC2 instance metadata service.
+type Chain struct {
+	explicit Credentials
+
+	mu    sync.Mutex
+	creds Credentials
+}
+
+// NewChain returns a chain starting with the explicit credentials, which may
+// be left empty
+func NewChain(explicit Credentials) *Chain {
+	return &Chain{explicit: explicit}
+}
+
+// Get returns the first credentials found, cached until shortly before they
+// expire
+func (c *Chain) Get(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Reuse cached credentials until shortly before they expire
+	if c.creds.AccessKeyID != "" && (c.creds.Expiration.IsZero() || time.Until(c.creds.Expiration) > 5*time.Minute) {
+		return c.creds, nil
+	}
+
+	sources := []func(context.Context) (Credentials, error){
+		c.fromExplicit,
+		credentialsFromEnv,
+		credentialsFromFile,
+		credentialsFromContainer,
+		credentialsFromInstance,
+	}
+
+	for _, source := range sources {
+		creds, err := source(ctx)
+		if err != nil {
+			return Credentials{}, err
+		}
+		if creds.AccessKeyID != "" {
+			c.creds = creds
+			return creds, nil
+		}
+	}
+
+	return Credentials{}, fmt.Errorf("aws: no credentials found")
+}
+
+func (c *Chain) fromExplicit(ctx context.Context) (Credentials, error) {
+	return c.explicit, nil
+}
+
+func credentialsFromEnv(ctx context.Context) (Credentials, error) {
+	return Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}, nil
+}
+
+func credentialsFromFile(ctx context.Context) (Credentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Credentials{}, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return Credentials{}, nil
+	}
+	if err != nil {
+		return Credentials{}, fmt.Errorf("aws: %v", err)
+	}
+	defer f.Close()
+
+	// Parse the INI formatted file, keeping only the selected profile
+	var creds Credentials
+	section := ""
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+
+	return creds, s.Err()
+}
+
+// metadataCredentials is the document served by both the ECS and EC2
+// credential endpoints
+type metadataCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      time.Time
+}
+
+func (m metadataCredentials) credentials() Credentials {
+	return Credentials{
+		AccessKeyID:     m.AccessKeyID,
+		SecretAccessKey: m.SecretAccessKey,
+		SessionToken:    m.Token,
+		Expiration:      m.Expiration,
+	}
+}
+
+func credentialsFromContainer(ctx context.Context) (Credentials, error) {
+	uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+	if uri == "" {
+		return Credentials{}, nil
+	}
+
+	req, err := http.NewRequest("GET", "http://169.254.170.2"+uri, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	var m metadataCredentials
+	err = metadataGet(ctx, req, &m)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("aws: container credentials: %v", err)
+	}
+
+	return m.credentials(), nil
+}
+
+func credentialsFromInstance(ctx context.Context) (Credentials, error) {
+	const endpoint = "http://169.254.169.254/latest"
+
+	// The instance metadata service is unreachable outside of EC2, so don't
+	// let it stall credential resolution for long
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	// Request an IMDSv2 session token
+	req, err := http.NewRequest("PUT", endpoint+"/api/token", nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return Credentials{}, nil
+	}
+	token, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return Credentials{}, nil
+	}
+
+	// Look up the name of the instance role
+	req, err = http.NewRequest("GET", endpoint+"/meta-data/iam/security-credentials/", nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+
+	resp, err = http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return Credentials{}, nil
+	}
+	role, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return Credentials{}, nil
+	}
+
+	name := strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0])
+	req, err = http.NewRequest("GET", endpoint+"/meta-data/iam/security-credentials/"+name, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+
+	var m metadataCredentials
+	err = metadataGet(ctx, req, &m)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("aws: instance credentials: %v", err)
+	}
+
+	return m.credentials(), nil
+}
+
+func metadataGet(ctx context.Context, req *http.Request, out interface{}) error {
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// SignV4 signs the request using AWS Signature Version 4
+func SignV4(req *http.Request, body []byte, creds Credentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// Build the canonical headers, which must include the host
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(v url.Values) string {
+	// AWS requires spaces to be encoded as %20 rather than +
+	return strings.Replace(v.Encode(), "+", "%20", -1)
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
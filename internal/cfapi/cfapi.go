@@ -0,0 +1,28 @@
+// Package cfapi adapts calls of the Cloudflare client library, shared by the
+// provider and the access rule action
+package cfapi
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	cf "github.com/cloudflare/cloudflare-go"
+)
+
+// ZoneIDByName returns the ID of the zone with the given name, like the
+// library's own, which doesn't accept a context
+func ZoneIDByName(ctx context.Context, api *cf.API, name string) (string, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	res, err := api.ListZonesContext(ctx, cf.WithZoneFilters(name, api.AccountID, ""))
+	if err != nil {
+		return "", err
+	}
+
+	for _, zone := range res.Result {
+		if zone.Name == name {
+			return zone.ID, nil
+		}
+	}
+	return "", errors.New("Zone could not be found")
+}
@@ -0,0 +1,115 @@
+// Package httpapi holds the helpers shared by the clients of HTTP APIs, such
+// as providers and the detectors querying local daemons
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Error is returned for responses with a non-2xx status code
+type Error struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       string
+}
+
+func (e *Error) Error() string {
+	body := strings.TrimSpace(e.Body)
+	if len(body) > 256 {
+		body = body[:256] + "..."
+	}
+	if body == "" {
+		return fmt.Sprintf("unexpected status %s", e.Status)
+	}
+	return fmt.Sprintf("unexpected status %s: %s", e.Status, body)
+}
+
+// IsStatus reports whether err is an Error with the given status code
+func IsStatus(err error, code int) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == code
+}
+
+// Do sends the request with the default client and returns the response
+// body, or an Error if the response status is not successful
+func Do(ctx context.Context, req *http.Request) ([]byte, error) {
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &Error{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Header:     resp.Header,
+			Body:       string(data),
+		}
+	}
+
+	return data, nil
+}
+
+// JSON sends the request and decodes the JSON response body into out,
+// unless out is nil
+func JSON(ctx context.Context, req *http.Request, out interface{}) error {
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	data, err := Do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// NewJSONRequest builds a request with body encoded as JSON, unless body is
+// nil
+func NewJSONRequest(method, url string, body interface{}) (*http.Request, error) {
+	if body == nil {
+		return http.NewRequest(method, url, nil)
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
+
+// UnixTransport returns a transport connecting to the unix socket at path
+// whatever the host of requests, for APIs of local daemons
+func UnixTransport(path string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", path)
+	}
+	return transport
+}
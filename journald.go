@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// journalHook sends log entries to systemd-journald using its native
+// protocol, with the entry's fields as journal fields
+type journalHook struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+func newJournalHook(identifier string) (*journalHook, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("journald: %v", err)
+	}
+	return &journalHook{conn: conn, identifier: identifier}, nil
+}
+
+func (h *journalHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *journalHook) Fire(e *log.Entry) error {
+	var b bytes.Buffer
+	appendJournalField(&b, "MESSAGE", e.Message)
+	appendJournalField(&b, "PRIORITY", strconv.Itoa(journalPriority(e.Level)))
+	appendJournalField(&b, "SYSLOG_IDENTIFIER", h.identifier)
+	for k, v := range e.Data {
+		appendJournalField(&b, journalFieldName(k), fmt.Sprint(v))
+	}
+
+	_, err := h.conn.Write(b.Bytes())
+	return err
+}
+
+func (h *journalHook) Close() error {
+	return h.conn.Close()
+}
+
+// appendJournalField appends a field in the journal's export format, using
+// the length prefixed form for values spanning several lines
+func appendJournalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journalFieldName converts a logrus field name into a valid journal field
+// name, which only holds upper case letters, digits and underscores
+func journalFieldName(k string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, k)
+	return strings.TrimLeft(name, "_")
+}
+
+// journalPriority maps a logrus level to a syslog priority
+func journalPriority(level log.Level) int {
+	switch level {
+	case log.PanicLevel:
+		return 0
+	case log.FatalLevel:
+		return 2
+	case log.ErrorLevel:
+		return 3
+	case log.WarnLevel:
+		return 4
+	case log.InfoLevel:
+		return 6
+	}
+	return 7
+}
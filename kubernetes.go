@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// kubernetesServiceAccount is where pods get the credentials of their service
+// account mounted
+const kubernetesServiceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetesClient calls the Kubernetes API from within a pod, with the
+// credentials of its service account
+type kubernetesClient struct {
+	host      string
+	namespace string
+	tokenFile string
+	client    *http.Client
+}
+
+func newKubernetesClient() (*kubernetesClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("kubernetes: not running in a cluster, KUBERNETES_SERVICE_HOST is not set")
+	}
+
+	ca, err := ioutil.ReadFile(kubernetesServiceAccount + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("kubernetes: no certificates found in %s/ca.crt", kubernetesServiceAccount)
+	}
+
+	namespace, err := ioutil.ReadFile(kubernetesServiceAccount + "/namespace")
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %v", err)
+	}
+
+	// The cluster's CA only signs the API server, so proxies don't apply
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return &kubernetesClient{
+		host:      "https://" + net.JoinHostPort(host, port),
+		namespace: strings.TrimSpace(string(namespace)),
+		tokenFile: kubernetesServiceAccount + "/token",
+		client:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// do sends a request to the API, decoding the JSON response into out unless
+// it's nil
+func (k *kubernetesClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	resp, err := k.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil || out == nil || len(data) == 0 {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// watch calls fn with the object of every event of a watch on path, until
+// the API server ends it or ctx is done
+func (k *kubernetesClient) watch(ctx context.Context, path string, fn func(eventType string, object json.RawMessage)) error {
+	resp, err := k.send(ctx, "GET", path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		err = dec.Decode(&event)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event.Type == "ERROR" {
+			return fmt.Errorf("kubernetes: watch failed: %s", event.Object)
+		}
+		fn(event.Type, event.Object)
+	}
+}
+
+// send sends a request to the API, returning an httpError for unsuccessful
+// responses. The token is read on every request, as the kubelet rotates it.
+func (k *kubernetesClient) send(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	token, err := ioutil.ReadFile(k.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: %v", err)
+	}
+
+	req, err := newJSONRequest(method, k.host+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := k.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, &httpError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Header:     resp.Header,
+			Body:       string(data),
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// kubernetesRef identifies the Service or Ingress whose load balancer
+// addresses a record publishes
+type kubernetesRef struct {
+	kind      string // service or ingress
+	namespace string
+	name      string
+}
+
+func (r kubernetesRef) String() string {
+	return fmt.Sprintf("%s/%s/%s", r.kind, r.namespace, r.name)
+}
+
+// path returns the API path of the object, or of its collection when
+// collection is set
+func (r kubernetesRef) path(collection bool) string {
+	base, resource := "/api/v1", "services"
+	if r.kind == "ingress" {
+		base, resource = "/apis/networking.k8s.io/v1", "ingresses"
+	}
+	path := fmt.Sprintf("%s/namespaces/%s/%s", base, url.PathEscape(r.namespace), resource)
+	if collection {
+		return path
+	}
+	return path + "/" + url.PathEscape(r.name)
+}
+
+// kubernetesRefOf returns the object a record publishes, given as
+// <namespace>/<name> or <name> in the pod's namespace
+func kubernetesRefOf(r recordConfig, namespace string) (kubernetesRef, bool) {
+	ref := kubernetesRef{kind: "service", namespace: namespace, name: r.Service}
+	if r.Ingress != "" {
+		ref.kind, ref.name = "ingress", r.Ingress
+	}
+	if ref.name == "" {
+		return ref, false
+	}
+	if i := strings.Index(ref.name, "/"); i >= 0 {
+		ref.namespace, ref.name = ref.name[:i], ref.name[i+1:]
+	}
+	return ref, true
+}
+
+// kubernetesObject holds the fields of Services and Ingresses dyn reads
+type kubernetesObject struct {
+	Spec struct {
+		Type string `json:"type"`
+	} `json:"spec"`
+	Status struct {
+		LoadBalancer struct {
+			Ingress []struct {
+				IP       string `json:"ip"`
+				Hostname string `json:"hostname"`
+			} `json:"ingress"`
+		} `json:"loadBalancer"`
+	} `json:"status"`
+}
+
+// addresses returns the load balancer IPs of the object
+func (o kubernetesObject) addresses() []net.IP {
+	var ips []net.IP
+	for _, i := range o.Status.LoadBalancer.Ingress {
+		if ip := net.ParseIP(i.IP); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// kubernetesRefs returns the objects the configured records publish, sorted
+func (d *daemon) kubernetesRefs() []kubernetesRef {
+	seen := map[kubernetesRef]bool{}
+	var refs []kubernetesRef
+	for _, z := range d.zones {
+		for _, r := range z.Records {
+			ref, ok := kubernetesRefOf(r, d.kubernetes.namespace)
+			if ok && !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, ref)
+			}
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].String() < refs[j].String() })
+	return refs
+}
+
+// syncKubernetes points the records publishing Services and Ingresses at
+// their load balancer addresses, returning the number of records and how
+// many of them failed to sync
+func (d *daemon) syncKubernetes(ctx context.Context) (int, int) {
+	failed, total := 0, 0
+	for _, ref := range d.kubernetesRefs() {
+		targets := func(t string) []dynIP {
+			return d.targetsWhere(t, func(r recordConfig) bool {
+				other, ok := kubernetesRefOf(r, d.kubernetes.namespace)
+				return ok && other == ref
+			})
+		}
+
+		var obj kubernetesObject
+		err := d.kubernetes.do(ctx, "GET", ref.path(false), nil, &obj)
+		if err == nil && ref.kind == "service" && obj.Spec.Type != "LoadBalancer" {
+			err = fmt.Errorf("not of type LoadBalancer")
+		}
+		if err != nil {
+			log.Errorf("kubernetes: %s: %v", ref, err)
+			n := len(targets("A")) + len(targets("AAAA"))
+			failed += n
+			total += n
+			continue
+		}
+
+		for _, t := range []string{"A", "AAAA"} {
+			records := targets(t)
+			if len(records) == 0 {
+				continue
+			}
+
+			var ip net.IP
+			for _, a := range obj.addresses() {
+				if (a.To4() != nil) == (t == "A") {
+					ip = a
+					break
+				}
+			}
+			if ip == nil {
+				// Load balancers such as AWS ELBs only have a host name,
+				// and others take a while to be assigned an address
+				log.Warnf("kubernetes: %s has no %s load balancer address", ref, recordFamily(t))
+				continue
+			}
+
+			if !viper.GetBool("detector.allowPrivate") {
+				err = checkPublicIP(ip)
+				if err != nil {
+					log.Warnf("kubernetes: %s: rejecting load balancer IP: %v", ref, err)
+					continue
+				}
+			}
+
+			n, f := d.syncRecords(ctx, t, ip, records)
+			failed += f
+			total += n
+		}
+	}
+	return total, failed
+}
+
+// watchKubernetes watches the objects the records publish, calling changed
+// when their load balancer addresses change so records are updated right
+// away rather than on the next check. Objects referenced after a reload are
+// only watched after a restart.
+func (d *daemon) watchKubernetes(ctx context.Context, changed func()) {
+	for _, ref := range d.kubernetesRefs() {
+		go func(ref kubernetesRef) {
+			q := url.Values{}
+			q.Set("watch", "true")
+			q.Set("fieldSelector", "metadata.name="+ref.name)
+			q.Set("timeoutSeconds", "600")
+			path := ref.path(true) + "?" + q.Encode()
+
+			// The first event reports the addresses the records were
+			// synced with on startup
+			last, seen := "", false
+			for {
+				err := d.kubernetes.watch(ctx, path, func(eventType string, object json.RawMessage) {
+					var obj kubernetesObject
+					if json.Unmarshal(object, &obj) != nil {
+						return
+					}
+					addresses := fmt.Sprint(obj.addresses())
+					if eventType == "DELETED" {
+						addresses = "[]"
+					}
+					if seen && addresses != last {
+						log.Infof("kubernetes: %s load balancer addresses changed to %s", ref, addresses)
+						changed()
+					}
+					last, seen = addresses, true
+				})
+				if ctx.Err() != nil {
+					return
+				}
+				if err == nil {
+					// The API server ended the watch after its timeout
+					continue
+				}
+
+				log.Warnf("kubernetes: watching %s: %v", ref, err)
+				select {
+				case <-time.After(5 * time.Second):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ref)
+	}
+}
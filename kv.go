@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// kvPublished holds the addresses last published to each store, so they are
+// only written again when they change
+var kvPublished = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// publishKV publishes the public IPs to Consul and etcd when configured, for
+// other infrastructure to consume without querying DNS. Failures are logged
+// and retried after the next cycle.
+func publishKV() {
+	s := state.snapshot()
+	ips := map[string]string{}
+	for t, ip := range s.IPs {
+		ips[recordFamily(t)] = ip.IP
+	}
+	if len(ips) == 0 {
+		return
+	}
+	published := ips["ipv4"] + " " + ips["ipv6"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	stores := []struct {
+		name    string
+		enabled bool
+		publish func(context.Context, map[string]string) error
+	}{
+		{"consul", viper.IsSet("consul.address"), publishConsul},
+		{"etcd", viper.IsSet("etcd.address"), publishEtcd},
+	}
+
+	kvPublished.Lock()
+	defer kvPublished.Unlock()
+
+	for _, store := range stores {
+		if !store.enabled || kvPublished.m[store.name] == published {
+			continue
+		}
+
+		err := store.publish(ctx, ips)
+		if err != nil {
+			log.Errorf("%s: %v", store.name, err)
+			continue
+		}
+		kvPublished.m[store.name] = published
+		log.Debugf("%s: published %s", store.name, strings.TrimSpace(published))
+	}
+}
+
+// recordFamily returns the address family of a record type, as used in keys
+func recordFamily(recordType string) string {
+	if recordType == "AAAA" {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// publishConsul writes the addresses to <consul.key>/ipv4 and /ipv6 in the
+// Consul KV store, and registers consul.service with the agent when set
+func publishConsul(ctx context.Context, ips map[string]string) error {
+	prefix := strings.Trim(viper.GetString("consul.key"), "/")
+	if prefix == "" {
+		prefix = "dyn"
+	}
+
+	for family, ip := range ips {
+		err := consulCall(ctx, "PUT", "/v1/kv/"+prefix+"/"+family, ip, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	name := viper.GetString("consul.service.name")
+	if name == "" {
+		return nil
+	}
+
+	// The service is reachable at the public IPv4 address, or the IPv6 one
+	// without it. Both are available as tagged addresses.
+	port := viper.GetInt("consul.service.port")
+	address := ips["ipv4"]
+	if address == "" {
+		address = ips["ipv6"]
+	}
+	tagged := map[string]interface{}{}
+	for family, ip := range ips {
+		tagged["wan_"+family] = map[string]interface{}{"Address": ip, "Port": port}
+	}
+
+	service := map[string]interface{}{
+		"ID":              name,
+		"Name":            name,
+		"Address":         address,
+		"Port":            port,
+		"Tags":            viper.GetStringSlice("consul.service.tags"),
+		"Meta":            ips,
+		"TaggedAddresses": tagged,
+	}
+	return consulCall(ctx, "PUT", "/v1/agent/service/register", service, nil)
+}
+
+// consulCall sends a request to the Consul agent at consul.address. A string
+// body is sent as is, others are encoded as JSON.
+func consulCall(ctx context.Context, method, path string, body, out interface{}) error {
+	endpoint := strings.TrimSuffix(viper.GetString("consul.address"), "/") + path
+
+	var req *http.Request
+	var err error
+	if s, ok := body.(string); ok {
+		req, err = http.NewRequest(method, endpoint, strings.NewReader(s))
+	} else {
+		req, err = newJSONRequest(method, endpoint, body)
+	}
+	if err != nil {
+		return err
+	}
+	if token := viper.GetString("consul.token"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	return httpJSON(ctx, req, out)
+}
+
+// publishEtcd writes the addresses to <etcd.key>/ipv4 and /ipv6
+func publishEtcd(ctx context.Context, ips map[string]string) error {
+	prefix := strings.TrimSuffix(viper.GetString("etcd.key"), "/")
+	if prefix == "" {
+		prefix = "/dyn"
+	}
+
+	for family, ip := range ips {
+		err := etcdCall(ctx, "/v3/kv/put", map[string]string{
+			"key":   etcdBytes(prefix + "/" + family),
+			"value": etcdBytes(ip),
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// etcdToken is the authentication token of the etcd user, requested on the
+// first call and again once it has expired
+var etcdToken struct {
+	sync.Mutex
+	token string
+}
+
+// etcdCall sends a request to the gRPC gateway of etcd v3 at etcd.address,
+// authenticating when etcd.username is set
+func etcdCall(ctx context.Context, path string, body, out interface{}) error {
+	address := strings.TrimSuffix(viper.GetString("etcd.address"), "/")
+	username := viper.GetString("etcd.username")
+
+	for retry := 0; ; retry++ {
+		etcdToken.Lock()
+		if username != "" && etcdToken.token == "" {
+			req, err := newJSONRequest("POST", address+"/v3/auth/authenticate", map[string]string{
+				"name":     username,
+				"password": viper.GetString("etcd.password"),
+			})
+			if err != nil {
+				etcdToken.Unlock()
+				return err
+			}
+			var auth struct {
+				Token string `json:"token"`
+			}
+			err = httpJSON(ctx, req, &auth)
+			if err != nil {
+				etcdToken.Unlock()
+				return fmt.Errorf("authentication failed: %v", err)
+			}
+			etcdToken.token = auth.Token
+		}
+		token := etcdToken.token
+		etcdToken.Unlock()
+
+		req, err := newJSONRequest("POST", address+path, body)
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+
+		err = httpJSON(ctx, req, out)
+		if isHTTPStatus(err, http.StatusUnauthorized) && token != "" && retry == 0 {
+			etcdToken.Lock()
+			etcdToken.token = ""
+			etcdToken.Unlock()
+			continue
+		}
+		return err
+	}
+}
+
+// etcdBytes encodes s the way the gateway expects bytes fields
+func etcdBytes(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
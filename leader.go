@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// elector campaigns for leadership through a lock shared by the instances
+type elector interface {
+	// campaign acquires or renews the lock for ttl, reporting whether this
+	// instance holds it
+	campaign(ctx context.Context) (bool, error)
+	// resign releases the lock when held
+	resign(ctx context.Context) error
+}
+
+// leadership lets a single one of several instances update records, the
+// others standing by until its lock expires. The lock is renewed in the
+// background, and leadership is only assumed while the last renewal is more
+// recent than the TTL.
+type leadership struct {
+	elector elector
+	id      string
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	leading bool
+	renewed time.Time
+}
+
+// newLeadership returns the leader election configured under leader, nil
+// when every instance may update records
+func newLeadership() (*leadership, error) {
+	backend := viper.GetString("leader.backend")
+	if backend == "" {
+		return nil, nil
+	}
+
+	id := viper.GetString("leader.id")
+	if id == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("leader: %v", err)
+		}
+		id = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+
+	ttl := 30 * time.Second
+	if viper.IsSet("leader.ttl") {
+		ttl = viper.GetDuration("leader.ttl")
+	}
+	if ttl < 10*time.Second {
+		return nil, fmt.Errorf("leader: ttl must be at least 10s")
+	}
+
+	key := viper.GetString("leader.key")
+	if key == "" {
+		key = "dyn/leader"
+	}
+
+	var e elector
+	switch backend {
+	case "file":
+		path := viper.GetString("leader.file")
+		if path == "" {
+			return nil, fmt.Errorf("leader: missing leader.file")
+		}
+		e = &fileElector{path: path, id: id, ttl: ttl}
+	case "consul":
+		if !viper.IsSet("consul.address") {
+			return nil, fmt.Errorf("leader: missing consul.address")
+		}
+		e = &consulElector{key: strings.Trim(key, "/"), id: id, ttl: ttl}
+	case "etcd":
+		if !viper.IsSet("etcd.address") {
+			return nil, fmt.Errorf("leader: missing etcd.address")
+		}
+		e = &etcdElector{key: "/" + strings.Trim(key, "/"), id: id, ttl: ttl}
+	case "kubernetes":
+		client, err := newKubernetesClient()
+		if err != nil {
+			return nil, fmt.Errorf("leader: %v", err)
+		}
+		name := viper.GetString("leader.lease")
+		if name == "" {
+			name = "dyn"
+		}
+		e = &kubernetesElector{client: client, name: name, id: id, ttl: ttl}
+	default:
+		return nil, fmt.Errorf("leader: unknown backend %q, expected file, consul, etcd or kubernetes", backend)
+	}
+
+	return &leadership{elector: e, id: id, ttl: ttl}, nil
+}
+
+// run campaigns every third of the TTL until ctx is done, calling elected
+// whenever this instance becomes the leader, and resigns on the way out so
+// another instance can take over right away
+func (l *leadership) run(ctx context.Context, elected func()) {
+	log.Infof("leader: campaigning as %s", l.id)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		cctx, cancel := context.WithTimeout(ctx, l.ttl/3)
+		leading, err := l.elector.campaign(cctx)
+		cancel()
+		if ctx.Err() != nil {
+			break
+		}
+
+		l.mu.Lock()
+		was := l.leading && time.Since(l.renewed) < l.ttl
+		switch {
+		case err != nil:
+			log.Errorf("leader: %v", err)
+		case leading:
+			l.leading, l.renewed = true, time.Now()
+		default:
+			l.leading = false
+		}
+		is := l.leading && time.Since(l.renewed) < l.ttl
+		l.mu.Unlock()
+
+		if is && !was {
+			log.Info("leader: elected, updating records")
+			elected()
+		} else if was && !is {
+			log.Warn("leader: lost leadership, standing by")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	rctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if l.isLeader() {
+		err := l.elector.resign(rctx)
+		if err != nil {
+			log.Errorf("leader: unable to resign: %v", err)
+		}
+	}
+}
+
+// isLeader reports whether this instance may update records, always true
+// without leader election
+func (l *leadership) isLeader() bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.leading && time.Since(l.renewed) < l.ttl
+}
+
+// fileElector holds the lock through a lease file on storage shared by the
+// instances, e.g. NFS, rewritten with the holder and expiry on every renewal.
+// Leases are read and written while holding an flock on <file>.lock, so two
+// instances can't both take over an expired one. The clocks of the hosts must
+// be in sync.
+type fileElector struct {
+	path string
+	id   string
+	ttl  time.Duration
+}
+
+type fileLease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+func (e *fileElector) read() (fileLease, error) {
+	var lease fileLease
+	data, err := ioutil.ReadFile(e.path)
+	if os.IsNotExist(err) {
+		return lease, nil
+	}
+	if err != nil {
+		return lease, err
+	}
+	// A partially written file counts as expired
+	if json.Unmarshal(data, &lease) != nil {
+		return fileLease{}, nil
+	}
+	return lease, nil
+}
+
+func (e *fileElector) campaign(ctx context.Context) (bool, error) {
+	unlock, err := lockFile(e.path + ".lock")
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	lease, err := e.read()
+	if err != nil {
+		return false, err
+	}
+	if lease.Holder != e.id && time.Now().Before(lease.Expires) {
+		return false, nil
+	}
+
+	data, err := json.Marshal(fileLease{Holder: e.id, Expires: time.Now().Add(e.ttl)})
+	if err != nil {
+		return false, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(e.path), ".leader")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), e.path)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (e *fileElector) resign(ctx context.Context) error {
+	unlock, err := lockFile(e.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	lease, err := e.read()
+	if err != nil || lease.Holder != e.id {
+		return err
+	}
+	return os.Remove(e.path)
+}
+
+// consulElector holds the lock through a Consul session acquiring a key. The
+// session is invalidated once it isn't renewed within the TTL, releasing the
+// key.
+type consulElector struct {
+	key     string
+	id      string
+	ttl     time.Duration
+	session string
+}
+
+func (e *consulElector) campaign(ctx context.Context) (bool, error) {
+	if e.session != "" {
+		err := consulCall(ctx, "PUT", "/v1/session/renew/"+e.session, nil, nil)
+		if isHTTPStatus(err, http.StatusNotFound) {
+			e.session = ""
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	if e.session == "" {
+		var session struct {
+			ID string `json:"ID"`
+		}
+		err := consulCall(ctx, "PUT", "/v1/session/create", map[string]string{
+			"Name":      "dyn leader " + e.id,
+			"TTL":       e.ttl.String(),
+			"LockDelay": "0s",
+		}, &session)
+		if err != nil {
+			return false, err
+		}
+		e.session = session.ID
+	}
+
+	var acquired bool
+	err := consulCall(ctx, "PUT", "/v1/kv/"+e.key+"?acquire="+url.QueryEscape(e.session), e.id, &acquired)
+	return acquired, err
+}
+
+func (e *consulElector) resign(ctx context.Context) error {
+	if e.session == "" {
+		return nil
+	}
+	err := consulCall(ctx, "PUT", "/v1/kv/"+e.key+"?release="+url.QueryEscape(e.session), e.id, nil)
+	if err != nil {
+		return err
+	}
+	err = consulCall(ctx, "PUT", "/v1/session/destroy/"+e.session, nil, nil)
+	e.session = ""
+	return err
+}
+
+// etcdElector holds the lock through a key attached to a lease, created only
+// when the key doesn't exist. The key is deleted once the lease isn't kept
+// alive within the TTL.
+type etcdElector struct {
+	key   string
+	id    string
+	ttl   time.Duration
+	lease string
+}
+
+func (e *etcdElector) campaign(ctx context.Context) (bool, error) {
+	if e.lease != "" {
+		var resp struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}
+		err := etcdCall(ctx, "/v3/lease/keepalive", map[string]string{"ID": e.lease}, &resp)
+		if err != nil {
+			return false, err
+		}
+		// An expired lease is reported with no TTL
+		if ttl, _ := strconv.Atoi(resp.Result.TTL); ttl <= 0 {
+			e.lease = ""
+		}
+	}
+
+	if e.lease == "" {
+		var resp struct {
+			ID string `json:"ID"`
+		}
+		err := etcdCall(ctx, "/v3/lease/grant", map[string]interface{}{"TTL": int(e.ttl.Seconds())}, &resp)
+		if err != nil {
+			return false, err
+		}
+		e.lease = resp.ID
+	}
+
+	var resp struct {
+		Succeeded bool `json:"succeeded"`
+		Responses []struct {
+			ResponseRange struct {
+				Kvs []struct {
+					Value string `json:"value"`
+					Lease string `json:"lease"`
+				} `json:"kvs"`
+			} `json:"response_range"`
+		} `json:"responses"`
+	}
+	key := etcdBytes(e.key)
+	err := etcdCall(ctx, "/v3/kv/txn", map[string]interface{}{
+		"compare": []interface{}{map[string]string{"key": key, "target": "CREATE", "create_revision": "0"}},
+		"success": []interface{}{map[string]interface{}{
+			"request_put": map[string]string{"key": key, "value": etcdBytes(e.id), "lease": e.lease},
+		}},
+		"failure": []interface{}{map[string]interface{}{
+			"request_range": map[string]string{"key": key},
+		}},
+	}, &resp)
+	if err != nil || resp.Succeeded {
+		return resp.Succeeded, err
+	}
+
+	// The key exists, held by this instance if attached to its lease
+	for _, r := range resp.Responses {
+		for _, kv := range r.ResponseRange.Kvs {
+			value, _ := base64.StdEncoding.DecodeString(kv.Value)
+			if string(value) == e.id && kv.Lease == e.lease {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (e *etcdElector) resign(ctx context.Context) error {
+	if e.lease == "" {
+		return nil
+	}
+	// Revoking the lease deletes the key
+	err := etcdCall(ctx, "/v3/lease/revoke", map[string]string{"ID": e.lease}, nil)
+	e.lease = ""
+	return err
+}
+
+// kubernetesElector holds the lock through a Lease object in the pod's
+// namespace, the way Kubernetes controllers elect their leader. Updates are
+// conditional on the resource version, so only one instance wins a race.
+type kubernetesElector struct {
+	client *kubernetesClient
+	name   string
+	id     string
+	ttl    time.Duration
+}
+
+// kubernetesMicroTime is the format of the Lease's time fields
+const kubernetesMicroTime = "2006-01-02T15:04:05.000000Z07:00"
+
+type kubernetesLease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions"`
+	} `json:"spec"`
+}
+
+func (e *kubernetesElector) path() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", url.PathEscape(e.client.namespace))
+}
+
+func (e *kubernetesElector) campaign(ctx context.Context) (bool, error) {
+	now := time.Now()
+
+	var lease kubernetesLease
+	err := e.client.do(ctx, "GET", e.path()+"/"+url.PathEscape(e.name), nil, &lease)
+	if isHTTPStatus(err, http.StatusNotFound) {
+		lease.APIVersion = "coordination.k8s.io/v1"
+		lease.Kind = "Lease"
+		lease.Metadata.Name = e.name
+		lease.Metadata.Namespace = e.client.namespace
+		lease.Spec.HolderIdentity = e.id
+		lease.Spec.LeaseDurationSeconds = int(e.ttl.Seconds())
+		lease.Spec.AcquireTime = now.UTC().Format(kubernetesMicroTime)
+		lease.Spec.RenewTime = lease.Spec.AcquireTime
+
+		err = e.client.do(ctx, "POST", e.path(), lease, nil)
+		if isHTTPStatus(err, http.StatusConflict) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if lease.Spec.HolderIdentity != e.id && lease.Spec.HolderIdentity != "" {
+		renewed, err := time.Parse(kubernetesMicroTime, lease.Spec.RenewTime)
+		expires := renewed.Add(time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second)
+		if err == nil && now.Before(expires) {
+			return false, nil
+		}
+	}
+
+	if lease.Spec.HolderIdentity != e.id {
+		lease.Spec.HolderIdentity = e.id
+		lease.Spec.AcquireTime = now.UTC().Format(kubernetesMicroTime)
+		lease.Spec.LeaseTransitions++
+	}
+	lease.Spec.LeaseDurationSeconds = int(e.ttl.Seconds())
+	lease.Spec.RenewTime = now.UTC().Format(kubernetesMicroTime)
+
+	err = e.client.do(ctx, "PUT", e.path()+"/"+url.PathEscape(e.name), lease, nil)
+	if isHTTPStatus(err, http.StatusConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (e *kubernetesElector) resign(ctx context.Context) error {
+	var lease kubernetesLease
+	err := e.client.do(ctx, "GET", e.path()+"/"+url.PathEscape(e.name), nil, &lease)
+	if err != nil || lease.Spec.HolderIdentity != e.id {
+		return err
+	}
+
+	// Like client-go, release the lease by clearing its holder
+	lease.Spec.HolderIdentity = ""
+	lease.Spec.LeaseDurationSeconds = 1
+	return e.client.do(ctx, "PUT", e.path()+"/"+url.PathEscape(e.name), lease, nil)
+}
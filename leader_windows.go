@@ -0,0 +1,9 @@
+package main
+
+import "fmt"
+
+// lockFile isn't implemented on Windows, where the file backend of leader
+// election is unavailable
+func lockFile(path string) (func(), error) {
+	return nil, fmt.Errorf("file locks aren't supported on Windows, use another leader.backend")
+}
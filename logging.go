@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// extraLogHooks are kept in addition to the configured outputs, such as the
+// event log when running as a Windows service
+var extraLogHooks []log.Hook
+
+// logClosers close the connections of the current log hooks, so they can be
+// replaced on reload
+var logClosers []func() error
+
+// configureLogging applies log.format and log.level, and sets up the outputs
+// under log
+func configureLogging() error {
+	switch format := viper.GetString("log.format"); format {
+	case "", "text":
+		log.SetFormatter(&log.TextFormatter{
+			FullTimestamp: true,
+		})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("log.format: unknown format '%s', expected text or json", format)
+	}
+
+	if viper.IsSet("log.level") {
+		level, err := log.ParseLevel(viper.GetString("log.level"))
+		if err != nil {
+			return fmt.Errorf("log.level: %v", err)
+		}
+		log.SetLevel(level)
+	}
+
+	return configureOutputs()
+}
+
+// configureOutputs sends logs to stderr, syslog and journald as configured,
+// replacing any previous outputs
+func configureOutputs() error {
+	hooks := make(log.LevelHooks)
+	var closers []func() error
+	for _, h := range extraLogHooks {
+		hooks.Add(h)
+	}
+
+	if viper.IsSet("log.syslog") {
+		network, raddr := "", ""
+		if addr := viper.GetString("log.syslog.address"); addr != "" {
+			u, err := url.Parse(addr)
+			if err != nil || u.Host == "" {
+				return fmt.Errorf("log.syslog.address: expected an address such as udp://host:514")
+			}
+			network, raddr = u.Scheme, u.Host
+		}
+
+		hook, closer, err := newSyslogHook(network, raddr, logIdentifier())
+		if err != nil {
+			return fmt.Errorf("log.syslog: %v", err)
+		}
+		hooks.Add(hook)
+		closers = append(closers, closer)
+	}
+
+	if viper.GetBool("log.journald") {
+		hook, err := newJournalHook(logIdentifier())
+		if err != nil {
+			return err
+		}
+		hooks.Add(hook)
+		closers = append(closers, hook.Close)
+	}
+
+	log.StandardLogger().ReplaceHooks(hooks)
+	for _, c := range logClosers {
+		c()
+	}
+	logClosers = closers
+
+	if viper.IsSet("log.stderr") && !viper.GetBool("log.stderr") {
+		log.SetOutput(ioutil.Discard)
+	} else {
+		log.SetOutput(os.Stderr)
+	}
+
+	return nil
+}
+
+// logIdentifier returns the program name used for syslog and journald
+func logIdentifier() string {
+	if id := viper.GetString("log.tag"); id != "" {
+		return id
+	}
+	return "dyn"
+}
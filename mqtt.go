@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// mqttState is published to the state topic after every cycle, and read by
+// the Home Assistant sensors through value templates
+type mqttState struct {
+	IPv4    string     `json:"ipv4"`
+	IPv6    string     `json:"ipv6"`
+	Changed *time.Time `json:"changed,omitempty"`
+	Status  string     `json:"status"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// mqttDiscovered is set once the discovery messages have been published.
+// They are retained by the broker, so once per run is enough.
+var mqttDiscovered struct {
+	sync.Mutex
+	done bool
+}
+
+// publishMQTT publishes the public IPs, the last change and the outcome of a
+// cycle to the broker under mqtt.broker, along with Home Assistant discovery
+// messages so they show up as sensors. Messages are retained, so subscribers
+// get the current values right away.
+func publishMQTT(cycleErr error) {
+	broker := viper.GetString("mqtt.broker")
+	if broker == "" {
+		return
+	}
+
+	topic := strings.TrimSuffix(viper.GetString("mqtt.topic"), "/")
+	if topic == "" {
+		topic = "dyn"
+	}
+
+	s := state.snapshot()
+	payload := mqttState{Status: "ok"}
+	if ip, ok := s.IPs["A"]; ok {
+		payload.IPv4 = ip.IP
+	}
+	if ip, ok := s.IPs["AAAA"]; ok {
+		payload.IPv6 = ip.IP
+	}
+	if changed := state.lastIPChange(); !changed.IsZero() {
+		payload.Changed = &changed
+	}
+	if cycleErr != nil {
+		payload.Status = "error"
+		payload.Error = cycleErr.Error()
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Errorf("mqtt: %v", err)
+		return
+	}
+	messages := []mqttMessage{{topic: topic + "/state", payload: data}}
+
+	mqttDiscovered.Lock()
+	defer mqttDiscovered.Unlock()
+
+	discovery := viper.GetBool("mqtt.discovery") || !viper.IsSet("mqtt.discovery")
+	if discovery && !mqttDiscovered.done {
+		messages = append(mqttDiscoveryMessages(topic), messages...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = mqttPublish(ctx, broker, messages)
+	if err != nil {
+		log.Errorf("mqtt: %v", err)
+		return
+	}
+	mqttDiscovered.done = discovery
+}
+
+// mqttDiscoveryMessages returns the Home Assistant discovery messages of the
+// sensors reading the state topic, grouped in a single device
+func mqttDiscoveryMessages(topic string) []mqttMessage {
+	prefix := strings.TrimSuffix(viper.GetString("mqtt.discoveryPrefix"), "/")
+	if prefix == "" {
+		prefix = "homeassistant"
+	}
+
+	node := strings.Replace(mqttClientID(), ".", "_", -1)
+	device := map[string]interface{}{
+		"identifiers": []string{node},
+		"name":        "dyn (" + mqttClientID() + ")",
+		"sw_version":  version,
+	}
+
+	sensors := []struct {
+		id, name, template, class, icon string
+	}{
+		{"ipv4", "Public IPv4", "{{ value_json.ipv4 }}", "", "mdi:ip-network"},
+		{"ipv6", "Public IPv6", "{{ value_json.ipv6 }}", "", "mdi:ip-network"},
+		{"changed", "Public IP changed", "{{ value_json.changed }}", "timestamp", ""},
+		{"status", "DNS sync status", "{{ value_json.status }}", "", "mdi:dns"},
+	}
+
+	var messages []mqttMessage
+	for _, s := range sensors {
+		config := map[string]interface{}{
+			"name":           s.name,
+			"unique_id":      node + "_" + s.id,
+			"state_topic":    topic + "/state",
+			"value_template": s.template,
+			"device":         device,
+		}
+		if s.class != "" {
+			config["device_class"] = s.class
+		}
+		if s.icon != "" {
+			config["icon"] = s.icon
+		}
+
+		data, err := json.Marshal(config)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, mqttMessage{
+			topic:   fmt.Sprintf("%s/sensor/%s/%s/config", prefix, node, s.id),
+			payload: data,
+		})
+	}
+	return messages
+}
+
+// mqttClientID returns the configured client ID, or one derived from the host
+// name
+func mqttClientID() string {
+	if id := viper.GetString("mqtt.clientId"); id != "" {
+		return id
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return "dyn-" + host
+}
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// mqttPublish connects to broker, a URL such as tcp://host:1883 or
+// ssl://host:8883, and publishes the messages retained with QoS 0 before
+// disconnecting. Only the small part of MQTT 3.1.1 needed for this is
+// implemented.
+func mqttPublish(ctx context.Context, broker string, messages []mqttMessage) error {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return fmt.Errorf("invalid mqtt.broker: %v", err)
+	}
+
+	var conn net.Conn
+	dialer := &net.Dialer{}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.DialContext(ctx, "tcp", withDefaultPort(u.Host, "1883"))
+	case "ssl", "tls", "mqtts":
+		conn, err = dialer.DialContext(ctx, "tcp", withDefaultPort(u.Host, "8883"))
+		if err == nil {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+			if deadline, ok := ctx.Deadline(); ok {
+				tlsConn.SetDeadline(deadline)
+			}
+			err = tlsConn.Handshake()
+			conn = tlsConn
+		}
+	default:
+		return fmt.Errorf("invalid mqtt.broker: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	username := viper.GetString("mqtt.username")
+	password := viper.GetString("mqtt.password")
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+
+	// CONNECT with a clean session and a 60s keep alive
+	flags := byte(0x02)
+	payload := mqttString(mqttClientID())
+	if username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(username)...)
+		if password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(password)...)
+		}
+	}
+	header := append(mqttString("MQTT"), 4, flags, 0, 60)
+	err = mqttWrite(conn, 0x10, append(header, payload...))
+	if err != nil {
+		return err
+	}
+
+	// CONNACK
+	ack := make([]byte, 4)
+	_, err = io.ReadFull(conn, ack)
+	if err != nil {
+		return fmt.Errorf("connection refused: %v", err)
+	}
+	if ack[0] != 0x20 {
+		return fmt.Errorf("unexpected packet type %#x", ack[0])
+	}
+	if ack[3] != 0 {
+		return fmt.Errorf("connection refused: %s", mqttConnectError(ack[3]))
+	}
+
+	// PUBLISH, retained with QoS 0
+	for _, m := range messages {
+		err = mqttWrite(conn, 0x31, append(mqttString(m.topic), m.payload...))
+		if err != nil {
+			return err
+		}
+	}
+
+	// DISCONNECT
+	return mqttWrite(conn, 0xe0, nil)
+}
+
+// withDefaultPort adds port to host unless it already has one
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// mqttWrite writes a packet with the given first byte and body
+func mqttWrite(w io.Writer, packet byte, body []byte) error {
+	// The remaining length is encoded with 7 bits per byte
+	buf := []byte{packet}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(buf, body...))
+	return err
+}
+
+// mqttString encodes a length prefixed UTF-8 string
+func mqttString(s string) []byte {
+	buf := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+// mqttConnectError describes a CONNACK return code
+func mqttConnectError(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("return code %d", code)
+}
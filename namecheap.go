@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+func newNamecheap(key string) (Provider, error) {
+	password := viper.GetString(key + ".password")
+	if password == "" {
+		return nil, fmt.Errorf("namecheap: missing %s.password", key)
+	}
+
+	return provider.NewNamecheap(password), nil
+}
+
+func init() {
+	registerProvider("namecheap", newNamecheap)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// networkSettle is how long to wait for a burst of network changes, such as a
+// reconnect replacing addresses and routes, to settle before syncing
+const networkSettle = 3 * time.Second
+
+// watchNetwork requests a sync whenever the operating system reports that the
+// network configuration changed, limited to network.interface when set, or
+// NetworkManager regains connectivity, so a new address is published without
+// waiting for the next check
+func watchNetwork(ctx context.Context, ctl *control) error {
+	var sources []<-chan struct{}
+	if viper.GetBool("network.watch") {
+		c, err := networkChanges(ctx, viper.GetString("network.interface"))
+		if err != nil {
+			return err
+		}
+		sources = append(sources, c)
+	}
+	if viper.GetBool("network.networkManager") {
+		c, err := networkManagerChanges(ctx)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, c)
+	}
+
+	changes := make(chan struct{}, 1)
+	for _, c := range sources {
+		go func(c <-chan struct{}) {
+			for range c {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}(c)
+	}
+
+	go func() {
+		var settle <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changes:
+				settle = time.After(networkSettle)
+			case <-settle:
+				settle = nil
+				log.Info("network: configuration changed, syncing")
+				ctl.requestSync()
+			}
+		}
+	}()
+
+	return nil
+}
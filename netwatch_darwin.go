@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// networkChanges listens on a routing socket for address changes on the
+// given interface (any when empty) and default route changes, which is where
+// SystemConfiguration learns about them too, without requiring cgo
+func networkChanges(ctx context.Context, iface string) (<-chan struct{}, error) {
+	index := 0
+	if iface != "" {
+		i, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, fmt.Errorf("network: %v", err)
+		}
+		index = i.Index
+	}
+
+	fd, err := syscall.Socket(syscall.AF_ROUTE, syscall.SOCK_RAW, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("network: routing socket: %v", err)
+	}
+
+	// Wake up regularly to notice cancellation, as closing the socket doesn't
+	// interrupt a blocked read
+	err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &syscall.Timeval{Sec: 1})
+	if err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("network: routing socket: %v", err)
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer syscall.Close(fd)
+		defer close(changes)
+
+		buf := make([]byte, syscall.Getpagesize())
+		for ctx.Err() == nil {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				log.Errorf("network: routing socket: %v", err)
+				return
+			}
+
+			msgs, err := syscall.ParseRoutingMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				if relevantRoutingMessage(m, index) {
+					select {
+					case changes <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// relevantRoutingMessage reports whether m is an address change on the
+// interface with the given index (any when 0), or a default route change
+func relevantRoutingMessage(m syscall.RoutingMessage, index int) bool {
+	switch m := m.(type) {
+	case *syscall.InterfaceAddrMessage:
+		return index == 0 || int(m.Header.Index) == index
+
+	case *syscall.RouteMessage:
+		if m.Header.Type != syscall.RTM_ADD && m.Header.Type != syscall.RTM_DELETE && m.Header.Type != syscall.RTM_CHANGE {
+			return false
+		}
+		sas, err := syscall.ParseRoutingSockaddr(m)
+		if err != nil || len(sas) <= syscall.RTAX_DST {
+			return false
+		}
+		switch dst := sas[syscall.RTAX_DST].(type) {
+		case nil:
+			return true
+		case *syscall.SockaddrInet4:
+			return net.IP(dst.Addr[:]).IsUnspecified()
+		case *syscall.SockaddrInet6:
+			return net.IP(dst.Addr[:]).IsUnspecified()
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rtnetlink multicast groups, missing from package syscall
+const (
+	rtmgrpIPv4Ifaddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6Ifaddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// networkChanges subscribes to rtnetlink address and route notifications,
+// reporting address changes on the given interface (any when empty) and
+// default route changes
+func networkChanges(ctx context.Context, iface string) (<-chan struct{}, error) {
+	index := 0
+	if iface != "" {
+		i, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, fmt.Errorf("network: %v", err)
+		}
+		index = i.Index
+	}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("network: netlink: %v", err)
+	}
+
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpIPv4Ifaddr | rtmgrpIPv6Ifaddr | rtmgrpIPv4Route | rtmgrpIPv6Route,
+	})
+	if err == nil {
+		// Wake up regularly to notice cancellation, as closing the socket
+		// doesn't interrupt a blocked receive
+		err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &syscall.Timeval{Sec: 1})
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("network: netlink: %v", err)
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer syscall.Close(fd)
+		defer close(changes)
+
+		buf := make([]byte, syscall.Getpagesize())
+		for ctx.Err() == nil {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				log.Errorf("network: netlink: %v", err)
+				return
+			}
+
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				if relevantNetlinkMessage(m, index) {
+					select {
+					case changes <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// relevantNetlinkMessage reports whether m is an address change on the
+// interface with the given index (any when 0), or a default route change
+func relevantNetlinkMessage(m syscall.NetlinkMessage, index int) bool {
+	switch m.Header.Type {
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(m.Data) < syscall.SizeofIfAddrmsg {
+			return false
+		}
+		ifa := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		return index == 0 || int(ifa.Index) == index
+
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		if len(m.Data) < syscall.SizeofRtMsg {
+			return false
+		}
+		rt := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		return rt.Dst_len == 0 && rt.Table == syscall.RT_TABLE_MAIN
+	}
+
+	return false
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+func networkChanges(ctx context.Context, iface string) (<-chan struct{}, error) {
+	return nil, fmt.Errorf("network: watching for changes is not supported on this platform")
+}
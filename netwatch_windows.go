@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	iphlpapi              = syscall.NewLazyDLL("iphlpapi.dll")
+	procNotifyAddrChange  = iphlpapi.NewProc("NotifyAddrChange")
+	procNotifyRouteChange = iphlpapi.NewProc("NotifyRouteChange")
+)
+
+// networkChanges waits for IP address and routing table changes with the IP
+// Helper API. Windows doesn't report which interface changed, so iface is
+// ignored.
+func networkChanges(ctx context.Context, iface string) (<-chan struct{}, error) {
+	err := procNotifyAddrChange.Find()
+	if err == nil {
+		err = procNotifyRouteChange.Find()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	wait := func(proc *syscall.LazyProc) {
+		for ctx.Err() == nil {
+			// Without a handle and overlapped structure, the call blocks
+			// until the next change. It can't be cancelled, which only
+			// matters when exiting.
+			ret, _, _ := proc.Call(0, 0)
+			if ret != 0 {
+				log.Errorf("network: %s failed: %v", proc.Name, syscall.Errno(ret))
+				return
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+	go wait(procNotifyAddrChange)
+	go wait(procNotifyRouteChange)
+
+	return changes, nil
+}
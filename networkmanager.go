@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	dbusSystemSocket = "/var/run/dbus/system_bus_socket"
+
+	// nmStateConnectedGlobal is NetworkManager's state with full Internet
+	// connectivity
+	nmStateConnectedGlobal = 70
+)
+
+// networkManagerChanges reports whenever NetworkManager regains full
+// connectivity, listening to its StateChanged signal on the system D-Bus.
+// Lost connections to the bus are retried.
+func networkManagerChanges(ctx context.Context) (<-chan struct{}, error) {
+	conn, err := dialNetworkManager()
+	if err != nil {
+		return nil, fmt.Errorf("networkmanager: %v", err)
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		state := uint32(0)
+		for {
+			err := readNetworkManagerStates(ctx, conn, func(s uint32) {
+				log.Debugf("networkmanager: state changed to %d", s)
+				if s == nmStateConnectedGlobal && state != nmStateConnectedGlobal {
+					select {
+					case changes <- struct{}{}:
+					default:
+					}
+				}
+				state = s
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			log.Errorf("networkmanager: %v", err)
+
+			for conn = nil; conn == nil; {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(30 * time.Second):
+				}
+				conn, err = dialNetworkManager()
+				if err != nil {
+					log.Errorf("networkmanager: %v", err)
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// dialNetworkManager connects to the system bus and subscribes to
+// NetworkManager's StateChanged signal
+func dialNetworkManager() (net.Conn, error) {
+	path := dbusSystemSocket
+	if addr := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"); strings.HasPrefix(addr, "unix:path=") {
+		path = strings.SplitN(strings.TrimPrefix(addr, "unix:path="), ",", 2)[0]
+	}
+
+	conn, err := net.DialTimeout("unix", path, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// Authenticate as the process' user, then register and subscribe without
+	// waiting for the replies
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	r := bufio.NewReader(conn)
+	_, err = fmt.Fprintf(conn, "\x00AUTH EXTERNAL %s\r\n", uid)
+	if err == nil {
+		var line string
+		line, err = r.ReadString('\n')
+		if err == nil && !strings.HasPrefix(line, "OK ") {
+			err = fmt.Errorf("authentication rejected: %s", strings.TrimSpace(line))
+		}
+	}
+	if err == nil {
+		_, err = io.WriteString(conn, "BEGIN\r\n")
+	}
+	if err == nil {
+		_, err = conn.Write(dbusMethodCall(1, "Hello"))
+	}
+	if err == nil {
+		_, err = conn.Write(dbusMethodCall(2, "AddMatch", "type='signal',interface='org.freedesktop.NetworkManager',member='StateChanged'"))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// readNetworkManagerStates calls fn with the state of every StateChanged
+// signal, until the connection fails or ctx is done
+func readNetworkManagerStates(ctx context.Context, conn net.Conn, fn func(uint32)) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		m, err := readDBusMessage(r)
+		if err != nil {
+			return err
+		}
+
+		if m.typ == dbusSignal && m.iface == "org.freedesktop.NetworkManager" && m.member == "StateChanged" && m.signature == "u" && len(m.body) >= 4 {
+			fn(m.order.Uint32(m.body))
+		}
+	}
+}
+
+// D-Bus message types
+const (
+	dbusMethodCallType = 1
+	dbusSignal         = 4
+)
+
+// dbusWriter marshals values in the D-Bus wire format, little endian,
+// aligning them relative to the start of the buffer
+type dbusWriter struct {
+	bytes.Buffer
+}
+
+func (w *dbusWriter) align(n int) {
+	for w.Len()%n != 0 {
+		w.WriteByte(0)
+	}
+}
+
+func (w *dbusWriter) uint32(v uint32) {
+	w.align(4)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func (w *dbusWriter) string(s string) {
+	w.uint32(uint32(len(s)))
+	w.WriteString(s)
+	w.WriteByte(0)
+}
+
+func (w *dbusWriter) signature(s string) {
+	w.WriteByte(byte(len(s)))
+	w.WriteString(s)
+	w.WriteByte(0)
+}
+
+// dbusMethodCall builds a call of a method of the bus itself, with string
+// arguments
+func dbusMethodCall(serial uint32, member string, args ...string) []byte {
+	var body dbusWriter
+	for _, a := range args {
+		body.string(a)
+	}
+
+	// The header fields start at offset 16, so aligning them within their
+	// own buffer aligns them within the message
+	var fields dbusWriter
+	field := func(code byte, sig, value string) {
+		fields.align(8)
+		fields.WriteByte(code)
+		fields.signature(sig)
+		if sig == "g" {
+			fields.signature(value)
+		} else {
+			fields.string(value)
+		}
+	}
+	field(1, "o", "/org/freedesktop/DBus")
+	field(2, "s", "org.freedesktop.DBus")
+	field(3, "s", member)
+	field(6, "s", "org.freedesktop.DBus")
+	if len(args) > 0 {
+		field(8, "g", strings.Repeat("s", len(args)))
+	}
+
+	var m dbusWriter
+	m.Write([]byte{'l', dbusMethodCallType, 0, 1})
+	m.uint32(uint32(body.Len()))
+	m.uint32(serial)
+	m.uint32(uint32(fields.Len()))
+	m.Write(fields.Bytes())
+	m.align(8)
+	m.Write(body.Bytes())
+	return m.Bytes()
+}
+
+// dbusMessage is a received message, with the header fields dyn cares about
+type dbusMessage struct {
+	order     binary.ByteOrder
+	typ       byte
+	iface     string
+	member    string
+	signature string
+	body      []byte
+}
+
+// dbusReader unmarshals values, recording the first out of bounds read
+type dbusReader struct {
+	b     []byte
+	pos   int
+	order binary.ByteOrder
+	err   error
+}
+
+func (r *dbusReader) next(n int) []byte {
+	if r.err != nil || r.pos+n > len(r.b) {
+		r.err = fmt.Errorf("malformed message")
+		return make([]byte, n)
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *dbusReader) align(n int) {
+	if pad := (n - r.pos%n) % n; pad > 0 {
+		r.next(pad)
+	}
+}
+
+func (r *dbusReader) signature() string {
+	n := int(r.next(1)[0])
+	s := string(r.next(n))
+	r.next(1)
+	return s
+}
+
+func (r *dbusReader) string() string {
+	r.align(4)
+	n := int(r.order.Uint32(r.next(4)))
+	if n > len(r.b) {
+		r.err = fmt.Errorf("malformed message")
+		return ""
+	}
+	s := string(r.next(n))
+	r.next(1)
+	return s
+}
+
+func readDBusMessage(r io.Reader) (dbusMessage, error) {
+	var m dbusMessage
+	hdr := make([]byte, 16)
+	_, err := io.ReadFull(r, hdr)
+	if err != nil {
+		return m, err
+	}
+
+	switch hdr[0] {
+	case 'l':
+		m.order = binary.LittleEndian
+	case 'B':
+		m.order = binary.BigEndian
+	default:
+		return m, fmt.Errorf("invalid byte order '%c'", hdr[0])
+	}
+	m.typ = hdr[1]
+
+	bodyLen := int(m.order.Uint32(hdr[4:]))
+	fieldsLen := int(m.order.Uint32(hdr[12:]))
+	if bodyLen > 1<<20 || fieldsLen > 1<<20 {
+		return m, fmt.Errorf("message too large")
+	}
+
+	padded := (fieldsLen + 7) &^ 7
+	rest := make([]byte, padded+bodyLen)
+	_, err = io.ReadFull(r, rest)
+	if err != nil {
+		return m, err
+	}
+	m.body = rest[padded:]
+
+	// Header fields are (code, variant) structs, aligned to 8 bytes
+	f := &dbusReader{b: rest[:fieldsLen], order: m.order}
+	for f.err == nil && f.pos < len(f.b) {
+		f.align(8)
+		code := f.next(1)[0]
+		switch sig := f.signature(); sig {
+		case "s", "o":
+			v := f.string()
+			if code == 2 {
+				m.iface = v
+			} else if code == 3 {
+				m.member = v
+			}
+		case "g":
+			v := f.signature()
+			if code == 8 {
+				m.signature = v
+			}
+		case "u":
+			f.align(4)
+			f.next(4)
+		default:
+			return m, fmt.Errorf("unexpected header field type '%s'", sig)
+		}
+	}
+
+	return m, f.err
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Event types sent to notifiers
+const (
+	eventIPChanged  = "ip_changed"
+	eventSyncFailed = "sync_failed"
+	eventFlapping   = "ip_flapping"
+	// eventNotPropagated reports an updated record missing on name servers
+	eventNotPropagated = "propagation_failed"
+	// eventDrift reports a record changed by someone else
+	eventDrift = "record_drift"
+)
+
+// event describes something worth notifying about
+type event struct {
+	Type     string     `json:"type"`
+	OldIP    string     `json:"oldIp,omitempty"`
+	NewIP    string     `json:"newIp,omitempty"`
+	Records  []string   `json:"records,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Changes  int        `json:"changes,omitempty"`  // IP changes within the flapping window
+	Restored bool       `json:"restored,omitempty"` // drifted records put back
+	Since    *time.Time `json:"since,omitempty"`    // first failure of a failing sync
+	Time     time.Time  `json:"time"`
+}
+
+// title returns a short summary of the event
+func (e event) title() string {
+	switch e.Type {
+	case eventIPChanged:
+		return "dyn: public IP changed"
+	case eventSyncFailed:
+		return "dyn: sync failing"
+	case eventFlapping:
+		return "dyn: public IP flapping"
+	case eventNotPropagated:
+		return "dyn: record not propagated"
+	case eventDrift:
+		return "dyn: record changed outside of dyn"
+	}
+	return "dyn: " + e.Type
+}
+
+// message returns a human readable description of the event
+func (e event) message() string {
+	switch e.Type {
+	case eventIPChanged:
+		old := e.OldIP
+		if old == "" {
+			old = "none"
+		}
+		return fmt.Sprintf("Public IP changed from %s to %s at %s, updated %s",
+			old, e.NewIP, e.Time.Format(time.RFC3339), strings.Join(e.Records, ", "))
+	case eventSyncFailed:
+		since := e.Time
+		if e.Since != nil {
+			since = *e.Since
+		}
+		return fmt.Sprintf("Sync has been failing since %s: %s", since.Format(time.RFC3339), e.Error)
+	case eventFlapping:
+		return fmt.Sprintf("Public IP changed %d times in the last %s, now %s. This usually points at an ISP or detector problem.",
+			e.Changes, flappingWindow(), e.NewIP)
+	case eventNotPropagated:
+		return fmt.Sprintf("%s was updated to %s, but is %s", strings.Join(e.Records, ", "), e.NewIP, e.Error)
+	case eventDrift:
+		action := "It was left alone."
+		if e.Restored {
+			action = "It was restored."
+		}
+		return fmt.Sprintf("%s was changed from %s to %s outside of dyn. %s", strings.Join(e.Records, ", "), e.OldIP, e.NewIP, action)
+	}
+	return e.Type
+}
+
+// Notifier is implemented by services receiving event notifications
+type Notifier interface {
+	Notify(ctx context.Context, e event) error
+}
+
+// notifiers holds the constructors of every available notifier by name. Each
+// constructor reads its settings from the configuration section under key.
+var notifiers = map[string]func(key string) (Notifier, error){}
+
+func registerNotifier(name string, fn func(key string) (Notifier, error)) {
+	notifiers[name] = fn
+}
+
+// newNotifiers constructs every notifier with a section under notify
+func newNotifiers() ([]Notifier, error) {
+	var names []string
+	for n := range notifiers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var ns []Notifier
+	for _, name := range names {
+		key := "notify." + name
+		if !viper.IsSet(key) {
+			continue
+		}
+
+		n, err := notifiers[name](key)
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+
+	return ns, nil
+}
+
+// notify sends e to every notifier in the background, so slow services don't
+// hold up the cycle
+func notify(ns []Notifier, e event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	for _, n := range ns {
+		go func(n Notifier) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			err := n.Notify(ctx, e)
+			if err != nil {
+				log.Errorf("notify: %v", err)
+			}
+		}(n)
+	}
+}
+
+// postJSON posts body as JSON to endpoint, discarding the response. Errors
+// leave out the URL, as webhook URLs embed their secret.
+func postJSON(ctx context.Context, endpoint string, body interface{}) error {
+	req, err := newJSONRequest("POST", endpoint, body)
+	if err != nil {
+		return err
+	}
+
+	_, err = httpDo(ctx, req)
+	if ue, ok := err.(*url.Error); ok {
+		return ue.Err
+	}
+	return err
+}
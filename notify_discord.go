@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// discord posts notifications to a Discord channel webhook
+type discord struct {
+	webhook string
+}
+
+func newDiscord(key string) (Notifier, error) {
+	webhook := viper.GetString(key + ".webhook")
+	if webhook == "" {
+		return nil, fmt.Errorf("discord: missing %s.webhook", key)
+	}
+	return &discord{webhook: webhook}, nil
+}
+
+func (d *discord) Notify(ctx context.Context, e event) error {
+	err := postJSON(ctx, d.webhook, map[string]string{
+		"content": "**" + e.title() + "**\n" + e.message(),
+	})
+	if err != nil {
+		return fmt.Errorf("discord: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	registerNotifier("discord", newDiscord)
+}
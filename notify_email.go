@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// email sends notifications through an SMTP server. The connection is
+// secured with STARTTLS by default, or implicit TLS with tls: true as usual
+// on port 465.
+type email struct {
+	host     string
+	port     int
+	tls      bool
+	insecure bool // plain text, for local relays only
+	username string
+	password string
+	from     string
+	sender   string // envelope address of from
+	to       []string
+}
+
+func newEmail(key string) (Notifier, error) {
+	e := &email{
+		host:     viper.GetString(key + ".host"),
+		port:     viper.GetInt(key + ".port"),
+		tls:      viper.GetBool(key + ".tls"),
+		insecure: viper.GetBool(key + ".insecure"),
+		username: viper.GetString(key + ".username"),
+		password: viper.GetString(key + ".password"),
+		from:     viper.GetString(key + ".from"),
+		to:       viper.GetStringSlice(key + ".to"),
+	}
+
+	if e.host == "" || e.from == "" || len(e.to) == 0 {
+		return nil, fmt.Errorf("email: %s.host, %s.from and %s.to are required", key, key, key)
+	}
+	from, err := mail.ParseAddress(e.from)
+	if err != nil {
+		return nil, fmt.Errorf("email: invalid %s.from: %v", key, err)
+	}
+	e.sender = from.Address
+
+	if e.port == 0 {
+		e.port = 587
+		if e.tls {
+			e.port = 465
+		}
+	}
+
+	return e, nil
+}
+
+func (e *email) Notify(ctx context.Context, ev event) error {
+	err := e.send(ctx, ev)
+	if err != nil {
+		return fmt.Errorf("email: %v", err)
+	}
+	return nil
+}
+
+func (e *email) send(ctx context.Context, ev event) error {
+	addr := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+	tlsConfig := &tls.Config{ServerName: e.host}
+
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	if e.tls {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if !e.tls {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			err = c.StartTLS(tlsConfig)
+			if err != nil {
+				return err
+			}
+		} else if !e.insecure {
+			return fmt.Errorf("%s does not support STARTTLS, set insecure to send in plain text", addr)
+		}
+	}
+
+	if e.username != "" {
+		err = c.Auth(smtp.PlainAuth("", e.username, e.password, e.host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(e.sender)
+	if err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		err = c.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	msg := "From: " + e.from + "\r\n" +
+		"To: " + strings.Join(e.to, ", ") + "\r\n" +
+		"Subject: " + ev.title() + "\r\n" +
+		"Date: " + ev.Time.Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		ev.message() + "\r\n"
+
+	_, err = w.Write([]byte(msg))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func init() {
+	registerNotifier("email", newEmail)
+}
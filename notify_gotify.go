@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// gotify sends notifications to a self-hosted Gotify server using an
+// application token
+type gotify struct {
+	url   string
+	token string
+}
+
+func newGotify(key string) (Notifier, error) {
+	g := &gotify{
+		url:   strings.TrimSuffix(viper.GetString(key+".url"), "/"),
+		token: viper.GetString(key + ".token"),
+	}
+	if g.url == "" || g.token == "" {
+		return nil, fmt.Errorf("gotify: %s.url and %s.token are required", key, key)
+	}
+	return g, nil
+}
+
+func (g *gotify) Notify(ctx context.Context, e event) error {
+	priority := 5
+	if e.Type == eventSyncFailed {
+		priority = 8
+	}
+
+	req, err := newJSONRequest("POST", g.url+"/message", map[string]interface{}{
+		"title":    e.title(),
+		"message":  e.message(),
+		"priority": priority,
+	})
+	if err != nil {
+		return fmt.Errorf("gotify: %v", err)
+	}
+	req.Header.Set("X-Gotify-Key", g.token)
+
+	_, err = httpDo(ctx, req)
+	if err != nil {
+		return fmt.Errorf("gotify: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	registerNotifier("gotify", newGotify)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ntfy publishes notifications to a topic on ntfy.sh or a self-hosted ntfy
+// server
+type ntfy struct {
+	server   string
+	topic    string
+	token    string
+	username string
+	password string
+}
+
+func newNtfy(key string) (Notifier, error) {
+	n := &ntfy{
+		server:   strings.TrimSuffix(viper.GetString(key+".server"), "/"),
+		topic:    viper.GetString(key + ".topic"),
+		token:    viper.GetString(key + ".token"),
+		username: viper.GetString(key + ".username"),
+		password: viper.GetString(key + ".password"),
+	}
+	if n.topic == "" {
+		return nil, fmt.Errorf("ntfy: missing %s.topic", key)
+	}
+	if n.server == "" {
+		n.server = "https://ntfy.sh"
+	}
+	return n, nil
+}
+
+func (n *ntfy) Notify(ctx context.Context, e event) error {
+	req, err := http.NewRequest("POST", n.server+"/"+n.topic, strings.NewReader(e.message()))
+	if err != nil {
+		return fmt.Errorf("ntfy: %v", err)
+	}
+	req.Header.Set("Title", e.title())
+	if e.Type == eventSyncFailed {
+		req.Header.Set("Priority", "high")
+		req.Header.Set("Tags", "warning")
+	}
+
+	switch {
+	case n.token != "":
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	case n.username != "":
+		req.SetBasicAuth(n.username, n.password)
+	}
+
+	_, err = httpDo(ctx, req)
+	if err != nil {
+		return fmt.Errorf("ntfy: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	registerNotifier("ntfy", newNtfy)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// pushover sends notifications through the Pushover API
+type pushover struct {
+	token string
+	user  string
+}
+
+func newPushover(key string) (Notifier, error) {
+	p := &pushover{
+		token: viper.GetString(key + ".token"),
+		user:  viper.GetString(key + ".user"),
+	}
+	if p.token == "" || p.user == "" {
+		return nil, fmt.Errorf("pushover: %s.token and %s.user are required", key, key)
+	}
+	return p, nil
+}
+
+func (p *pushover) Notify(ctx context.Context, e event) error {
+	form := url.Values{}
+	form.Set("token", p.token)
+	form.Set("user", p.user)
+	form.Set("title", e.title())
+	form.Set("message", e.message())
+	form.Set("timestamp", fmt.Sprint(e.Time.Unix()))
+	if e.Type == eventSyncFailed {
+		form.Set("priority", "1")
+	}
+
+	req, err := http.NewRequest("POST", "https://api.pushover.net/1/messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("pushover: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	_, err = httpDo(ctx, req)
+	if err != nil {
+		return fmt.Errorf("pushover: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	registerNotifier("pushover", newPushover)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// slack posts notifications to a Slack incoming webhook
+type slack struct {
+	webhook string
+}
+
+func newSlack(key string) (Notifier, error) {
+	webhook := viper.GetString(key + ".webhook")
+	if webhook == "" {
+		return nil, fmt.Errorf("slack: missing %s.webhook", key)
+	}
+	return &slack{webhook: webhook}, nil
+}
+
+func (s *slack) Notify(ctx context.Context, e event) error {
+	err := postJSON(ctx, s.webhook, map[string]string{
+		"text": "*" + e.title() + "*\n" + e.message(),
+	})
+	if err != nil {
+		return fmt.Errorf("slack: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	registerNotifier("slack", newSlack)
+}
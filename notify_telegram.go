@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/viper"
+)
+
+// telegram sends notifications as messages from a Telegram bot
+type telegram struct {
+	token  string
+	chatID string
+}
+
+func newTelegram(key string) (Notifier, error) {
+	t := &telegram{
+		token:  viper.GetString(key + ".token"),
+		chatID: viper.GetString(key + ".chatId"),
+	}
+	if t.token == "" || t.chatID == "" {
+		return nil, fmt.Errorf("telegram: %s.token and %s.chatId are required", key, key)
+	}
+	return t, nil
+}
+
+func (t *telegram) Notify(ctx context.Context, e event) error {
+	err := postJSON(ctx, "https://api.telegram.org/bot"+url.PathEscape(t.token)+"/sendMessage", map[string]string{
+		"chat_id": t.chatID,
+		"text":    e.title() + "\n" + e.message(),
+	})
+	if err != nil {
+		return fmt.Errorf("telegram: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	registerNotifier("telegram", newTelegram)
+}
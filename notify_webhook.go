@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+// webhook posts events as JSON to an arbitrary URL. With a secret, requests
+// carry an X-Dyn-Signature header of "sha256=<hex HMAC-SHA256>" computed over
+// the X-Dyn-Timestamp header, a dot and the body, so receivers can verify
+// where they came from.
+type webhook struct {
+	url     string
+	secret  string
+	headers map[string]string
+}
+
+func newWebhook(key string) (Notifier, error) {
+	w := &webhook{
+		url:     viper.GetString(key + ".url"),
+		secret:  viper.GetString(key + ".secret"),
+		headers: viper.GetStringMapString(key + ".headers"),
+	}
+	if w.url == "" {
+		return nil, fmt.Errorf("webhook: missing %s.url", key)
+	}
+	return w, nil
+}
+
+func (w *webhook) Notify(ctx context.Context, e event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dyn")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+
+	if w.secret != "" {
+		// Sign the timestamp along with the body to prevent replays
+		ts := strconv.FormatInt(e.Time.Unix(), 10)
+		req.Header.Set("X-Dyn-Timestamp", ts)
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write([]byte(ts + "." + string(body)))
+		req.Header.Set("X-Dyn-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	_, err = httpDo(ctx, req)
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	if err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+	return nil
+}
+
+func init() {
+	registerNotifier("webhook", newWebhook)
+}
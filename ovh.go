@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+func newOVH(key string) (Provider, error) {
+	opts := provider.OVHOptions{
+		Endpoint:          viper.GetString(key + ".endpoint"),
+		ApplicationKey:    viper.GetString(key + ".applicationKey"),
+		ApplicationSecret: viper.GetString(key + ".applicationSecret"),
+		ConsumerKey:       viper.GetString(key + ".consumerKey"),
+	}
+	if opts.ApplicationKey == "" || opts.ApplicationSecret == "" || opts.ConsumerKey == "" {
+		return nil, fmt.Errorf("ovh: %s.applicationKey, %s.applicationSecret and %s.consumerKey are required", key, key, key)
+	}
+
+	return provider.NewOVH(opts), nil
+}
+
+func init() {
+	registerProvider("ovh", newOVH)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ping reports the outcome of a cycle to a dead man's switch, such as
+// Healthchecks.io or an Uptime Kuma push monitor, which alerts when pings stop
+// arriving. Healthchecks.io style URLs get /fail appended on errors, while
+// Uptime Kuma push URLs get a status=down parameter.
+func ping(cycleErr error) {
+	endpoint := viper.GetString("ping.url")
+	if endpoint == "" {
+		return
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		log.Errorf("ping: invalid ping.url: %v", err)
+		return
+	}
+
+	kind := viper.GetString("ping.kind")
+	if kind == "" {
+		kind = "healthchecks"
+		if strings.Contains(u.Path, "/api/push/") {
+			kind = "uptimekuma"
+		}
+	}
+
+	switch kind {
+	case "uptimekuma":
+		q := u.Query()
+		q.Set("status", "up")
+		q.Set("msg", "OK")
+		if cycleErr != nil {
+			q.Set("status", "down")
+			q.Set("msg", cycleErr.Error())
+		}
+		u.RawQuery = q.Encode()
+	default:
+		if cycleErr != nil {
+			u.Path = strings.TrimSuffix(u.Path, "/") + "/fail"
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		log.Errorf("ping: %v", err)
+		return
+	}
+	_, err = httpDo(ctx, req)
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	if err != nil {
+		log.Errorf("ping: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package detect
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Binding makes the connections of a detector leave through an interface or
+// from a source address, so the public IP of each uplink of a multi-WAN host
+// can be detected
+type Binding struct {
+	Interface string
+	Source    net.IP
+}
+
+// Dialer returns a dialer for proto, such as tcp4 or udp6, bound to the
+// interface or source address, or an unbound one for a nil binding
+func (b *Binding) Dialer(proto string) (*net.Dialer, error) {
+	d := &net.Dialer{}
+	if b == nil {
+		return d, nil
+	}
+
+	ip4 := strings.HasSuffix(proto, "4")
+	source := b.Source
+	if b.Interface != "" {
+		// Without SO_BINDTODEVICE, the route is chosen by the address of the
+		// interface
+		d.Control = bindToDevice(b.Interface)
+		if d.Control == nil && source == nil {
+			var err error
+			source, err = interfaceAddr(b.Interface, ip4)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if source != nil {
+		if (source.To4() != nil) != ip4 {
+			return nil, fmt.Errorf("source address %s can't be used over %s", source, proto)
+		}
+		if strings.HasPrefix(proto, "udp") {
+			d.LocalAddr = &net.UDPAddr{IP: source}
+		} else {
+			d.LocalAddr = &net.TCPAddr{IP: source}
+		}
+	}
+
+	return d, nil
+}
+
+// interfaceAddr returns the first global address of the interface in the
+// given family
+func interfaceAddr(name string, ip4 bool) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %v", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %v", name, err)
+	}
+
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if ok && ipnet.IP.IsGlobalUnicast() && (ipnet.IP.To4() != nil) == ip4 {
+			return ipnet.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no address to bind to", name)
+}
@@ -0,0 +1,92 @@
+package detect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Source is a detector combined with others, named in logs and errors
+type Source struct {
+	Name string
+	Detector
+}
+
+// consensusDetector queries several detectors concurrently and only accepts
+// an address reported by at least quorum of them, so a single broken or
+// compromised source cannot decide the public IP on its own
+type consensusDetector struct {
+	sources []Source
+	quorum  int
+}
+
+// NewConsensus returns a detector accepting the address reported by at least
+// quorum of sources, or a majority of them when quorum is 0
+func NewConsensus(sources []Source, quorum int) (Detector, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("no sources")
+	}
+	if quorum == 0 {
+		quorum = len(sources)/2 + 1
+	}
+	if quorum < 1 || quorum > len(sources) {
+		return nil, errors.New("the quorum must be between 1 and the number of sources")
+	}
+
+	return &consensusDetector{sources: sources, quorum: quorum}, nil
+}
+
+func (d *consensusDetector) Detect(ctx context.Context, network string) (net.IP, error) {
+	type result struct {
+		name string
+		ip   net.IP
+		err  error
+	}
+
+	results := make(chan result, len(d.sources))
+	for _, source := range d.sources {
+		go func(source Source) {
+			ip, err := source.Detect(ctx, network)
+			results <- result{source.Name, ip, err}
+		}(source)
+	}
+
+	// Tally the votes for each address
+	votes := map[string]int{}
+	var failures []string
+	for range d.sources {
+		r := <-results
+		if r.err != nil {
+			log.Warnf("detector: %s: %v", r.name, r.err)
+			failures = append(failures, r.name)
+			continue
+		}
+
+		log.Debugf("detector: %s reported %s", r.name, r.ip)
+		votes[r.ip.String()]++
+	}
+
+	// Prefer the address with the most votes should several reach quorum
+	best, most := "", 0
+	for ip, n := range votes {
+		if n > most {
+			best, most = ip, n
+		}
+	}
+	if most >= d.quorum {
+		return net.ParseIP(best), nil
+	}
+
+	var tally []string
+	for ip, n := range votes {
+		tally = append(tally, fmt.Sprintf("%s (%d)", ip, n))
+	}
+	if len(failures) > 0 {
+		tally = append(tally, fmt.Sprintf("%d failed", len(failures)))
+	}
+	return nil, fmt.Errorf("no quorum of %d detectors agree on the public IP: %s", d.quorum, strings.Join(tally, ", "))
+}
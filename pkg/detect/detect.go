@@ -0,0 +1,74 @@
+// Package detect defines the interface implemented by methods of learning the
+// public IP address, along with helpers to validate detected addresses
+package detect
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Detector is implemented by methods of learning the public IP address
+type Detector interface {
+	// Detect returns the public IP address on the given network, either
+	// "ip4" or "ip6"
+	Detect(ctx context.Context, network string) (net.IP, error)
+}
+
+// ParseIP parses an address returned by a detector, checking that it belongs
+// to the requested network
+func ParseIP(s, network string) (net.IP, error) {
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address '%s'", strings.TrimSpace(s))
+	}
+
+	if (ip.To4() != nil) != (network == "ip4") {
+		return nil, fmt.Errorf("IP address %s does not belong to network %s", ip, network)
+	}
+
+	return ip, nil
+}
+
+// nonPublicNets are ranges which are never reachable from the Internet, in
+// addition to private, loopback, link-local and multicast addresses
+var nonPublicNets = mustParseCIDRs(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT (RFC 6598)
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved
+	"2001:db8::/32",   // documentation
+)
+
+// CheckPublicIP returns an error unless ip is a global unicast address
+// reachable from the Internet
+func CheckPublicIP(ip net.IP) error {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return fmt.Errorf("%s is not a public address", ip)
+	}
+
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return fmt.Errorf("%s is not a public address (%s)", ip, n)
+		}
+	}
+
+	return nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
@@ -0,0 +1,216 @@
+package detect
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type resolver struct {
+	addr     string
+	resolver string
+	network  string // "ip4" or "ip6"
+	txt      bool   // the address is published as a TXT record
+	doh      string // DNS-over-HTTPS endpoint used instead of the resolver
+	dohHTTP  *http.Client
+	tls      bool   // query the resolver over DNS-over-TLS
+	tlsName  string // server name to verify, defaults to the resolver
+	bind     *Binding
+	ip       []net.IP
+}
+
+func (dns *resolver) lookup(ctx context.Context) error {
+	// Query the resolver over the same address family being looked up, since
+	// echo services such as OpenDNS answer with the address of the querier
+	proto, stream := "udp4", "tcp4"
+	if dns.network == "ip6" {
+		proto, stream = "udp6", "tcp6"
+	}
+
+	dialer, err := dns.bind.Dialer(proto)
+	if err != nil {
+		return err
+	}
+	r := net.Resolver{
+		PreferGo: true, // override system DNS
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, proto, net.JoinHostPort(dns.resolver, "53"))
+		},
+	}
+
+	if dns.tls {
+		// Verify the resolver's certificate, so lookups can't be tampered with
+		serverName := dns.tlsName
+		if serverName == "" {
+			serverName = dns.resolver
+		}
+
+		dialer, err := dns.bind.Dialer(stream)
+		if err != nil {
+			return err
+		}
+		r.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, stream, net.JoinHostPort(dns.resolver, "853"))
+			if err != nil {
+				return nil, err
+			}
+
+			tc := tls.Client(conn, &tls.Config{ServerName: serverName})
+			if deadline, ok := ctx.Deadline(); ok {
+				tc.SetDeadline(deadline)
+			}
+			err = tc.Handshake()
+			if err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("DoT: %v", err)
+			}
+			return tc, nil
+		}
+	}
+
+	if dns.doh != "" {
+		r.Dial = newDoHDial(dns.doh, dns.dohHTTP)
+	}
+
+	if dns.txt {
+		return dns.lookupTXT(ctx, &r)
+	}
+
+	ip, err := r.LookupIP(ctx, dns.network, dns.addr)
+	if err != nil {
+		return fmt.Errorf("DNS lookup error: %s", err)
+	}
+
+	dns.ip = ip
+	return nil
+}
+
+func (dns *resolver) lookupTXT(ctx context.Context, r *net.Resolver) error {
+	txt, err := r.LookupTXT(ctx, dns.addr)
+	if err != nil {
+		return fmt.Errorf("DNS lookup error: %s", err)
+	}
+
+	// Skip any informational records which don't hold an address
+	var ips []net.IP
+	for _, t := range txt {
+		ip, err := ParseIP(t, dns.network)
+		if err == nil {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("DNS lookup error: no address in TXT records of %s", dns.addr)
+	}
+
+	dns.ip = ips
+	return nil
+}
+
+// DNSOptions configures a detector querying a DNS echo service, which
+// answers queries for a special hostname with the address of the querier
+type DNSOptions struct {
+	Hostname  string
+	Resolvers []string // tried in order until one answers
+	TXT       bool     // the address is published as a TXT record
+
+	DoH           string // DNS-over-HTTPS endpoint used instead of the resolvers
+	TLS           bool   // query the resolvers over DNS-over-TLS
+	TLSServerName string // server name to verify, defaults to the resolver
+
+	Binding *Binding
+}
+
+// Well known DNS echo services. Google's and Akamai's must be queried on
+// their authoritative name servers.
+var (
+	OpenDNS = DNSOptions{
+		Hostname: "myip.opendns.com",
+		Resolvers: []string{
+			"resolver1.opendns.com",
+			"resolver2.opendns.com",
+			"resolver3.opendns.com",
+			"resolver4.opendns.com",
+		},
+	}
+	Google = DNSOptions{
+		Hostname: "o-o.myaddr.l.google.com",
+		Resolvers: []string{
+			"ns1.google.com",
+			"ns2.google.com",
+			"ns3.google.com",
+			"ns4.google.com",
+		},
+		TXT: true,
+	}
+	Akamai = DNSOptions{
+		Hostname: "whoami.akamai.net",
+		Resolvers: []string{
+			"ns1-1.akamaitech.net",
+		},
+	}
+)
+
+// dnsDetector learns the public IP from a DNS echo service
+type dnsDetector struct {
+	DNSOptions
+
+	// Clients of the DoH endpoint by network, reused across detections
+	dohHTTP map[string]*http.Client
+}
+
+// NewDNS returns a detector querying the DNS echo service described by opts,
+// such as OpenDNS
+func NewDNS(opts DNSOptions) (Detector, error) {
+	if opts.Hostname == "" || len(opts.Resolvers) == 0 {
+		return nil, errors.New("the hostname and resolvers of a DNS detector must not be empty")
+	}
+
+	d := &dnsDetector{DNSOptions: opts}
+
+	// Connect over the address family being looked up, as for resolvers
+	if d.DoH != "" {
+		d.dohHTTP = map[string]*http.Client{
+			"ip4": detectionClient("tcp4", d.Binding),
+			"ip6": detectionClient("tcp6", d.Binding),
+		}
+	}
+
+	return d, nil
+}
+
+func (d *dnsDetector) Detect(ctx context.Context, network string) (net.IP, error) {
+	var err error
+	for _, r := range d.Resolvers {
+		dns := resolver{
+			addr:     d.Hostname,
+			resolver: r,
+			network:  network,
+			txt:      d.TXT,
+			doh:      d.DoH,
+			dohHTTP:  d.dohHTTP[network],
+			tls:      d.TLS,
+			tlsName:  d.TLSServerName,
+			bind:     d.Binding,
+		}
+
+		err = dns.lookup(ctx)
+		if err == nil {
+			return dns.ip[0], nil
+		}
+
+		// A DoH endpoint replaces the resolvers, so there's nothing to fall
+		// back to
+		if d.DoH != "" || ctx.Err() != nil {
+			break
+		}
+		log.Debugf("detector: resolver %s: %v", r, err)
+	}
+
+	return net.IP{}, err
+}
@@ -0,0 +1,92 @@
+package detect
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// dohConn carries the DNS messages of a net.Resolver over DNS-over-HTTPS
+// (RFC 8484). It is not a net.PacketConn, so the resolver frames messages as
+// it would over TCP, with a two byte length prefix.
+type dohConn struct {
+	ctx    context.Context
+	url    string
+	client *http.Client
+
+	resp     bytes.Buffer
+	deadline time.Time
+}
+
+// newDoHDial returns a resolver Dial function sending queries to the DoH
+// endpoint at url with client, which is reused across lookups
+func newDoHDial(url string, client *http.Client) func(ctx context.Context, _, _ string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return &dohConn{ctx: ctx, url: url, client: client}, nil
+	}
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	if len(b) < 2 || int(binary.BigEndian.Uint16(b)) != len(b)-2 {
+		return 0, errors.New("DoH: unexpected message framing")
+	}
+
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(b[2:]))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("DoH: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("DoH: unexpected status %s", resp.Status)
+	}
+
+	msg, err := ioutil.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return 0, fmt.Errorf("DoH: %v", err)
+	}
+
+	// Queue the length prefixed response for the resolver to read
+	c.resp.Reset()
+	c.resp.Write([]byte{byte(len(msg) >> 8), byte(len(msg))})
+	c.resp.Write(msg)
+
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	return c.resp.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { c.deadline = t; return nil }
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
@@ -0,0 +1,31 @@
+package detect
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/ianmuscat/dyn/internal/command"
+)
+
+// execDetector runs an external command which prints the public IP, for
+// detection methods dyn doesn't support such as scraping a modem's status page
+type execDetector struct {
+	command string
+	args    []string
+}
+
+// NewExec returns a detector running command with args. The network being
+// detected is passed as DYN_NETWORK.
+func NewExec(command string, args []string) Detector {
+	return &execDetector{command: command, args: args}
+}
+
+func (d *execDetector) Detect(ctx context.Context, network string) (net.IP, error) {
+	out, err := command.Run(ctx, d.command, d.args, []string{"DYN_NETWORK=" + network}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("exec: %v", err)
+	}
+
+	return ParseIP(out, network)
+}
@@ -0,0 +1,45 @@
+package detect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// fallbackDetector tries an ordered list of detectors, falling back to the
+// next whenever one times out or fails
+type fallbackDetector struct {
+	sources []Source
+}
+
+// NewFallback returns a detector trying sources in order
+func NewFallback(sources []Source) (Detector, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("no sources")
+	}
+
+	return &fallbackDetector{sources: sources}, nil
+}
+
+func (d *fallbackDetector) Detect(ctx context.Context, network string) (net.IP, error) {
+	var lastErr error
+	for i, source := range d.sources {
+		ip, err := source.Detect(ctx, network)
+		if err == nil {
+			return ip, nil
+		}
+
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(d.sources)-1 {
+			log.Warnf("detector: %s failed, falling back to %s: %v", source.Name, d.sources[i+1].Name, err)
+		}
+	}
+
+	return nil, fmt.Errorf("all detectors failed: %v", lastErr)
+}
@@ -0,0 +1,126 @@
+package detect
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultHTTPEndpoints are queried by HTTP detectors without endpoints
+var DefaultHTTPEndpoints = []string{
+	"https://api64.ipify.org",
+	"https://icanhazip.com",
+}
+
+// httpDetector learns the public IP from HTTP(S) services which respond with
+// the address of the caller in plain text. Endpoints are tried in order until
+// one succeeds.
+type httpDetector struct {
+	endpoints []string
+	clients   map[string]*http.Client // by network, reused across detections
+}
+
+// NewHTTP returns a detector querying endpoints, or DefaultHTTPEndpoints
+// when empty, for the address of the caller. Connections are bound by bind,
+// which may be nil.
+func NewHTTP(endpoints []string, bind *Binding) Detector {
+	if len(endpoints) == 0 {
+		endpoints = DefaultHTTPEndpoints
+	}
+
+	// Force connections over the requested address family, so dual-stack
+	// services report the matching address
+	clients := map[string]*http.Client{
+		"ip4": detectionClient("tcp4", bind),
+		"ip6": detectionClient("tcp6", bind),
+	}
+	for _, c := range clients {
+		c.Timeout = 30 * time.Second
+	}
+
+	return &httpDetector{endpoints: endpoints, clients: clients}
+}
+
+// detectionClient returns an HTTP client connecting over proto, tcp4 or
+// tcp6, through bind. Bound clients bypass the proxy, which would connect
+// from its own uplink.
+func detectionClient(proto string, bind *Binding) *http.Client {
+	transport := &http.Transport{
+		Proxy: defaultProxy,
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			dialer, err := bind.Dialer(proto)
+			if err != nil {
+				return nil, err
+			}
+			dialer.Timeout = 10 * time.Second
+			return dialer.DialContext(ctx, proto, addr)
+		},
+	}
+	if bind != nil {
+		transport.Proxy = nil
+	}
+	return &http.Client{Transport: transport}
+}
+
+// defaultProxy returns the proxy the default transport uses for req, so
+// detections go through the same proxy as other requests of the program
+func defaultProxy(req *http.Request) (*url.URL, error) {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return http.ProxyFromEnvironment(req)
+	}
+	if t.Proxy == nil {
+		return nil, nil
+	}
+	return t.Proxy(req)
+}
+
+func (d *httpDetector) Detect(ctx context.Context, network string) (net.IP, error) {
+	client := d.clients[network]
+
+	var lastErr error
+	for _, endpoint := range d.endpoints {
+		ip, err := d.detect(ctx, client, endpoint, network)
+		if err == nil {
+			return ip, nil
+		}
+
+		log.Debugf("detector: %s: %v", endpoint, err)
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("HTTP detection failed: %v", lastErr)
+}
+
+func (d *httpDetector) detect(ctx context.Context, client *http.Client, endpoint, network string) (net.IP, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain")
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	// An address is never longer than a few dozen bytes
+	body := make([]byte, 64)
+	n, err := io.ReadFull(resp.Body, body)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	return ParseIP(string(body[:n]), network)
+}
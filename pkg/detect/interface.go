@@ -0,0 +1,73 @@
+package detect
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// InterfaceOptions configures a detector reading the public IP from an
+// address bound to a local interface, for hosts which have a public address
+// assigned directly
+type InterfaceOptions struct {
+	Name string
+
+	// GlobalOnly skips private and link-local addresses
+	GlobalOnly bool
+	// ExcludeTemporary skips IPv6 privacy extension addresses
+	ExcludeTemporary bool
+}
+
+type interfaceDetector struct {
+	InterfaceOptions
+}
+
+// NewInterface returns a detector reading the addresses of the interface
+// described by opts
+func NewInterface(opts InterfaceOptions) Detector {
+	return &interfaceDetector{opts}
+}
+
+func (d *interfaceDetector) Detect(ctx context.Context, network string) (net.IP, error) {
+	iface, err := net.InterfaceByName(d.Name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %v", d.Name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %v", d.Name, err)
+	}
+
+	// Temporary (privacy extension) addresses change frequently and are not
+	// meant to be published
+	var temporary map[string]bool
+	if d.ExcludeTemporary && network == "ip6" {
+		temporary, err = temporaryAddrs(d.Name)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: %v", d.Name, err)
+		}
+	}
+
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipnet.IP
+
+		if (ip.To4() != nil) != (network == "ip4") {
+			continue
+		}
+		if d.GlobalOnly && (!ip.IsGlobalUnicast() || ip.IsPrivate()) {
+			continue
+		}
+		if temporary[ip.String()] {
+			continue
+		}
+
+		return ip, nil
+	}
+
+	return nil, fmt.Errorf("interface %s has no suitable %s address", d.Name, network)
+}
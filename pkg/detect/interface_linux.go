@@ -0,0 +1,69 @@
+package detect
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ifaFlagTemporary marks IPv6 privacy extension addresses (IFA_F_TEMPORARY)
+const ifaFlagTemporary = 0x01
+
+// temporaryAddrs returns the temporary IPv6 addresses of an interface, as
+// listed in /proc/net/if_inet6
+func temporaryAddrs(name string) (map[string]bool, error) {
+	f, err := os.Open("/proc/net/if_inet6")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	temporary := map[string]bool{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// address, index, prefix length, scope, flags, interface name
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 || fields[5] != name {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil || flags&ifaFlagTemporary == 0 {
+			continue
+		}
+
+		b, err := hex.DecodeString(fields[0])
+		if err != nil || len(b) != net.IPv6len {
+			continue
+		}
+		temporary[net.IP(b).String()] = true
+	}
+
+	return temporary, s.Err()
+}
+
+// bindToDevice returns a dialer Control function binding sockets to an
+// interface with SO_BINDTODEVICE, which requires CAP_NET_RAW before Linux 5.7
+func bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			err = syscall.BindToDevice(int(fd), name)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		if err != nil {
+			return fmt.Errorf("binding to interface %s: %v", name, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package detect
+
+import "syscall"
+
+// temporaryAddrs is only able to identify temporary addresses on Linux
+func temporaryAddrs(name string) (map[string]bool, error) {
+	return nil, nil
+}
+
+// bindToDevice is only supported on Linux, elsewhere sockets are bound to an
+// address of the interface instead
+func bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,196 @@
+package detect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+// OverlayDetector is implemented by detectors reading the host's address on
+// an overlay network such as Tailscale or ZeroTier. Those addresses are
+// private by design, so they're published without checking they're public.
+type OverlayDetector interface {
+	Overlay()
+}
+
+// tailscaleDetector reads the host's Tailscale address from the local
+// tailscaled API
+type tailscaleDetector struct {
+	client *http.Client
+}
+
+// DefaultTailscaleSocket is the socket of the tailscaled API on Linux
+const DefaultTailscaleSocket = "/var/run/tailscale/tailscaled.sock"
+
+// NewTailscale returns a detector asking the tailscaled API listening on
+// socket, or DefaultTailscaleSocket when empty
+func NewTailscale(socket string) Detector {
+	if socket == "" {
+		socket = DefaultTailscaleSocket
+	}
+
+	return &tailscaleDetector{
+		client: &http.Client{Transport: httpapi.UnixTransport(socket)},
+	}
+}
+
+func (d *tailscaleDetector) Overlay() {}
+
+func (d *tailscaleDetector) Detect(ctx context.Context, network string) (net.IP, error) {
+	// tailscaled only answers requests for its own host name, guarding
+	// against DNS rebinding
+	req, err := http.NewRequest("GET", "http://local-tailscaled.sock/localapi/v0/status", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var status struct {
+		BackendState string `json:"BackendState"`
+		Self         struct {
+			TailscaleIPs []string `json:"TailscaleIPs"`
+		} `json:"Self"`
+	}
+	err = overlayJSON(ctx, d.client, req, &status)
+	if err != nil {
+		return nil, fmt.Errorf("tailscale: %v", err)
+	}
+	if status.BackendState != "Running" {
+		return nil, fmt.Errorf("tailscale: not connected (%s)", status.BackendState)
+	}
+
+	ip := overlayAddress(status.Self.TailscaleIPs, network)
+	if ip == nil {
+		return nil, fmt.Errorf("tailscale: no %s address assigned", network)
+	}
+	return ip, nil
+}
+
+// ZeroTierOptions configures a detector reading the host's address in a
+// ZeroTier network from the local zerotier-one API
+type ZeroTierOptions struct {
+	URL string // defaults to http://127.0.0.1:9993
+
+	// The API token, read from TokenFile when empty
+	Token     string
+	TokenFile string // defaults to /var/lib/zerotier-one/authtoken.secret
+
+	// The network ID, which may be empty when the host joined a single one
+	Network string
+}
+
+type zerotierDetector struct {
+	url       string
+	token     string
+	tokenFile string
+	network   string
+}
+
+// NewZeroTier returns a detector asking the zerotier-one API described by
+// opts
+func NewZeroTier(opts ZeroTierOptions) Detector {
+	if opts.URL == "" {
+		opts.URL = "http://127.0.0.1:9993"
+	}
+	if opts.TokenFile == "" {
+		opts.TokenFile = "/var/lib/zerotier-one/authtoken.secret"
+	}
+
+	return &zerotierDetector{
+		url:       strings.TrimSuffix(opts.URL, "/"),
+		token:     opts.Token,
+		tokenFile: opts.TokenFile,
+		network:   strings.ToLower(opts.Network),
+	}
+}
+
+func (d *zerotierDetector) Overlay() {}
+
+func (d *zerotierDetector) Detect(ctx context.Context, network string) (net.IP, error) {
+	// The token is read on every detection, as zerotier-one creates it on
+	// its first start
+	token := d.token
+	if token == "" {
+		data, err := ioutil.ReadFile(d.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("zerotier: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	req, err := http.NewRequest("GET", d.url+"/network", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-ZT1-Auth", token)
+
+	var networks []struct {
+		ID                string   `json:"id"`
+		Name              string   `json:"name"`
+		Status            string   `json:"status"`
+		AssignedAddresses []string `json:"assignedAddresses"`
+	}
+	err = overlayJSON(ctx, http.DefaultClient, req, &networks)
+	if err != nil {
+		return nil, fmt.Errorf("zerotier: %v", err)
+	}
+
+	// Without a network ID, the host must have joined a single network
+	if d.network == "" && len(networks) != 1 {
+		return nil, fmt.Errorf("zerotier: member of %d networks, set the network to use", len(networks))
+	}
+	for _, n := range networks {
+		if d.network != "" && n.ID != d.network {
+			continue
+		}
+		if n.Status != "OK" {
+			return nil, fmt.Errorf("zerotier: network %s is not available (%s)", n.ID, n.Status)
+		}
+
+		ip := overlayAddress(n.AssignedAddresses, network)
+		if ip == nil {
+			return nil, fmt.Errorf("zerotier: no %s address assigned in network %s", network, n.ID)
+		}
+		return ip, nil
+	}
+	return nil, fmt.Errorf("zerotier: not a member of network %s", d.network)
+}
+
+// overlayJSON sends a request to the API of a local daemon with client, and
+// decodes the JSON response body into out
+func overlayJSON(ctx context.Context, client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &httpapi.Error{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: string(data)}
+	}
+	return json.Unmarshal(data, out)
+}
+
+// overlayAddress returns the first address of the family of network in
+// addrs, given with or without a prefix length
+func overlayAddress(addrs []string, network string) net.IP {
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			ip, _, _ = net.ParseCIDR(a)
+		}
+		if ip != nil && (ip.To4() != nil) == (network == "ip4") {
+			return ip
+		}
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package detect
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+	log "github.com/sirupsen/logrus"
+)
+
+// routerDetector asks the local router for its WAN address using NAT-PMP
+// (also answered by PCP capable routers) or UPnP IGD, so no external service
+// is involved. Only IPv4 is supported, since routers don't NAT IPv6.
+type routerDetector struct {
+	method  string
+	gateway string
+}
+
+// NewRouter returns a detector asking the router at gateway with method,
+// one of auto, natpmp or upnp. An empty method means auto, and an empty
+// gateway the default gateway.
+func NewRouter(method, gateway string) (Detector, error) {
+	switch method {
+	case "":
+		method = "auto"
+	case "auto", "natpmp", "upnp":
+	default:
+		return nil, fmt.Errorf("unknown method '%s', expected one of auto, natpmp or upnp", method)
+	}
+
+	return &routerDetector{method: method, gateway: gateway}, nil
+}
+
+func (d *routerDetector) Detect(ctx context.Context, network string) (net.IP, error) {
+	if network != "ip4" {
+		return nil, fmt.Errorf("router detection only supports IPv4")
+	}
+
+	ip, err := RouterWANIP(ctx, d.method, d.gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseIP(ip.String(), network)
+}
+
+// RouterWANIP queries the router for its WAN address with the given method,
+// trying NAT-PMP and then UPnP when method is "auto"
+func RouterWANIP(ctx context.Context, method, gateway string) (net.IP, error) {
+	if method == "upnp" {
+		return upnpExternalIP(ctx)
+	}
+
+	if gateway == "" {
+		gw, err := defaultGateway()
+		if err != nil && method == "natpmp" {
+			return nil, fmt.Errorf("NAT-PMP: %v, set the gateway explicitly", err)
+		}
+		gateway = gw
+	}
+
+	if gateway != "" {
+		ip, err := natpmpExternalIP(ctx, gateway)
+		if err == nil || method == "natpmp" {
+			return ip, err
+		}
+		log.Debugf("detector: NAT-PMP: %v", err)
+	}
+
+	return upnpExternalIP(ctx)
+}
+
+// natpmpExternalIP sends a NAT-PMP external address request (RFC 6886)
+func natpmpExternalIP(ctx context.Context, gateway string) (net.IP, error) {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "udp4", net.JoinHostPort(gateway, "5351"))
+	if err != nil {
+		return nil, fmt.Errorf("NAT-PMP: %v", err)
+	}
+	defer conn.Close()
+
+	// Retransmit with a doubling timeout, as the RFC recommends
+	resp := make([]byte, 16)
+	timeout := 250 * time.Millisecond
+	for attempt := 0; attempt < 4; attempt++ {
+		conn.SetDeadline(time.Now().Add(timeout))
+		timeout *= 2
+
+		_, err = conn.Write([]byte{0, 0})
+		if err != nil {
+			return nil, fmt.Errorf("NAT-PMP: %v", err)
+		}
+
+		var n int
+		n, err = conn.Read(resp)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && ctx.Err() == nil {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("NAT-PMP: %v", err)
+		}
+
+		if n < 12 || resp[0] != 0 || resp[1] != 128 {
+			return nil, fmt.Errorf("NAT-PMP: unexpected response")
+		}
+		if code := binary.BigEndian.Uint16(resp[2:]); code != 0 {
+			return nil, fmt.Errorf("NAT-PMP: request failed with result code %d", code)
+		}
+
+		return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+	}
+
+	return nil, fmt.Errorf("NAT-PMP: no response from %s", gateway)
+}
+
+// defaultGateway returns the IPv4 default gateway from the Linux routing
+// table
+func defaultGateway() (string, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", fmt.Errorf("unable to determine default gateway")
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+
+		// The gateway is a little endian hexadecimal address
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		return net.IPv4(b[3], b[2], b[1], b[0]).String(), nil
+	}
+
+	return "", fmt.Errorf("no default gateway")
+}
+
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// upnpExternalIP discovers an Internet Gateway Device with SSDP and asks it
+// for its external address
+func upnpExternalIP(ctx context.Context) (net.IP, error) {
+	location, err := ssdpDiscover(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("UPnP: %v", err)
+	}
+
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := httpapi.Do(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("UPnP: device description: %v", err)
+	}
+
+	var desc struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	err = xml.Unmarshal(data, &desc)
+	if err != nil {
+		return nil, fmt.Errorf("UPnP: device description: %v", err)
+	}
+
+	serviceType, controlURL := upnpFindService(desc.Device)
+	if controlURL == "" {
+		return nil, fmt.Errorf("UPnP: no WAN connection service found")
+	}
+
+	// The control URL is relative to the URL base or description location
+	base := desc.URLBase
+	if base == "" {
+		base = location
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("UPnP: %v", err)
+	}
+	control, err := baseURL.Parse(controlURL)
+	if err != nil {
+		return nil, fmt.Errorf("UPnP: %v", err)
+	}
+
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + serviceType + `"/></s:Body></s:Envelope>`
+
+	req, err = http.NewRequest("POST", control.String(), strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+serviceType+`#GetExternalIPAddress"`)
+
+	data, err = httpapi.Do(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("UPnP: GetExternalIPAddress: %v", err)
+	}
+
+	var resp struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	err = xml.Unmarshal(data, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("UPnP: GetExternalIPAddress: %v", err)
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(resp.IP))
+	if ip == nil {
+		return nil, fmt.Errorf("UPnP: router returned invalid address '%s'", resp.IP)
+	}
+	return ip, nil
+}
+
+// upnpFindService searches the device tree for a WAN connection service
+func upnpFindService(d upnpDevice) (string, string) {
+	for _, s := range d.Services {
+		for _, t := range upnpServiceTypes {
+			if s.ServiceType == t {
+				return s.ServiceType, s.ControlURL
+			}
+		}
+	}
+
+	for _, child := range d.Devices {
+		if t, u := upnpFindService(child); u != "" {
+			return t, u
+		}
+	}
+
+	return "", ""
+}
+
+// ssdpDiscover multicasts an SSDP search for Internet Gateway Devices and
+// returns the description location of the first to answer
+func ssdpDiscover(ctx context.Context) (string, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	deadline := time.Now().Add(3 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+	_, err = conn.WriteTo([]byte(search), group)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("no Internet Gateway Device found")
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		if location := resp.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
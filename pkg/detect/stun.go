@@ -0,0 +1,189 @@
+package detect
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	stunMagicCookie = 0x2112a442
+
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+
+	stunAttrMappedAddress    = 0x0001
+	stunAttrXorMappedAddress = 0x0020
+)
+
+// DefaultSTUNServers are queried by STUN detectors without servers
+var DefaultSTUNServers = []string{
+	"stun.l.google.com:19302",
+	"stun.cloudflare.com:3478",
+}
+
+// stunDetector learns the public IP by sending a STUN binding request
+// (RFC 5389) and reading the mapped address of the response. Servers are
+// tried in order until one succeeds.
+type stunDetector struct {
+	servers []string
+	bind    *Binding
+}
+
+// NewSTUN returns a detector querying servers, or DefaultSTUNServers when
+// empty, for the mapped address. Requests are bound by bind, which may be
+// nil.
+func NewSTUN(servers []string, bind *Binding) Detector {
+	if len(servers) == 0 {
+		servers = DefaultSTUNServers
+	}
+	return &stunDetector{servers: servers, bind: bind}
+}
+
+func (d *stunDetector) Detect(ctx context.Context, network string) (net.IP, error) {
+	var lastErr error
+	for _, server := range d.servers {
+		ip, err := d.detect(ctx, server, network)
+		if err == nil {
+			return ParseIP(ip.String(), network)
+		}
+
+		log.Debugf("detector: %s: %v", server, err)
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("STUN detection failed: %v", lastErr)
+}
+
+func (d *stunDetector) detect(ctx context.Context, server, network string) (net.IP, error) {
+	proto := "udp4"
+	if network == "ip6" {
+		proto = "udp6"
+	}
+
+	dialer, err := d.bind.Dialer(proto)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.DialContext(ctx, proto, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Binding request: type, length, magic cookie and transaction ID
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	_, err = rand.Read(req[8:20])
+	if err != nil {
+		return nil, err
+	}
+
+	// Retransmit over UDP until a response arrives
+	resp := make([]byte, 1024)
+	for attempt := 0; attempt < 3; attempt++ {
+		deadline := time.Now().Add(2 * time.Second)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetDeadline(deadline)
+
+		_, err = conn.Write(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var n int
+		n, err = conn.Read(resp)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && ctx.Err() == nil {
+				continue
+			}
+			return nil, err
+		}
+
+		return parseSTUNResponse(resp[:n], req[8:20])
+	}
+
+	return nil, err
+}
+
+// parseSTUNResponse extracts the mapped address from a binding response
+func parseSTUNResponse(msg, txID []byte) (net.IP, error) {
+	if len(msg) < 20 || binary.BigEndian.Uint16(msg[0:]) != stunBindingResponse {
+		return nil, fmt.Errorf("unexpected STUN message")
+	}
+	if binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie || !bytes.Equal(msg[8:20], txID) {
+		return nil, fmt.Errorf("STUN transaction mismatch")
+	}
+
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if 20+length > len(msg) {
+		return nil, fmt.Errorf("truncated STUN message")
+	}
+
+	var mapped net.IP
+	attrs := msg[20 : 20+length]
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		size := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+size > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+size]
+
+		switch typ {
+		case stunAttrXorMappedAddress:
+			// The address is XORed with the magic cookie and transaction ID
+			ip := stunAddress(value)
+			if ip != nil {
+				key := append(msg[4:8:8], txID...)
+				for i := range ip {
+					ip[i] ^= key[i]
+				}
+				return ip, nil
+			}
+		case stunAttrMappedAddress:
+			mapped = stunAddress(value)
+		}
+
+		// Attributes are padded to a multiple of four bytes
+		next := 4 + (size+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+
+	if mapped == nil {
+		return nil, fmt.Errorf("STUN response has no mapped address")
+	}
+	return mapped, nil
+}
+
+// stunAddress decodes the address of a (XOR-)MAPPED-ADDRESS attribute value
+func stunAddress(value []byte) net.IP {
+	if len(value) < 4 {
+		return nil
+	}
+
+	switch value[1] {
+	case 0x01:
+		if len(value) >= 8 {
+			return append(net.IP{}, value[4:8]...)
+		}
+	case 0x02:
+		if len(value) >= 20 {
+			return append(net.IP{}, value[4:20]...)
+		}
+	}
+
+	return nil
+}
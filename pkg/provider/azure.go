@@ -0,0 +1,232 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+const (
+	azureManagementEndpoint = "https://management.azure.com"
+	azureDNSAPIVersion      = "2018-05-01"
+)
+
+// azure manages records in Azure DNS zones through the Azure Resource Manager
+// REST API, authenticating with either a service principal or the managed
+// identity of the host
+type azure struct {
+	subscriptionID string
+	resourceGroup  string
+	tenantID       string
+	clientID       string
+	clientSecret   string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// AzureOptions configures a provider managing Azure DNS zones. Without a
+// client secret, the managed identity of the host is used.
+type AzureOptions struct {
+	SubscriptionID string
+	ResourceGroup  string
+
+	// The service principal
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+}
+
+// NewAzure returns a provider managing the zones of the resource group of
+// opts
+func NewAzure(opts AzureOptions) (Provider, error) {
+	if opts.SubscriptionID == "" || opts.ResourceGroup == "" {
+		return nil, fmt.Errorf("azure: the subscription ID and resource group are required")
+	}
+	if opts.ClientSecret != "" && (opts.TenantID == "" || opts.ClientID == "") {
+		return nil, fmt.Errorf("azure: service principal authentication requires the tenant and client IDs")
+	}
+
+	return &azure{
+		subscriptionID: opts.SubscriptionID,
+		resourceGroup:  opts.ResourceGroup,
+		tenantID:       opts.TenantID,
+		clientID:       opts.ClientID,
+		clientSecret:   opts.ClientSecret,
+	}, nil
+}
+
+type azureRecordSet struct {
+	ID         string `json:"id,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Properties struct {
+		TTL      int `json:"TTL"`
+		ARecords []struct {
+			IPv4Address string `json:"ipv4Address"`
+		} `json:"ARecords,omitempty"`
+		AAAARecords []struct {
+			IPv6Address string `json:"ipv6Address"`
+		} `json:"AAAARecords,omitempty"`
+	} `json:"properties"`
+}
+
+func (a *azure) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	var rs azureRecordSet
+	err := a.do(ctx, "GET", a.recordURL(zone, name, recordType), nil, &rs)
+	if httpapi.IsStatus(err, http.StatusNotFound) {
+		return Record{}, ErrRecordNotFound
+	}
+	if err != nil {
+		return Record{}, err
+	}
+
+	rec := Record{
+		ID:   rs.ID,
+		Zone: zone,
+		Name: name,
+		Type: recordType,
+		TTL:  rs.Properties.TTL,
+	}
+	switch {
+	case recordType == "A" && len(rs.Properties.ARecords) > 0:
+		rec.Content = rs.Properties.ARecords[0].IPv4Address
+	case recordType == "AAAA" && len(rs.Properties.AAAARecords) > 0:
+		rec.Content = rs.Properties.AAAARecords[0].IPv6Address
+	}
+
+	return rec, nil
+}
+
+func (a *azure) CreateRecord(ctx context.Context, r Record) error {
+	return a.put(ctx, r)
+}
+
+func (a *azure) UpdateRecord(ctx context.Context, r Record) error {
+	return a.put(ctx, r)
+}
+
+func (a *azure) put(ctx context.Context, r Record) error {
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = 300
+	}
+
+	props := map[string]interface{}{"TTL": ttl}
+	switch r.Type {
+	case "A":
+		props["ARecords"] = []map[string]string{{"ipv4Address": r.Content}}
+	case "AAAA":
+		props["AAAARecords"] = []map[string]string{{"ipv6Address": r.Content}}
+	default:
+		return fmt.Errorf("azure: unsupported record type %s", r.Type)
+	}
+
+	body := map[string]interface{}{"properties": props}
+	return a.do(ctx, "PUT", a.recordURL(r.Zone, r.Name, r.Type), body, nil)
+}
+
+// recordURL returns the resource URL of a record set, which Azure addresses by
+// its name relative to the zone
+func (a *azure) recordURL(zone, name, recordType string) string {
+	return fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/dnsZones/%s/%s/%s?api-version=%s",
+		azureManagementEndpoint,
+		url.PathEscape(a.subscriptionID),
+		url.PathEscape(a.resourceGroup),
+		url.PathEscape(zone),
+		recordType,
+		url.PathEscape(RelativeName(name, zone)),
+		azureDNSAPIVersion)
+}
+
+func (a *azure) do(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	token, err := a.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := httpapi.NewJSONRequest(method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	err = httpapi.JSON(ctx, req, out)
+	if err != nil && !httpapi.IsStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("azure: %v", err)
+	}
+	return err
+}
+
+type azureToken struct {
+	AccessToken string          `json:"access_token"`
+	ExpiresIn   json.RawMessage `json:"expires_in"` // a number or a string, depending on the endpoint
+}
+
+// accessToken returns a cached Azure Resource Manager token, requesting a new
+// one when it is about to expire
+func (a *azure) accessToken(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Until(a.expires) > 5*time.Minute {
+		return a.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	if a.clientSecret != "" {
+		// Service principal, using the client credentials grant
+		form := url.Values{}
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", a.clientID)
+		form.Set("client_secret", a.clientSecret)
+		form.Set("scope", azureManagementEndpoint+"/.default")
+
+		req, err = http.NewRequest("POST",
+			"https://login.microsoftonline.com/"+url.PathEscape(a.tenantID)+"/oauth2/v2.0/token",
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		// Managed identity, using the instance metadata service
+		q := url.Values{}
+		q.Set("api-version", "2018-02-01")
+		q.Set("resource", azureManagementEndpoint+"/")
+		if a.clientID != "" {
+			q.Set("client_id", a.clientID)
+		}
+
+		req, err = http.NewRequest("GET", "http://169.254.169.254/metadata/identity/oauth2/token?"+q.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata", "true")
+	}
+
+	var t azureToken
+	err = httpapi.JSON(ctx, req, &t)
+	if err != nil {
+		return "", fmt.Errorf("azure: authentication failed: %v", err)
+	}
+
+	expiresIn, err := strconv.Atoi(strings.Trim(string(t.ExpiresIn), `"`))
+	if err != nil {
+		return "", fmt.Errorf("azure: invalid token expiry %s", t.ExpiresIn)
+	}
+
+	a.token = t.AccessToken
+	a.expires = time.Now().Add(time.Duration(expiresIn) * time.Second)
+
+	return a.token, nil
+}
@@ -0,0 +1,269 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	cf "github.com/cloudflare/cloudflare-go"
+	"github.com/ianmuscat/dyn/internal/cfapi"
+)
+
+// cloudflare manages records through the Cloudflare API. Zones may be spread
+// across several accounts, each with its own credentials.
+type cloudflare struct {
+	api      *cf.API // default account, nil when only accounts are configured
+	accounts []*cf.API
+
+	mu    sync.Mutex
+	zones map[string]*cf.API // account of each zone
+
+	cache *cloudflareCache
+}
+
+// CloudflareAccount is an additional account of a Cloudflare provider, with
+// the zones it holds. Accounts without zones are searched for zones which
+// aren't listed anywhere.
+type CloudflareAccount struct {
+	API   *cf.API
+	Zones []string
+}
+
+// NewCloudflare returns a provider managing the zones of the default account
+// api, which may be nil, and of additional accounts. Records are cached for
+// cacheTTL, to notice changes made by others.
+func NewCloudflare(api *cf.API, accounts []CloudflareAccount, cacheTTL time.Duration) Provider {
+	c := &cloudflare{api: api, zones: map[string]*cf.API{}, cache: newCloudflareCache(cacheTTL)}
+	for _, a := range accounts {
+		c.accounts = append(c.accounts, a.API)
+		for _, zone := range a.Zones {
+			c.zones[zone] = a.API
+		}
+	}
+
+	return c
+}
+
+// account returns the API client of the account holding zone: the one
+// listing it, else the default account, else the first additional account
+// where the zone is found
+func (c *cloudflare) account(ctx context.Context, zone string) (*cf.API, error) {
+	c.mu.Lock()
+	api, ok := c.zones[zone]
+	c.mu.Unlock()
+	if ok {
+		return api, nil
+	}
+	if c.api != nil {
+		return c.api, nil
+	}
+
+	for _, api := range c.accounts {
+		zoneID, err := cfapi.ZoneIDByName(ctx, api, zone)
+		if err == nil {
+			c.mu.Lock()
+			c.zones[zone] = api
+			c.mu.Unlock()
+			c.cache.setZoneID(zone, zoneID)
+			return api, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, fmt.Errorf("cloudflare: zone %s not found in any account", zone)
+}
+
+func (c *cloudflare) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	recs, err := c.GetRecords(ctx, zone, name, recordType)
+	if err != nil {
+		return Record{}, err
+	}
+
+	if len(recs) == 0 {
+		return Record{}, ErrRecordNotFound
+	}
+
+	return recs[0], nil
+}
+
+func (c *cloudflare) GetRecords(ctx context.Context, zone, name, recordType string) ([]Record, error) {
+	if records, ok := c.cache.get(name, recordType); ok {
+		return records, nil
+	}
+
+	api, err := c.account(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	zoneID, err := c.zoneID(ctx, api, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the matching records of the given type
+	recs, err := api.DNSRecords(ctx, zoneID, cf.DNSRecord{Type: recordType, Name: name})
+	if err != nil {
+		c.cache.invalidate(zone, name, recordType)
+		return nil, err
+	}
+
+	var records []Record
+	for _, r := range recs {
+		records = append(records, fromCloudflare(r, zone))
+	}
+	c.cache.set(name, recordType, records)
+	return records, nil
+}
+
+func (c *cloudflare) CreateRecord(ctx context.Context, r Record) error {
+	api, err := c.account(ctx, r.Zone)
+	if err != nil {
+		return err
+	}
+
+	zoneID := r.ZoneID
+	if zoneID == "" {
+		zoneID, err = c.zoneID(ctx, api, r.Zone)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The created record is listed on the next check, for its ID
+	defer c.cache.invalidate(r.Zone, r.Name, r.Type)
+	_, err = api.CreateDNSRecord(ctx, zoneID, toCloudflare(r))
+	return err
+}
+
+func (c *cloudflare) UpdateRecord(ctx context.Context, r Record) error {
+	api, err := c.account(ctx, r.Zone)
+	if err != nil {
+		return err
+	}
+
+	err = api.UpdateDNSRecord(ctx, r.ZoneID, r.ID, toCloudflare(r))
+	if err != nil {
+		// The record may have been deleted or changed by someone else
+		c.cache.invalidate(r.Zone, r.Name, r.Type)
+		return err
+	}
+
+	c.cache.updated(r)
+	return nil
+}
+
+func (c *cloudflare) DeleteRecord(ctx context.Context, r Record) error {
+	api, err := c.account(ctx, r.Zone)
+	if err != nil {
+		return err
+	}
+
+	defer c.cache.invalidate(r.Zone, r.Name, r.Type)
+	return api.DeleteDNSRecord(ctx, r.ZoneID, r.ID)
+}
+
+// Verify checks that the account holding zone accepts the credentials, and
+// that they may edit its DNS records
+func (c *cloudflare) Verify(ctx context.Context, zone string) error {
+	api, err := c.account(ctx, zone)
+	if err != nil {
+		return &CredentialError{Zone: zone, Reason: err.Error()}
+	}
+
+	_, err = api.UserDetails(ctx)
+	if isCloudflareStatus(err, http.StatusUnauthorized, http.StatusForbidden) {
+		return &CredentialError{Zone: zone, Reason: "the API key or email is invalid"}
+	}
+	if err != nil {
+		return err
+	}
+
+	zoneID, err := c.zoneID(ctx, api, zone)
+	if err != nil && strings.Contains(err.Error(), "Zone could not be found") {
+		return &CredentialError{Zone: zone, Reason: "the zone isn't part of the account"}
+	}
+	if err != nil {
+		return err
+	}
+
+	details, err := api.ZoneDetails(ctx, zoneID)
+	if isCloudflareStatus(err, http.StatusUnauthorized, http.StatusForbidden) {
+		return &CredentialError{Zone: zone, Reason: "missing permission to read the zone"}
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(details.Permissions) > 0 && !containsString(details.Permissions, "#dns_records:edit") {
+		return &CredentialError{Zone: zone, Reason: "missing permission to edit DNS records"}
+	}
+	return nil
+}
+
+// isCloudflareStatus reports whether err is a response with one of the
+// given status codes. The library only reports them in the message.
+func isCloudflareStatus(err error, codes ...int) bool {
+	if err == nil {
+		return false
+	}
+	for _, code := range codes {
+		if strings.Contains(err.Error(), fmt.Sprintf("HTTP status %d", code)) {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneID returns the ID of zone, looking it up on the first use
+func (c *cloudflare) zoneID(ctx context.Context, api *cf.API, zone string) (string, error) {
+	if id, ok := c.cache.zoneID(zone); ok {
+		return id, nil
+	}
+
+	zoneID, err := cfapi.ZoneIDByName(ctx, api, zone)
+	if err != nil {
+		return "", err
+	}
+
+	c.cache.setZoneID(zone, zoneID)
+	return zoneID, nil
+}
+
+func fromCloudflare(r cf.DNSRecord, zone string) Record {
+	return Record{
+		ID:      r.ID,
+		ZoneID:  r.ZoneID,
+		Zone:    zone,
+		Name:    r.Name,
+		Type:    r.Type,
+		Content: r.Content,
+		TTL:     r.TTL,
+		Proxied: r.Proxied != nil && *r.Proxied,
+	}
+}
+
+func toCloudflare(r Record) cf.DNSRecord {
+	proxied := r.Proxied
+	return cf.DNSRecord{
+		Type:    r.Type,
+		Name:    r.Name,
+		Content: r.Content,
+		TTL:     r.TTL,
+		Proxied: &proxied,
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package provider
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// cloudflareCache remembers zone IDs and records between cycles, so that
+// checks which find nothing to change don't cost any API calls. Zone IDs are
+// kept until a call using them fails, records for ttl or until they change.
+type cloudflareCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	zoneIDs map[string]string
+	records map[string]cachedRecords
+}
+
+type cachedRecords struct {
+	records []Record
+	fetched time.Time
+}
+
+func newCloudflareCache(ttl time.Duration) *cloudflareCache {
+	return &cloudflareCache{
+		ttl:     ttl,
+		zoneIDs: map[string]string{},
+		records: map[string]cachedRecords{},
+	}
+}
+
+func cacheKey(name, recordType string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "/" + recordType
+}
+
+func (c *cloudflareCache) zoneID(zone string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, ok := c.zoneIDs[zone]
+	return id, ok
+}
+
+func (c *cloudflareCache) setZoneID(zone, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.zoneIDs[zone] = id
+}
+
+// get returns the cached records with name and type, unless they are older
+// than the TTL
+func (c *cloudflareCache) get(name, recordType string) ([]Record, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.records[cacheKey(name, recordType)]
+	if !ok || time.Since(cached.fetched) > c.ttl {
+		return nil, false
+	}
+	return append([]Record(nil), cached.records...), true
+}
+
+func (c *cloudflareCache) set(name, recordType string, records []Record) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.records[cacheKey(name, recordType)] = cachedRecords{records: records, fetched: time.Now()}
+}
+
+// updated replaces a cached record with its new version
+func (c *cloudflareCache) updated(r Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.records[cacheKey(r.Name, r.Type)]
+	if !ok {
+		return
+	}
+	for i := range cached.records {
+		if cached.records[i].ID == r.ID {
+			cached.records[i] = r
+		}
+	}
+}
+
+// invalidate forgets the records of a zone with name and type, and the ID of
+// the zone, so they are fetched again
+func (c *cloudflareCache) invalidate(zone, name, recordType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.records, cacheKey(name, recordType))
+	delete(c.zoneIDs, zone)
+}
@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+const (
+	desecEndpoint = "https://desec.io/api/v1"
+
+	// deSEC rejects RRsets with a TTL below the minimum of the domain, which
+	// defaults to one hour
+	desecMinTTL = 3600
+)
+
+// desec manages records through the deSEC API. deSEC asks clients to keep
+// updates infrequent and throttles them otherwise, which is reported as a
+// *RateLimitError so updates can be deferred until the API allows them.
+type desec struct {
+	token string
+}
+
+// NewDesec returns a provider managing deSEC domains, authenticated with
+// token
+func NewDesec(token string) Provider {
+	return &desec{token: token}
+}
+
+type desecRRset struct {
+	Subname string   `json:"subname"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl"`
+	Records []string `json:"records"`
+}
+
+func (d *desec) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	var rrset desecRRset
+	err := d.do(ctx, "GET", d.rrsetPath(zone, name, recordType), nil, &rrset)
+	if httpapi.IsStatus(err, http.StatusNotFound) {
+		return Record{}, ErrRecordNotFound
+	}
+	if err != nil {
+		return Record{}, fmt.Errorf("desec: %v", err)
+	}
+	if len(rrset.Records) == 0 {
+		return Record{}, ErrRecordNotFound
+	}
+
+	return Record{
+		Zone:    zone,
+		Name:    name,
+		Type:    rrset.Type,
+		Content: rrset.Records[0],
+		TTL:     rrset.TTL,
+	}, nil
+}
+
+func (d *desec) CreateRecord(ctx context.Context, r Record) error {
+	body := desecRRset{
+		Subname: SubName(r.Name, r.Zone),
+		Type:    r.Type,
+		TTL:     d.ttl(r.TTL),
+		Records: []string{r.Content},
+	}
+
+	return d.write(ctx, "POST", "/domains/"+url.PathEscape(r.Zone)+"/rrsets/", body)
+}
+
+func (d *desec) UpdateRecord(ctx context.Context, r Record) error {
+	body := map[string]interface{}{
+		"ttl":     d.ttl(r.TTL),
+		"records": []string{r.Content},
+	}
+
+	return d.write(ctx, "PATCH", d.rrsetPath(r.Zone, r.Name, r.Type), body)
+}
+
+func (d *desec) ttl(ttl int) int {
+	if ttl < desecMinTTL {
+		return desecMinTTL
+	}
+	return ttl
+}
+
+func (d *desec) MinTTL() int {
+	return desecMinTTL
+}
+
+// rrsetPath returns the API path of an RRset, where the zone apex is
+// addressed as "@"
+func (d *desec) rrsetPath(zone, name, recordType string) string {
+	return fmt.Sprintf("/domains/%s/rrsets/%s/%s/", url.PathEscape(zone), url.PathEscape(RelativeName(name, zone)), recordType)
+}
+
+// write performs a modifying request, reporting a *RateLimitError when the
+// API asks to back off
+func (d *desec) write(ctx context.Context, method, path string, body interface{}) error {
+	err := d.do(ctx, method, path, body, nil)
+	if httpapi.IsStatus(err, http.StatusTooManyRequests) {
+		retry := time.Minute
+		if s, perr := strconv.Atoi(err.(*httpapi.Error).Header.Get("Retry-After")); perr == nil {
+			retry = time.Duration(s) * time.Second
+		}
+		return &RateLimitError{Provider: "desec", RetryAfter: retry}
+	}
+	if err != nil {
+		return fmt.Errorf("desec: %v", err)
+	}
+	return nil
+}
+
+func (d *desec) do(ctx context.Context, method, path string, body, out interface{}) error {
+	req, err := httpapi.NewJSONRequest(method, desecEndpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+d.token)
+
+	return httpapi.JSON(ctx, req, out)
+}
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+// duckDNS updates DuckDNS subdomains through the token-based update URL
+type duckDNS struct {
+	token string
+}
+
+// NewDuckDNS returns a provider managing DuckDNS subdomains, authenticated
+// with the account token
+func NewDuckDNS(token string) Provider {
+	return &duckDNS{token: token}
+}
+
+func (d *duckDNS) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	return resolveRecord(ctx, zone, name, recordType)
+}
+
+func (d *duckDNS) CreateRecord(ctx context.Context, r Record) error {
+	return d.UpdateRecord(ctx, r)
+}
+
+func (d *duckDNS) UpdateRecord(ctx context.Context, r Record) error {
+	// DuckDNS identifies domains by their subdomain only
+	q := url.Values{}
+	q.Set("domains", strings.TrimSuffix(r.Name, ".duckdns.org"))
+	q.Set("token", d.token)
+	if r.Type == "AAAA" {
+		q.Set("ipv6", r.Content)
+	} else {
+		q.Set("ip", r.Content)
+	}
+
+	req, err := http.NewRequest("GET", "https://www.duckdns.org/update?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	// Errors leave out the URL, which holds the token
+	body, err := httpapi.Do(ctx, req)
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	if err != nil {
+		return fmt.Errorf("duckdns: %v", err)
+	}
+	if strings.TrimSpace(string(body)) != "OK" {
+		return fmt.Errorf("duckdns: update rejected, check the token and domain")
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+// dyndns2Errors describes the failure codes of the dyndns2 protocol
+var dyndns2Errors = map[string]string{
+	"badauth":  "invalid username or password",
+	"notfqdn":  "hostname is not a fully qualified domain name",
+	"nohost":   "hostname does not exist for this account",
+	"numhost":  "too many hosts in update",
+	"abuse":    "hostname is blocked for abuse",
+	"badagent": "user agent rejected",
+	"dnserr":   "server side DNS error",
+	"911":      "server side error or maintenance",
+	"!donator": "feature is not available for this account",
+}
+
+// dyndns2 updates records through any endpoint speaking the dyndns2 protocol,
+// as implemented by No-IP, DynDNS, FreeDNS and many others
+type dyndns2 struct {
+	url      string
+	username string
+	password string
+}
+
+// NewDyndns2 returns a provider sending updates to the dyndns2 update URL of
+// a service, authenticated with username and password
+func NewDyndns2(updateURL, username, password string) (Provider, error) {
+	_, err := url.Parse(updateURL)
+	if err != nil {
+		return nil, fmt.Errorf("dyndns2: invalid URL: %v", err)
+	}
+
+	return &dyndns2{url: updateURL, username: username, password: password}, nil
+}
+
+func (d *dyndns2) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	return resolveRecord(ctx, zone, name, recordType)
+}
+
+func (d *dyndns2) CreateRecord(ctx context.Context, r Record) error {
+	return d.UpdateRecord(ctx, r)
+}
+
+func (d *dyndns2) UpdateRecord(ctx context.Context, r Record) error {
+	u, err := url.Parse(d.url)
+	if err != nil {
+		return err
+	}
+
+	q := u.Query()
+	q.Set("hostname", r.Name)
+	q.Set("myip", r.Content)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(d.username, d.password)
+	req.Header.Set("User-Agent", "dyn")
+
+	body, err := httpapi.Do(ctx, req)
+	if err != nil {
+		return fmt.Errorf("dyndns2: %v", err)
+	}
+
+	// Successful responses are "good <ip>" or "nochg <ip>"
+	resp := strings.Fields(string(body))
+	if len(resp) == 0 {
+		return fmt.Errorf("dyndns2: empty response")
+	}
+	switch resp[0] {
+	case "good", "nochg":
+		return nil
+	}
+
+	if msg, ok := dyndns2Errors[resp[0]]; ok {
+		return fmt.Errorf("dyndns2: %s (%s)", msg, resp[0])
+	}
+	return fmt.Errorf("dyndns2: unexpected response '%s'", strings.TrimSpace(string(body)))
+}
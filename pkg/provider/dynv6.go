@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+// dynv6 updates dynv6 zones through the token-based update URL
+type dynv6 struct {
+	token string
+}
+
+// NewDynv6 returns a provider managing dynv6 zones, authenticated with an HTTP
+// token
+func NewDynv6(token string) Provider {
+	return &dynv6{token: token}
+}
+
+func (d *dynv6) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	return resolveRecord(ctx, zone, name, recordType)
+}
+
+func (d *dynv6) CreateRecord(ctx context.Context, r Record) error {
+	return d.UpdateRecord(ctx, r)
+}
+
+func (d *dynv6) UpdateRecord(ctx context.Context, r Record) error {
+	q := url.Values{}
+	q.Set("hostname", r.Name)
+	q.Set("token", d.token)
+	if r.Type == "AAAA" {
+		q.Set("ipv6", r.Content)
+	} else {
+		q.Set("ipv4", r.Content)
+	}
+
+	req, err := http.NewRequest("GET", "https://dynv6.com/api/update?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	// Errors leave out the URL, which holds the token
+	_, err = httpapi.Do(ctx, req)
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	if err != nil {
+		return fmt.Errorf("dynv6: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/ianmuscat/dyn/internal/command"
+)
+
+// execProvider hands record changes to an external command, for DNS providers
+// dyn doesn't support. The record is passed in DYN_* environment variables and
+// as JSON on stdin.
+type execProvider struct {
+	command string
+	args    []string
+	lookup  bool
+}
+
+// ExecOptions configures a provider handing record changes to a command
+type ExecOptions struct {
+	Command string
+	Args    []string
+
+	// Lookup asks the command for the current address of records, which are
+	// otherwise resolved through DNS
+	Lookup bool
+}
+
+// NewExec returns a provider running the command of opts
+func NewExec(opts ExecOptions) Provider {
+	return &execProvider{command: opts.Command, args: opts.Args, lookup: opts.Lookup}
+}
+
+// execRecord is the JSON document written to the command's stdin
+type execRecord struct {
+	Action  string `json:"action"`
+	Zone    string `json:"zone"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content,omitempty"`
+	TTL     int    `json:"ttl,omitempty"`
+	Proxied bool   `json:"proxied,omitempty"`
+}
+
+func (e *execProvider) run(ctx context.Context, r execRecord) (string, error) {
+	stdin, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	env := []string{
+		"DYN_ACTION=" + r.Action,
+		"DYN_ZONE=" + r.Zone,
+		"DYN_RECORD=" + r.Name,
+		"DYN_TYPE=" + r.Type,
+		"DYN_IP=" + r.Content,
+		"DYN_TTL=" + strconv.Itoa(r.TTL),
+		"DYN_PROXIED=" + strconv.FormatBool(r.Proxied),
+	}
+
+	out, err := command.Run(ctx, e.command, e.args, env, stdin)
+	if err != nil {
+		return "", fmt.Errorf("exec: %s %s: %v", r.Action, r.Name, err)
+	}
+	return out, nil
+}
+
+// GetRecord asks the command for the current address when lookup is enabled,
+// which prints it or nothing when the record doesn't exist. Otherwise the
+// record is resolved through DNS.
+func (e *execProvider) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	if !e.lookup {
+		return resolveRecord(ctx, zone, name, recordType)
+	}
+
+	out, err := e.run(ctx, execRecord{Action: "get", Zone: zone, Name: name, Type: recordType})
+	if err != nil {
+		return Record{}, err
+	}
+	if out == "" {
+		return Record{}, ErrRecordNotFound
+	}
+
+	return Record{
+		Zone:    zone,
+		Name:    name,
+		Type:    recordType,
+		Content: out,
+	}, nil
+}
+
+func (e *execProvider) CreateRecord(ctx context.Context, r Record) error {
+	_, err := e.run(ctx, execRecord{Action: "create", Zone: r.Zone, Name: r.Name, Type: r.Type, Content: r.Content, TTL: r.TTL, Proxied: r.Proxied})
+	return err
+}
+
+func (e *execProvider) UpdateRecord(ctx context.Context, r Record) error {
+	_, err := e.run(ctx, execRecord{Action: "update", Zone: r.Zone, Name: r.Name, Type: r.Type, Content: r.Content, TTL: r.TTL, Proxied: r.Proxied})
+	return err
+}
@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+const gandiEndpoint = "https://api.gandi.net/v5/livedns"
+
+// gandi manages records through the Gandi LiveDNS API, authenticated with a
+// personal access token
+type gandi struct {
+	token string
+}
+
+// NewGandi returns a provider managing Gandi LiveDNS domains, authenticated
+// with a personal access token
+func NewGandi(token string) Provider {
+	return &gandi{token: token}
+}
+
+type gandiRRset struct {
+	Name   string   `json:"rrset_name,omitempty"`
+	Type   string   `json:"rrset_type,omitempty"`
+	TTL    int      `json:"rrset_ttl,omitempty"`
+	Values []string `json:"rrset_values"`
+}
+
+func (g *gandi) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	var rrset gandiRRset
+	err := g.do(ctx, "GET", g.rrsetPath(zone, name, recordType), nil, &rrset)
+	if httpapi.IsStatus(err, http.StatusNotFound) {
+		return Record{}, ErrRecordNotFound
+	}
+	if err != nil {
+		return Record{}, fmt.Errorf("gandi: %v", err)
+	}
+	if len(rrset.Values) == 0 {
+		return Record{}, ErrRecordNotFound
+	}
+
+	return Record{
+		Zone:    zone,
+		Name:    name,
+		Type:    rrset.Type,
+		Content: rrset.Values[0],
+		TTL:     rrset.TTL,
+	}, nil
+}
+
+func (g *gandi) CreateRecord(ctx context.Context, r Record) error {
+	return g.write(ctx, "POST", r)
+}
+
+func (g *gandi) UpdateRecord(ctx context.Context, r Record) error {
+	return g.write(ctx, "PUT", r)
+}
+
+func (g *gandi) write(ctx context.Context, method string, r Record) error {
+	body := gandiRRset{
+		TTL:    r.TTL,
+		Values: []string{r.Content},
+	}
+
+	err := g.do(ctx, method, g.rrsetPath(r.Zone, r.Name, r.Type), body, nil)
+	if err != nil {
+		return fmt.Errorf("gandi: %v", err)
+	}
+
+	return nil
+}
+
+func (g *gandi) rrsetPath(zone, name, recordType string) string {
+	return fmt.Sprintf("/domains/%s/records/%s/%s", url.PathEscape(zone), url.PathEscape(RelativeName(name, zone)), recordType)
+}
+
+func (g *gandi) do(ctx context.Context, method, path string, body, out interface{}) error {
+	req, err := httpapi.NewJSONRequest(method, gandiEndpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+
+	return httpapi.JSON(ctx, req, out)
+}
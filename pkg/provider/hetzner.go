@@ -0,0 +1,145 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+const hetznerEndpoint = "https://dns.hetzner.com/api/v1"
+
+// hetzner manages records through the Hetzner DNS API
+type hetzner struct {
+	token string
+}
+
+// NewHetzner returns a provider managing Hetzner DNS zones, authenticated with
+// an API token
+func NewHetzner(token string) Provider {
+	return &hetzner{token: token}
+}
+
+type hetznerRecord struct {
+	ID     string `json:"id,omitempty"`
+	ZoneID string `json:"zone_id"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	TTL    int    `json:"ttl,omitempty"`
+}
+
+func (h *hetzner) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	recs, err := h.GetRecords(ctx, zone, name, recordType)
+	if err != nil {
+		return Record{}, err
+	}
+
+	if len(recs) == 0 {
+		return Record{}, ErrRecordNotFound
+	}
+
+	return recs[0], nil
+}
+
+func (h *hetzner) GetRecords(ctx context.Context, zone, name, recordType string) ([]Record, error) {
+	zoneID, err := h.zoneID(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Records []hetznerRecord `json:"records"`
+	}
+	err = h.do(ctx, "GET", "/records?zone_id="+url.QueryEscape(zoneID), nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Records are named relative to the zone
+	relative := RelativeName(name, zone)
+	var records []Record
+	for _, r := range resp.Records {
+		if r.Name == relative && r.Type == recordType {
+			records = append(records, Record{
+				ID:      r.ID,
+				ZoneID:  zoneID,
+				Zone:    zone,
+				Name:    name,
+				Type:    r.Type,
+				Content: r.Value,
+				TTL:     r.TTL,
+			})
+		}
+	}
+
+	return records, nil
+}
+
+func (h *hetzner) CreateRecord(ctx context.Context, r Record) error {
+	zoneID := r.ZoneID
+	if zoneID == "" {
+		id, err := h.zoneID(ctx, r.Zone)
+		if err != nil {
+			return err
+		}
+		zoneID = id
+	}
+
+	return h.do(ctx, "POST", "/records", h.record(r, zoneID), nil)
+}
+
+func (h *hetzner) UpdateRecord(ctx context.Context, r Record) error {
+	return h.do(ctx, "PUT", "/records/"+url.PathEscape(r.ID), h.record(r, r.ZoneID), nil)
+}
+
+func (h *hetzner) DeleteRecord(ctx context.Context, r Record) error {
+	return h.do(ctx, "DELETE", "/records/"+url.PathEscape(r.ID), nil, nil)
+}
+
+func (h *hetzner) record(r Record, zoneID string) hetznerRecord {
+	return hetznerRecord{
+		ZoneID: zoneID,
+		Type:   r.Type,
+		Name:   RelativeName(r.Name, r.Zone),
+		Value:  r.Content,
+		TTL:    r.TTL,
+	}
+}
+
+func (h *hetzner) zoneID(ctx context.Context, zone string) (string, error) {
+	var resp struct {
+		Zones []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"zones"`
+	}
+	err := h.do(ctx, "GET", "/zones?name="+url.QueryEscape(zone), nil, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	for _, z := range resp.Zones {
+		if z.Name == zone {
+			return z.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("hetzner: zone %s not found", zone)
+}
+
+func (h *hetzner) do(ctx context.Context, method, path string, body, out interface{}) error {
+	req, err := httpapi.NewJSONRequest(method, hetznerEndpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Auth-API-Token", h.token)
+
+	err = httpapi.JSON(ctx, req, out)
+	if err != nil {
+		return fmt.Errorf("hetzner: %v", err)
+	}
+
+	return nil
+}
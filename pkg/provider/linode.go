@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+const linodeEndpoint = "https://api.linode.com/v4"
+
+// linode manages records through the Linode (Akamai) Domains API
+type linode struct {
+	token string
+}
+
+// NewLinode returns a provider managing Linode domains, authenticated with a
+// personal access token
+func NewLinode(token string) Provider {
+	return &linode{token: token}
+}
+
+type linodeRecord struct {
+	ID     int    `json:"id,omitempty"`
+	Type   string `json:"type,omitempty"`
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	TTL    int    `json:"ttl_sec,omitempty"`
+}
+
+func (l *linode) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	domainID, err := l.domainID(ctx, zone)
+	if err != nil {
+		return Record{}, err
+	}
+
+	relative := SubName(name, zone)
+	for page, pages := 1, 1; page <= pages; page++ {
+		var resp struct {
+			Data  []linodeRecord `json:"data"`
+			Pages int            `json:"pages"`
+		}
+		err = l.do(ctx, "GET", fmt.Sprintf("/domains/%d/records?page=%d&page_size=500", domainID, page), nil, nil, &resp)
+		if err != nil {
+			return Record{}, err
+		}
+		pages = resp.Pages
+
+		for _, r := range resp.Data {
+			if r.Name == relative && r.Type == recordType {
+				return Record{
+					ID:      strconv.Itoa(r.ID),
+					ZoneID:  strconv.Itoa(domainID),
+					Zone:    zone,
+					Name:    name,
+					Type:    r.Type,
+					Content: r.Target,
+					TTL:     r.TTL,
+				}, nil
+			}
+		}
+	}
+
+	return Record{}, ErrRecordNotFound
+}
+
+func (l *linode) CreateRecord(ctx context.Context, r Record) error {
+	domainID, err := l.domainID(ctx, r.Zone)
+	if err != nil {
+		return err
+	}
+
+	body := linodeRecord{
+		Type:   r.Type,
+		Name:   SubName(r.Name, r.Zone),
+		Target: r.Content,
+		TTL:    r.TTL,
+	}
+	return l.do(ctx, "POST", fmt.Sprintf("/domains/%d/records", domainID), nil, body, nil)
+}
+
+func (l *linode) UpdateRecord(ctx context.Context, r Record) error {
+	body := linodeRecord{
+		Name:   SubName(r.Name, r.Zone),
+		Target: r.Content,
+		TTL:    r.TTL,
+	}
+	return l.do(ctx, "PUT", fmt.Sprintf("/domains/%s/records/%s", r.ZoneID, r.ID), nil, body, nil)
+}
+
+func (l *linode) domainID(ctx context.Context, zone string) (int, error) {
+	filter, err := json.Marshal(map[string]string{"domain": zone})
+	if err != nil {
+		return 0, err
+	}
+
+	var resp struct {
+		Data []struct {
+			ID     int    `json:"id"`
+			Domain string `json:"domain"`
+		} `json:"data"`
+	}
+	err = l.do(ctx, "GET", "/domains", map[string]string{"X-Filter": string(filter)}, nil, &resp)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, d := range resp.Data {
+		if d.Domain == zone {
+			return d.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("linode: domain %s not found", zone)
+}
+
+func (l *linode) do(ctx context.Context, method, path string, headers map[string]string, body, out interface{}) error {
+	req, err := httpapi.NewJSONRequest(method, linodeEndpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+l.token)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	err = httpapi.JSON(ctx, req, out)
+	if err != nil {
+		return fmt.Errorf("linode: %v", err)
+	}
+
+	return nil
+}
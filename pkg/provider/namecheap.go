@@ -0,0 +1,83 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+// namecheap updates Namecheap hosts through the dynamic DNS update endpoint,
+// authenticated with the per-domain dynamic DNS password
+type namecheap struct {
+	password string
+}
+
+// NewNamecheap returns a provider managing Namecheap hosts, authenticated with
+// the dynamic DNS password of the domain
+func NewNamecheap(password string) Provider {
+	return &namecheap{password: password}
+}
+
+type namecheapResponse struct {
+	ErrCount int `xml:"ErrCount"`
+	Errors   struct {
+		Messages []string `xml:",any"`
+	} `xml:"errors"`
+}
+
+func (n *namecheap) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	return resolveRecord(ctx, zone, name, recordType)
+}
+
+func (n *namecheap) CreateRecord(ctx context.Context, r Record) error {
+	return n.UpdateRecord(ctx, r)
+}
+
+func (n *namecheap) UpdateRecord(ctx context.Context, r Record) error {
+	if r.Type != "A" {
+		return fmt.Errorf("namecheap: dynamic DNS only supports A records")
+	}
+
+	q := url.Values{}
+	q.Set("host", RelativeName(r.Name, r.Zone))
+	q.Set("domain", r.Zone)
+	q.Set("password", n.password)
+	q.Set("ip", r.Content)
+
+	req, err := http.NewRequest("GET", "https://dynamicdns.park-your-domain.com/update?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	// Errors leave out the URL, which holds the password
+	body, err := httpapi.Do(ctx, req)
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	if err != nil {
+		return fmt.Errorf("namecheap: %v", err)
+	}
+
+	// The response declares a UTF-16 encoding but is sent as UTF-8
+	var resp namecheapResponse
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	err = dec.Decode(&resp)
+	if err != nil {
+		return fmt.Errorf("namecheap: invalid response: %v", err)
+	}
+	if resp.ErrCount > 0 {
+		return fmt.Errorf("namecheap: %s", strings.Join(resp.Errors.Messages, "; "))
+	}
+
+	return nil
+}
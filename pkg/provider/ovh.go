@@ -0,0 +1,189 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+var ovhEndpoints = map[string]string{
+	"ovh-eu":        "https://eu.api.ovh.com/1.0",
+	"ovh-ca":        "https://ca.api.ovh.com/1.0",
+	"ovh-us":        "https://api.us.ovhcloud.com/1.0",
+	"kimsufi-eu":    "https://eu.api.kimsufi.com/1.0",
+	"soyoustart-eu": "https://eu.api.soyoustart.com/1.0",
+}
+
+// ovh manages records through the OVH API, signing every request with the
+// application secret and consumer key
+type ovh struct {
+	endpoint          string
+	applicationKey    string
+	applicationSecret string
+	consumerKey       string
+
+	once       sync.Once
+	timeOffset time.Duration // difference between the API and local clocks
+}
+
+// OVHOptions configures a provider managing OVH domains
+type OVHOptions struct {
+	// Endpoint is a well known endpoint name such as ovh-eu, the default,
+	// or the URL of the API
+	Endpoint string
+
+	ApplicationKey    string
+	ApplicationSecret string
+	ConsumerKey       string
+}
+
+// NewOVH returns a provider managing OVH domains with the application and
+// consumer keys of opts
+func NewOVH(opts OVHOptions) Provider {
+	o := &ovh{
+		endpoint:          opts.Endpoint,
+		applicationKey:    opts.ApplicationKey,
+		applicationSecret: opts.ApplicationSecret,
+		consumerKey:       opts.ConsumerKey,
+	}
+
+	// Accept either a well known endpoint name or a full URL
+	if o.endpoint == "" {
+		o.endpoint = "ovh-eu"
+	}
+	if e, ok := ovhEndpoints[o.endpoint]; ok {
+		o.endpoint = e
+	}
+
+	return o
+}
+
+type ovhRecord struct {
+	ID        int    `json:"id,omitempty"`
+	FieldType string `json:"fieldType,omitempty"`
+	SubDomain string `json:"subDomain"`
+	Target    string `json:"target"`
+	TTL       int    `json:"ttl,omitempty"`
+}
+
+func (o *ovh) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	q := url.Values{}
+	q.Set("fieldType", recordType)
+	q.Set("subDomain", SubName(name, zone))
+
+	var ids []int
+	err := o.do(ctx, "GET", "/domain/zone/"+url.PathEscape(zone)+"/record?"+q.Encode(), nil, &ids)
+	if err != nil {
+		return Record{}, err
+	}
+	if len(ids) == 0 {
+		return Record{}, ErrRecordNotFound
+	}
+
+	var r ovhRecord
+	err = o.do(ctx, "GET", fmt.Sprintf("/domain/zone/%s/record/%d", url.PathEscape(zone), ids[0]), nil, &r)
+	if err != nil {
+		return Record{}, err
+	}
+
+	return Record{
+		ID:      strconv.Itoa(r.ID),
+		Zone:    zone,
+		Name:    name,
+		Type:    r.FieldType,
+		Content: r.Target,
+		TTL:     r.TTL,
+	}, nil
+}
+
+func (o *ovh) CreateRecord(ctx context.Context, r Record) error {
+	body := ovhRecord{
+		FieldType: r.Type,
+		SubDomain: SubName(r.Name, r.Zone),
+		Target:    r.Content,
+		TTL:       r.TTL,
+	}
+
+	err := o.do(ctx, "POST", "/domain/zone/"+url.PathEscape(r.Zone)+"/record", body, nil)
+	if err != nil {
+		return err
+	}
+
+	return o.refresh(ctx, r.Zone)
+}
+
+func (o *ovh) UpdateRecord(ctx context.Context, r Record) error {
+	body := ovhRecord{
+		SubDomain: SubName(r.Name, r.Zone),
+		Target:    r.Content,
+		TTL:       r.TTL,
+	}
+
+	err := o.do(ctx, "PUT", "/domain/zone/"+url.PathEscape(r.Zone)+"/record/"+url.PathEscape(r.ID), body, nil)
+	if err != nil {
+		return err
+	}
+
+	return o.refresh(ctx, r.Zone)
+}
+
+// refresh applies pending record changes to the zone
+func (o *ovh) refresh(ctx context.Context, zone string) error {
+	return o.do(ctx, "POST", "/domain/zone/"+url.PathEscape(zone)+"/refresh", nil, nil)
+}
+
+func (o *ovh) do(ctx context.Context, method, path string, body, out interface{}) error {
+	// Requests are signed with a timestamp which must be close to the API's
+	// own clock, so measure the offset once
+	o.once.Do(func() {
+		req, err := http.NewRequest("GET", o.endpoint+"/auth/time", nil)
+		if err != nil {
+			return
+		}
+		var serverTime int64
+		if httpapi.JSON(ctx, req, &serverTime) == nil {
+			o.timeOffset = time.Unix(serverTime, 0).Sub(time.Now())
+		}
+	})
+
+	var payload []byte
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = b
+	}
+
+	endpoint := o.endpoint + path
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Add(o.timeOffset).Unix(), 10)
+	signature := sha1.Sum([]byte(o.applicationSecret + "+" + o.consumerKey + "+" + method + "+" + endpoint + "+" + string(payload) + "+" + timestamp))
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ovh-Application", o.applicationKey)
+	req.Header.Set("X-Ovh-Consumer", o.consumerKey)
+	req.Header.Set("X-Ovh-Timestamp", timestamp)
+	req.Header.Set("X-Ovh-Signature", "$1$"+hex.EncodeToString(signature[:]))
+
+	err = httpapi.JSON(ctx, req, out)
+	if err != nil {
+		return fmt.Errorf("ovh: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+const porkbunEndpoint = "https://api.porkbun.com/api/json/v3"
+
+// porkbun manages records through the Porkbun API, which authenticates every
+// request with keys in the JSON body
+type porkbun struct {
+	apiKey       string
+	secretAPIKey string
+}
+
+// NewPorkbun returns a provider managing Porkbun domains, authenticated with
+// an API key and its secret
+func NewPorkbun(apiKey, secretAPIKey string) Provider {
+	return &porkbun{apiKey: apiKey, secretAPIKey: secretAPIKey}
+}
+
+type porkbunRequest struct {
+	APIKey       string `json:"apikey"`
+	SecretAPIKey string `json:"secretapikey"`
+	Name         string `json:"name,omitempty"`
+	Type         string `json:"type,omitempty"`
+	Content      string `json:"content,omitempty"`
+	TTL          string `json:"ttl,omitempty"`
+}
+
+type porkbunResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Records []struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Type    string `json:"type"`
+		Content string `json:"content"`
+		TTL     string `json:"ttl"`
+	} `json:"records"`
+}
+
+func (p *porkbun) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	var resp porkbunResponse
+	path := fmt.Sprintf("/dns/retrieveByNameType/%s/%s/%s", url.PathEscape(zone), recordType, url.PathEscape(SubName(name, zone)))
+	err := p.do(ctx, path, porkbunRequest{}, &resp)
+	if err != nil {
+		return Record{}, err
+	}
+	if len(resp.Records) == 0 {
+		return Record{}, ErrRecordNotFound
+	}
+
+	r := resp.Records[0]
+	ttl, _ := strconv.Atoi(r.TTL)
+	return Record{
+		ID:      r.ID,
+		Zone:    zone,
+		Name:    name,
+		Type:    r.Type,
+		Content: r.Content,
+		TTL:     ttl,
+	}, nil
+}
+
+func (p *porkbun) CreateRecord(ctx context.Context, r Record) error {
+	return p.do(ctx, "/dns/create/"+url.PathEscape(r.Zone), p.request(r), nil)
+}
+
+func (p *porkbun) UpdateRecord(ctx context.Context, r Record) error {
+	return p.do(ctx, "/dns/edit/"+url.PathEscape(r.Zone)+"/"+url.PathEscape(r.ID), p.request(r), nil)
+}
+
+func (p *porkbun) request(r Record) porkbunRequest {
+	req := porkbunRequest{
+		Name:    SubName(r.Name, r.Zone),
+		Type:    r.Type,
+		Content: r.Content,
+	}
+	if r.TTL > 0 {
+		req.TTL = strconv.Itoa(r.TTL)
+	}
+	return req
+}
+
+func (p *porkbun) do(ctx context.Context, path string, body porkbunRequest, out *porkbunResponse) error {
+	body.APIKey = p.apiKey
+	body.SecretAPIKey = p.secretAPIKey
+
+	req, err := httpapi.NewJSONRequest("POST", porkbunEndpoint+path, body)
+	if err != nil {
+		return err
+	}
+
+	var resp porkbunResponse
+	err = httpapi.JSON(ctx, req, &resp)
+	if err != nil {
+		return fmt.Errorf("porkbun: %v", err)
+	}
+	if resp.Status != "SUCCESS" {
+		return fmt.Errorf("porkbun: %s", resp.Message)
+	}
+
+	if out != nil {
+		*out = resp
+	}
+	return nil
+}
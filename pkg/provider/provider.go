@@ -0,0 +1,151 @@
+// Package provider defines the interface implemented by DNS providers, so
+// other programs can manage dynamic records with dyn's providers or plug in
+// their own
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Record is a provider-agnostic representation of a DNS address record
+type Record struct {
+	ID      string // provider-specific record identifier
+	ZoneID  string // provider-specific zone identifier
+	Zone    string
+	Name    string // fully qualified record name
+	Type    string
+	Content string
+	TTL     int
+	Proxied bool
+}
+
+// Provider is implemented by DNS providers able to manage address records
+type Provider interface {
+	// GetRecord returns the record with the given fully qualified name and
+	// type, or ErrRecordNotFound if no such record exists
+	GetRecord(ctx context.Context, zone, name, recordType string) (Record, error)
+
+	CreateRecord(ctx context.Context, r Record) error
+	UpdateRecord(ctx context.Context, r Record) error
+}
+
+// Lister is implemented by providers able to return every record with a
+// name and type, for names with several addresses
+type Lister interface {
+	// GetRecords is like GetRecord, but returns every matching record
+	GetRecords(ctx context.Context, zone, name, recordType string) ([]Record, error)
+}
+
+// Deleter is implemented by providers able to delete records
+type Deleter interface {
+	DeleteRecord(ctx context.Context, r Record) error
+}
+
+// Verifier is implemented by providers able to check up front that their
+// credentials are valid and allowed to manage the records of a zone
+type Verifier interface {
+	// Verify returns a *CredentialError when the credentials are rejected or
+	// lack permissions, or any other error when they couldn't be checked
+	Verify(ctx context.Context, zone string) error
+}
+
+// TTLLimiter is implemented by providers which raise TTLs below a minimum.
+// The minimum is the TTL such records are synced with, so they aren't
+// rewritten for a TTL they can't have.
+type TTLLimiter interface {
+	MinTTL() int
+}
+
+// RateLimitError is returned by providers when the API asks them to back
+// off before the next update
+type RateLimitError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: rate limited, retrying after %s", e.Provider, e.RetryAfter)
+}
+
+// CredentialError is returned by Verify for credentials which can't manage
+// the records of a zone
+type CredentialError struct {
+	Zone   string
+	Reason string
+}
+
+func (e *CredentialError) Error() string {
+	return fmt.Sprintf("credentials can't manage zone %s: %s", e.Zone, e.Reason)
+}
+
+// ErrRecordNotFound is returned by GetRecord when the record doesn't exist
+var ErrRecordNotFound = errors.New("record not found")
+
+// RelativeName returns a fully qualified record name relative to its zone,
+// with "@" standing for the zone apex
+func RelativeName(name, zone string) string {
+	name = strings.TrimSuffix(name, ".")
+	zone = strings.TrimSuffix(zone, ".")
+	if name == zone {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+zone)
+}
+
+// SubName is like RelativeName, but returns an empty name for the zone apex
+// as expected by several provider APIs
+func SubName(name, zone string) string {
+	relative := RelativeName(name, zone)
+	if relative == "@" {
+		return ""
+	}
+	return relative
+}
+
+// RecordNetwork maps a DNS record type to the IP network it holds
+func RecordNetwork(recordType string) string {
+	if recordType == "AAAA" {
+		return "ip6"
+	}
+	return "ip4"
+}
+
+// Unwrap returns the provider wrapped by p, such as a rate limiter, when it
+// has an Unwrap method, or p itself, so optional interfaces like Lister can be
+// checked on the underlying provider
+func Unwrap(p Provider) Provider {
+	for {
+		w, ok := p.(interface{ Unwrap() Provider })
+		if !ok {
+			return p
+		}
+		p = w.Unwrap()
+	}
+}
+
+// resolveRecord looks up the current address of a record through DNS, for
+// providers whose APIs only accept updates and cannot be queried
+func resolveRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, RecordNetwork(recordType), name)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return Record{}, ErrRecordNotFound
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	if len(ips) == 0 {
+		return Record{}, ErrRecordNotFound
+	}
+
+	return Record{
+		Zone:    zone,
+		Name:    name,
+		Type:    recordType,
+		Content: ips[0].String(),
+	}, nil
+}
@@ -0,0 +1,311 @@
+package provider
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	dnsTypeA    = 1
+	dnsTypeSOA  = 6
+	dnsTypeAAAA = 28
+	dnsTypeTSIG = 250
+
+	dnsClassIN  = 1
+	dnsClassANY = 255
+
+	dnsOpcodeUpdate = 5
+)
+
+var dnsRcodes = map[int]string{
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+}
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-md5.sig-alg.reg.int": md5.New,
+	"hmac-sha1":                sha1.New,
+	"hmac-sha256":              sha256.New,
+	"hmac-sha512":              sha512.New,
+}
+
+// rfc2136 updates records on an authoritative name server using DNS UPDATE
+// messages (RFC 2136), optionally signed with a TSIG key (RFC 8945)
+type rfc2136 struct {
+	server    string
+	keyName   string
+	keySecret []byte
+	algorithm string
+}
+
+// RFC2136Options configures a provider updating records on an authoritative
+// name server
+type RFC2136Options struct {
+	Server string // host and optional port, 53 by default
+
+	// The TSIG key signing updates, which are unsigned without a name
+	KeyName      string
+	KeySecret    []byte
+	KeyAlgorithm string // defaults to hmac-sha256
+}
+
+// NewRFC2136 returns a provider sending DNS UPDATE messages to the server of
+// opts
+func NewRFC2136(opts RFC2136Options) (Provider, error) {
+	if opts.Server == "" {
+		return nil, fmt.Errorf("rfc2136: missing server")
+	}
+	server := opts.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	p := &rfc2136{
+		server:    server,
+		keyName:   opts.KeyName,
+		algorithm: strings.TrimSuffix(strings.ToLower(opts.KeyAlgorithm), "."),
+	}
+
+	if p.keyName != "" {
+		p.keySecret = opts.KeySecret
+
+		if p.algorithm == "" {
+			p.algorithm = "hmac-sha256"
+		}
+		if _, ok := tsigAlgorithms[p.algorithm]; !ok {
+			return nil, fmt.Errorf("rfc2136: unsupported TSIG algorithm '%s'", p.algorithm)
+		}
+	}
+
+	return p, nil
+}
+
+func (p *rfc2136) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	// Query the server directly so the answer isn't stale from a cache
+	r := net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := net.Dialer{}
+			return d.DialContext(ctx, network, p.server)
+		},
+	}
+
+	ips, err := r.LookupIP(ctx, RecordNetwork(recordType), name)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return Record{}, ErrRecordNotFound
+	}
+	if err != nil {
+		return Record{}, fmt.Errorf("rfc2136: %v", err)
+	}
+	if len(ips) == 0 {
+		return Record{}, ErrRecordNotFound
+	}
+
+	return Record{
+		Zone:    zone,
+		Name:    name,
+		Type:    recordType,
+		Content: ips[0].String(),
+	}, nil
+}
+
+func (p *rfc2136) CreateRecord(ctx context.Context, r Record) error {
+	return p.replace(ctx, r)
+}
+
+func (p *rfc2136) UpdateRecord(ctx context.Context, r Record) error {
+	return p.replace(ctx, r)
+}
+
+// replace sends an update deleting the existing RRset of the record and
+// adding the new address in its place
+func (p *rfc2136) replace(ctx context.Context, r Record) error {
+	ip := net.ParseIP(r.Content)
+	if ip == nil {
+		return fmt.Errorf("rfc2136: invalid address '%s'", r.Content)
+	}
+
+	var rtype uint16
+	var rdata []byte
+	switch r.Type {
+	case "A":
+		rtype, rdata = dnsTypeA, ip.To4()
+	case "AAAA":
+		rtype, rdata = dnsTypeAAAA, ip.To16()
+	default:
+		return fmt.Errorf("rfc2136: unsupported record type %s", r.Type)
+	}
+	if rdata == nil {
+		return fmt.Errorf("rfc2136: address '%s' is not valid for an %s record", r.Content, r.Type)
+	}
+
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = 300
+	}
+
+	var id [2]byte
+	_, err := rand.Read(id[:])
+	if err != nil {
+		return err
+	}
+
+	// Header: ID, opcode UPDATE, one zone, no prerequisites, two updates
+	msg := append([]byte{}, id[:]...)
+	msg = append(msg, dnsOpcodeUpdate<<3, 0)
+	msg = appendUint16(msg, 1, 0, 2, 0)
+
+	// Zone section
+	msg = appendDNSName(msg, r.Zone)
+	msg = appendUint16(msg, dnsTypeSOA, dnsClassIN)
+
+	// Update section: delete the RRset, then add the new record
+	msg = appendDNSName(msg, r.Name)
+	msg = appendUint16(msg, rtype, dnsClassANY)
+	msg = appendUint32(msg, 0)
+	msg = appendUint16(msg, 0)
+
+	msg = appendDNSName(msg, r.Name)
+	msg = appendUint16(msg, rtype, dnsClassIN)
+	msg = appendUint32(msg, uint32(ttl))
+	msg = appendUint16(msg, uint16(len(rdata)))
+	msg = append(msg, rdata...)
+
+	if p.keyName != "" {
+		msg = p.sign(msg, time.Now())
+	}
+
+	resp, err := p.exchange(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("rfc2136: %v", err)
+	}
+
+	if len(resp) < 12 || resp[0] != id[0] || resp[1] != id[1] {
+		return fmt.Errorf("rfc2136: malformed response")
+	}
+	if rcode := int(resp[3] & 0x0f); rcode != 0 {
+		name, ok := dnsRcodes[rcode]
+		if !ok {
+			name = fmt.Sprintf("RCODE%d", rcode)
+		}
+		return fmt.Errorf("rfc2136: update rejected with %s", name)
+	}
+
+	return nil
+}
+
+// sign appends a TSIG record to msg
+func (p *rfc2136) sign(msg []byte, now time.Time) []byte {
+	keyName := strings.ToLower(p.keyName)
+	algorithm := p.algorithm
+	signed := uint64(now.Unix())
+	const fudge = 300
+
+	// The MAC covers the message followed by the TSIG variables
+	mac := hmac.New(tsigAlgorithms[algorithm], p.keySecret)
+	mac.Write(msg)
+
+	var vars []byte
+	vars = appendDNSName(vars, keyName)
+	vars = appendUint16(vars, dnsClassANY)
+	vars = appendUint32(vars, 0)
+	vars = appendDNSName(vars, algorithm)
+	vars = appendUint16(vars, uint16(signed>>32))
+	vars = appendUint32(vars, uint32(signed))
+	vars = appendUint16(vars, fudge, 0, 0)
+	mac.Write(vars)
+	sum := mac.Sum(nil)
+
+	var rdata []byte
+	rdata = appendDNSName(rdata, algorithm)
+	rdata = appendUint16(rdata, uint16(signed>>32))
+	rdata = appendUint32(rdata, uint32(signed))
+	rdata = appendUint16(rdata, fudge, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, msg[0], msg[1]) // original ID
+	rdata = appendUint16(rdata, 0, 0)
+
+	// Increment the additional record count
+	arcount := binary.BigEndian.Uint16(msg[10:12])
+	binary.BigEndian.PutUint16(msg[10:12], arcount+1)
+
+	msg = appendDNSName(msg, keyName)
+	msg = appendUint16(msg, dnsTypeTSIG, dnsClassANY)
+	msg = appendUint32(msg, 0)
+	msg = appendUint16(msg, uint16(len(rdata)))
+	return append(msg, rdata...)
+}
+
+// exchange sends msg to the server over TCP and returns the response
+func (p *rfc2136) exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "tcp", p.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	_, err = conn.Write(append(appendUint16(nil, uint16(len(msg))), msg...))
+	if err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	_, err = io.ReadFull(conn, length[:])
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err = io.ReadFull(conn, resp)
+	return resp, err
+}
+
+// appendDNSName appends name in uncompressed wire format
+func appendDNSName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func appendUint16(b []byte, vs ...uint16) []byte {
+	for _, v := range vs {
+		b = append(b, byte(v>>8), byte(v))
+	}
+	return b
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
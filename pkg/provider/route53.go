@@ -0,0 +1,220 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ianmuscat/dyn/internal/awsauth"
+)
+
+const route53Endpoint = "https://route53.amazonaws.com/2013-04-01"
+
+type route53 struct {
+	hostedZoneID string
+	creds        *awsauth.Chain
+}
+
+// Route53Options configures a provider managing a Route 53 hosted zone. AWS
+// credentials left empty are resolved like the AWS SDKs do, from the
+// environment, shared credentials file or instance metadata.
+type Route53Options struct {
+	HostedZoneID string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// NewRoute53 returns a provider managing the hosted zone of opts
+func NewRoute53(opts Route53Options) Provider {
+	return &route53{
+		hostedZoneID: strings.TrimPrefix(opts.HostedZoneID, "/hostedzone/"),
+		creds: awsauth.NewChain(awsauth.Credentials{
+			AccessKeyID:     opts.AccessKeyID,
+			SecretAccessKey: opts.SecretAccessKey,
+			SessionToken:    opts.SessionToken,
+		}),
+	}
+}
+
+type route53RecordSet struct {
+	Name            string   `xml:"Name"`
+	Type            string   `xml:"Type"`
+	TTL             int      `xml:"TTL,omitempty"`
+	ResourceRecords []string `xml:"ResourceRecords>ResourceRecord>Value"`
+}
+
+type route53ListResponse struct {
+	RecordSets []route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+}
+
+type route53Change struct {
+	Action    string           `xml:"Action"`
+	RecordSet route53RecordSet `xml:"ResourceRecordSet"`
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Comment string          `xml:"ChangeBatch>Comment"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53Error struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+func (r *route53) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	rs, err := r.recordSet(ctx, name, recordType)
+	if err != nil {
+		return Record{}, err
+	}
+
+	rec := Record{
+		ID:     rs.Name,
+		ZoneID: r.hostedZoneID,
+		Zone:   zone,
+		Name:   name,
+		Type:   rs.Type,
+		TTL:    rs.TTL,
+	}
+	if len(rs.ResourceRecords) > 0 {
+		rec.Content = rs.ResourceRecords[0]
+	}
+
+	return rec, nil
+}
+
+// recordSet returns the record set with the given name and type, or
+// ErrRecordNotFound if no such set exists
+func (r *route53) recordSet(ctx context.Context, name, recordType string) (route53RecordSet, error) {
+	q := url.Values{}
+	q.Set("name", name)
+	q.Set("type", recordType)
+	q.Set("maxitems", "1")
+
+	var resp route53ListResponse
+	err := r.do(ctx, "GET", "/hostedzone/"+r.hostedZoneID+"/rrset?"+q.Encode(), nil, &resp)
+	if err != nil {
+		return route53RecordSet{}, err
+	}
+
+	// Record sets are listed starting from the requested name, so the first
+	// result may belong to a different name
+	if len(resp.RecordSets) == 0 {
+		return route53RecordSet{}, ErrRecordNotFound
+	}
+	rs := resp.RecordSets[0]
+	if strings.TrimSuffix(rs.Name, ".") != strings.TrimSuffix(name, ".") || rs.Type != recordType {
+		return route53RecordSet{}, ErrRecordNotFound
+	}
+
+	return rs, nil
+}
+
+func (r *route53) CreateRecord(ctx context.Context, rec Record) error {
+	return r.upsert(ctx, rec, false)
+}
+
+func (r *route53) UpdateRecord(ctx context.Context, rec Record) error {
+	return r.upsert(ctx, rec, true)
+}
+
+// upsert writes the value of rec into its record set. An UPSERT replaces the
+// whole set, so the other values of sets with several are kept: an update
+// replaces the value reported by GetRecord, while a creation adds one.
+func (r *route53) upsert(ctx context.Context, rec Record, update bool) error {
+	rs, err := r.recordSet(ctx, rec.Name, rec.Type)
+	if err != nil && err != ErrRecordNotFound {
+		return err
+	}
+
+	values := []string{rec.Content}
+	if len(rs.ResourceRecords) > 0 {
+		others := rs.ResourceRecords
+		if update {
+			others = others[1:]
+		}
+		for _, v := range others {
+			if v != rec.Content {
+				values = append(values, v)
+			}
+		}
+	}
+
+	// Keep the TTL of the set, unless one is configured
+	ttl := rec.TTL
+	if ttl == 0 {
+		ttl = rs.TTL
+	}
+	if ttl == 0 {
+		ttl = 300
+	}
+
+	req := route53ChangeRequest{
+		Comment: "Updated by dyn",
+		Changes: []route53Change{{
+			Action: "UPSERT",
+			RecordSet: route53RecordSet{
+				Name:            rec.Name,
+				Type:            rec.Type,
+				TTL:             ttl,
+				ResourceRecords: values,
+			},
+		}},
+	}
+
+	body, err := xml.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	return r.do(ctx, "POST", "/hostedzone/"+r.hostedZoneID+"/rrset", append([]byte(xml.Header), body...), nil)
+}
+
+func (r *route53) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	creds, err := r.creds.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, route53Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	awsauth.SignV4(req, body, creds, "us-east-1", "route53", time.Now())
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("route53: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("route53: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e route53Error
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			return fmt.Errorf("route53: %s: %s", e.Code, e.Message)
+		}
+		return fmt.Errorf("route53: unexpected status %s", resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, out)
+}
@@ -0,0 +1,111 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/ianmuscat/dyn/internal/httpapi"
+)
+
+const vultrEndpoint = "https://api.vultr.com/v2"
+
+// vultr manages records through the Vultr DNS API
+type vultr struct {
+	token string
+}
+
+// NewVultr returns a provider managing Vultr DNS domains, authenticated with
+// an API key
+func NewVultr(token string) Provider {
+	return &vultr{token: token}
+}
+
+type vultrRecord struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type,omitempty"`
+	Name string `json:"name"`
+	Data string `json:"data"`
+	TTL  int    `json:"ttl,omitempty"`
+}
+
+func (v *vultr) GetRecord(ctx context.Context, zone, name, recordType string) (Record, error) {
+	relative := SubName(name, zone)
+
+	// Records are paginated with a cursor
+	cursor := ""
+	for {
+		q := url.Values{}
+		q.Set("per_page", "500")
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+
+		var resp struct {
+			Records []vultrRecord `json:"records"`
+			Meta    struct {
+				Links struct {
+					Next string `json:"next"`
+				} `json:"links"`
+			} `json:"meta"`
+		}
+		err := v.do(ctx, "GET", "/domains/"+url.PathEscape(zone)+"/records?"+q.Encode(), nil, &resp)
+		if err != nil {
+			return Record{}, err
+		}
+
+		for _, r := range resp.Records {
+			if r.Name == relative && r.Type == recordType {
+				return Record{
+					ID:      r.ID,
+					Zone:    zone,
+					Name:    name,
+					Type:    r.Type,
+					Content: r.Data,
+					TTL:     r.TTL,
+				}, nil
+			}
+		}
+
+		cursor = resp.Meta.Links.Next
+		if cursor == "" {
+			return Record{}, ErrRecordNotFound
+		}
+	}
+}
+
+func (v *vultr) CreateRecord(ctx context.Context, r Record) error {
+	body := vultrRecord{
+		Type: r.Type,
+		Name: SubName(r.Name, r.Zone),
+		Data: r.Content,
+		TTL:  r.TTL,
+	}
+
+	return v.do(ctx, "POST", "/domains/"+url.PathEscape(r.Zone)+"/records", body, nil)
+}
+
+func (v *vultr) UpdateRecord(ctx context.Context, r Record) error {
+	body := vultrRecord{
+		Name: SubName(r.Name, r.Zone),
+		Data: r.Content,
+		TTL:  r.TTL,
+	}
+
+	return v.do(ctx, "PATCH", "/domains/"+url.PathEscape(r.Zone)+"/records/"+url.PathEscape(r.ID), body, nil)
+}
+
+func (v *vultr) do(ctx context.Context, method, path string, body, out interface{}) error {
+	req, err := httpapi.NewJSONRequest(method, vultrEndpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+v.token)
+
+	err = httpapi.JSON(ctx, req, out)
+	if err != nil {
+		return fmt.Errorf("vultr: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DetectionError is returned by a sync when the public IP couldn't be
+// detected
+type DetectionError struct {
+	Err error
+}
+
+func (e *DetectionError) Error() string {
+	return e.Err.Error()
+}
+
+// SyncError is returned by a sync when records failed to sync
+type SyncError struct {
+	Failed int
+	Total  int
+}
+
+func (e *SyncError) Error() string {
+	return fmt.Sprintf("%d of %d records failed to sync", e.Failed, e.Total)
+}
+
+// CycleError returns the outcome of a sync: a *DetectionError when the
+// public IP of a type couldn't be detected, mentioning records which failed
+// to sync as well, otherwise a *SyncError when some did
+func CycleError(detectErrs []string, failed, total int) error {
+	if len(detectErrs) > 0 {
+		if failed > 0 {
+			detectErrs = append(detectErrs, (&SyncError{Failed: failed, Total: total}).Error())
+		}
+		return &DetectionError{Err: errors.New(strings.Join(detectErrs, "; "))}
+	}
+	if failed > 0 {
+		return &SyncError{Failed: failed, Total: total}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+// Package sync points DNS records managed by the providers of pkg/provider at
+// an address, such as the public IP detected by pkg/detect
+package sync
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+	log "github.com/sirupsen/logrus"
+)
+
+// Hooks customize the updates of a target, and may be left empty
+type Hooks struct {
+	// Defer reports whether an update of the record has to wait, e.g. for a
+	// rate limit. A deferred update leaves the record as it is, without
+	// running the other hooks.
+	Defer func(r provider.Record) bool
+
+	// BeforeUpdate runs before the record is pointed at a new address, and
+	// leaves it as it is when failing
+	BeforeUpdate func(ctx context.Context, t *Target) error
+
+	// AfterUpdate runs once the record has been pointed at a new address
+	AfterUpdate func(ctx context.Context, t *Target)
+}
+
+// Target is a record to keep pointed at an address
+type Target struct {
+	Provider provider.Provider
+	Zone     string
+	Name     string // relative to the zone, "@" or empty for the apex
+	Type     string
+	IP       net.IP // the address the record should point at
+	DryRun   bool
+	TTL      int   // desired TTL, 0 for the provider default
+	Proxied  *bool // desired proxy status, nil to leave it as is
+
+	// Creates the record when it's missing
+	CreateMissing bool
+
+	Hooks
+
+	// Set by Lookup
+	Record   provider.Record
+	RemoteIP net.IP
+
+	// Other records with the same name and type, for providers which can
+	// list them
+	Duplicates []provider.Record
+
+	Changed bool // set once the record has been pointed at a new IP
+
+	// Set when Hooks.Defer held the update back, leaving the record as it is
+	Deferred bool
+}
+
+// FQDN returns the fully qualified name of the record, treating "@" as the
+// zone apex
+func (d *Target) FQDN() string {
+	if d.Name == "@" || d.Name == "" {
+		return d.Zone
+	}
+	return fmt.Sprintf("%s.%s", d.Name, d.Zone)
+}
+
+// Log returns a logger with the record as structured fields
+func (d *Target) Log() *log.Entry {
+	fields := log.Fields{
+		"zone":   d.Zone,
+		"record": d.FQDN(),
+		"type":   d.Type,
+		"new_ip": d.IP.String(),
+	}
+	if d.RemoteIP != nil {
+		fields["old_ip"] = d.RemoteIP.String()
+	}
+	return log.WithFields(fields)
+}
+
+// Lookup fetches the record from the provider, returning
+// provider.ErrRecordNotFound when it doesn't exist
+func (d *Target) Lookup(ctx context.Context) error {
+	lister, ok := provider.Unwrap(d.Provider).(provider.Lister)
+	if !ok {
+		r, err := d.Provider.GetRecord(ctx, d.Zone, d.FQDN(), d.Type)
+		if err != nil {
+			return err
+		}
+
+		d.Record = r
+		d.RemoteIP = net.ParseIP(r.Content)
+		return nil
+	}
+
+	recs, err := lister.GetRecords(ctx, d.Zone, d.FQDN(), d.Type)
+	if err != nil {
+		return err
+	}
+	if len(recs) == 0 {
+		return provider.ErrRecordNotFound
+	}
+
+	// Prefer a record already pointing at the dynamic IP, so duplicates
+	// don't cause needless updates
+	primary := 0
+	for i, r := range recs {
+		if d.IP != nil && d.IP.Equal(net.ParseIP(r.Content)) {
+			primary = i
+			break
+		}
+	}
+
+	d.Record = recs[primary]
+	d.RemoteIP = net.ParseIP(d.Record.Content)
+	d.Duplicates = append(append([]provider.Record(nil), recs[:primary]...), recs[primary+1:]...)
+
+	return nil
+}
+
+// Sync updates the record looked up by Lookup when it doesn't point at the
+// address, or its settings differ
+func (d *Target) Sync(ctx context.Context) error {
+	// Check if the dynamic and remote IP addresses are equal, and the record
+	// settings are as configured
+	ipSynced := net.IP.Equal(d.IP, d.RemoteIP)
+	if ipSynced && d.settingsSynced() {
+		return nil
+	}
+	if !ipSynced {
+		d.Log().Warnf("DNS %s record %s (%s) is out of sync with Dynamic IP (%s)", d.Type, d.FQDN(), d.RemoteIP, d.IP)
+	} else {
+		d.Log().Warnf("DNS %s record %s TTL (%d) or proxy status (%t) differ from the configuration", d.Type, d.FQDN(), d.Record.TTL, d.Record.Proxied)
+	}
+
+	if d.DryRun {
+		d.Log().Infof("dry-run: would update DNS %s record %s from %s to %s", d.Type, d.FQDN(), d.RemoteIP, d.IP)
+		return nil
+	}
+
+	record := d.Record
+	record.Content = d.IP.String()
+	d.applySettings(&record)
+
+	// Deferred updates are sent by a later sync, which runs the hooks and
+	// reports the change then
+	if d.Defer != nil && d.Defer(record) {
+		d.Deferred = true
+		d.Log().Infof("DNS %s record %s updated too recently, deferring the update to %s", d.Type, d.FQDN(), d.IP)
+		return nil
+	}
+
+	// A failing pre-update hook leaves the record as it is
+	if !ipSynced && d.BeforeUpdate != nil {
+		err := d.BeforeUpdate(ctx, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Update the dynamic IP with the provider
+	err := d.Provider.UpdateRecord(ctx, record)
+	if err != nil {
+		return err
+	}
+	d.Changed = !ipSynced
+
+	d.Log().Infof("DNS %s record %s (%s) has been synched with Dynamic IP (%s)", d.Type, d.FQDN(), d.RemoteIP, d.IP)
+
+	if d.Changed && d.AfterUpdate != nil {
+		d.AfterUpdate(ctx, d)
+	}
+	return nil
+}
+
+// Create adds the record pointing at the address, for records which don't
+// exist yet
+func (d *Target) Create(ctx context.Context) error {
+	record := provider.Record{
+		Zone:    d.Zone,
+		Name:    d.FQDN(),
+		Type:    d.Type,
+		Content: d.IP.String(),
+	}
+	d.applySettings(&record)
+
+	if d.DryRun {
+		d.Log().Infof("dry-run: would create DNS %s record %s with %s", d.Type, d.FQDN(), d.IP)
+		return nil
+	}
+
+	if d.BeforeUpdate != nil {
+		err := d.BeforeUpdate(ctx, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := d.Provider.CreateRecord(ctx, record)
+	if err != nil {
+		return err
+	}
+	d.Changed = true
+
+	d.Log().Infof("DNS %s record %s has been created with Dynamic IP (%s)", d.Type, d.FQDN(), d.IP)
+
+	if d.AfterUpdate != nil {
+		d.AfterUpdate(ctx, d)
+	}
+	return nil
+}
+
+// settingsSynced reports whether the TTL and proxy status of the record match
+// the configured ones. Providers which don't report a TTL are never updated
+// for it.
+func (d *Target) settingsSynced() bool {
+	if ttl := d.ttl(); ttl != 0 && d.Record.TTL != 0 && d.Record.TTL != ttl {
+		return false
+	}
+	if d.Proxied != nil && d.Record.Proxied != *d.Proxied {
+		return false
+	}
+	return true
+}
+
+// applySettings sets the configured TTL and proxy status on r
+func (d *Target) applySettings(r *provider.Record) {
+	if ttl := d.ttl(); ttl != 0 {
+		r.TTL = ttl
+	}
+	if d.Proxied != nil {
+		r.Proxied = *d.Proxied
+	}
+}
+
+// ttl returns the configured TTL, raised to the minimum of providers which
+// don't accept lower ones
+func (d *Target) ttl() int {
+	if l, ok := provider.Unwrap(d.Provider).(provider.TTLLimiter); ok && d.TTL != 0 && d.TTL < l.MinTTL() {
+		return l.MinTTL()
+	}
+	return d.TTL
+}
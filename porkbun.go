@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+func newPorkbun(key string) (Provider, error) {
+	apiKey := viper.GetString(key + ".apiKey")
+	secretAPIKey := viper.GetString(key + ".secretApiKey")
+	if apiKey == "" || secretAPIKey == "" {
+		return nil, fmt.Errorf("porkbun: %s.apiKey and %s.secretApiKey are required", key, key)
+	}
+
+	return provider.NewPorkbun(apiKey, secretAPIKey), nil
+}
+
+func init() {
+	registerProvider("porkbun", newPorkbun)
+}
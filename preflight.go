@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// preflight logs a table of every managed record, its current content and
+// settings, and whether it matches the detected IP, so operators see right
+// away what the daemon is going to manage
+func (d *daemon) preflight(ctx context.Context) {
+	states, err := d.status(ctx)
+	if err != nil {
+		log.Warnf("preflight: %v", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	writeStates(&buf, states)
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		log.Info("preflight: " + s.Text())
+	}
+}
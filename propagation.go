@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// propagationServers returns the name servers to check propagation on: the
+// zone's authoritative servers unless propagation.authoritative is false,
+// and the resolvers under propagation.resolvers
+func propagationServers(ctx context.Context, zone string) ([]string, error) {
+	var servers []string
+	if !viper.IsSet("propagation.authoritative") || viper.GetBool("propagation.authoritative") {
+		ns, err := net.DefaultResolver.LookupNS(ctx, zone)
+		if err != nil {
+			return nil, fmt.Errorf("unable to look up the name servers of %s: %v", zone, err)
+		}
+		for _, n := range ns {
+			servers = append(servers, strings.TrimSuffix(n.Host, "."))
+		}
+	}
+	servers = append(servers, viper.GetStringSlice("propagation.resolvers")...)
+	return servers, nil
+}
+
+// lookupOn resolves name on a single name server, bypassing the system
+// resolver and its cache
+func lookupOn(ctx context.Context, server, network, name string) ([]net.IP, error) {
+	r := net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, proto, address string) (net.Conn, error) {
+			d := net.Dialer{}
+			return d.DialContext(ctx, proto, net.JoinHostPort(server, "53"))
+		},
+	}
+	return r.LookupIP(ctx, network, name)
+}
+
+// checkPropagation waits in the background until every name server answers
+// the updated record with ip, logging how long each took and alerting when
+// it isn't visible everywhere within propagation.timeout
+func (d *daemon) checkPropagation(zone, name, recordType string, ip net.IP) {
+	interval := viper.GetDuration("propagation.interval")
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	timeout := viper.GetDuration("propagation.timeout")
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		start := time.Now()
+
+		servers, err := propagationServers(ctx, zone)
+		if err != nil {
+			log.Warnf("propagation: %v", err)
+			return
+		}
+
+		var mu sync.Mutex
+		var missing []string
+		var wg sync.WaitGroup
+		for _, server := range servers {
+			wg.Add(1)
+			go func(server string) {
+				defer wg.Done()
+				if waitForRecord(ctx, server, recordNetwork(recordType), name, ip, interval) {
+					log.Infof("propagation: DNS %s record %s visible on %s after %s", recordType, name, server, time.Since(start).Round(time.Second))
+					return
+				}
+				mu.Lock()
+				missing = append(missing, server)
+				mu.Unlock()
+			}(server)
+		}
+		wg.Wait()
+
+		delay := time.Since(start)
+		state.observePropagation(name, recordType, delay, len(missing) == 0)
+		if len(missing) == 0 {
+			log.Infof("propagation: DNS %s record %s propagated to %d servers in %s", recordType, name, len(servers), delay.Round(time.Second))
+			return
+		}
+
+		e := event{
+			Type:    eventNotPropagated,
+			NewIP:   ip.String(),
+			Records: []string{name},
+			Error:   fmt.Sprintf("not visible on %s after %s", strings.Join(missing, ", "), timeout),
+		}
+		log.Warnf("ALERT: DNS %s record %s %s", recordType, name, e.Error)
+		notify(d.notifiers, e)
+	}()
+}
+
+// waitForRecord polls server every interval until it answers name with ip,
+// reporting false when ctx is done first
+func waitForRecord(ctx context.Context, server, network, name string, ip net.IP, interval time.Duration) bool {
+	for {
+		ips, err := lookupOn(ctx, server, network, name)
+		if err == nil {
+			for _, i := range ips {
+				if i.Equal(ip) {
+					return true
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+)
+
+// The provider types live in pkg/provider, so other programs can embed them
+type (
+	Record   = provider.Record
+	Provider = provider.Provider
+	Lister   = provider.Lister
+	Deleter  = provider.Deleter
+	Verifier = provider.Verifier
+
+	credentialError = provider.CredentialError
+)
+
+var errRecordNotFound = provider.ErrRecordNotFound
+
+// providers holds the constructors of every available provider by name. Each
+// constructor reads its settings from the configuration section under key.
+var providers = map[string]func(key string) (Provider, error){}
+
+func registerProvider(name string, fn func(key string) (Provider, error)) {
+	providers[name] = fn
+}
+
+func newProvider(name string) (Provider, error) {
+	fn, ok := providers[name]
+	if !ok {
+		var names []string
+		for n := range providers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown provider '%s', expected one of %s", name, strings.Join(names, ", "))
+	}
+
+	p, err := fn(name)
+	if err != nil {
+		return nil, err
+	}
+	return withRateLimit(name, p)
+}
+
+var (
+	recordNetwork  = provider.RecordNetwork
+	unwrapProvider = provider.Unwrap
+)
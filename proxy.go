@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// proxyFor returns the proxy for req: proxy.url, unless the host matches
+// proxy.noProxy, else the one from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables. HTTP, HTTPS and SOCKS5 proxies are supported.
+func proxyFor(req *http.Request) (*url.URL, error) {
+	proxy := viper.GetString("proxy.url")
+	if proxy == "" {
+		return http.ProxyFromEnvironment(req)
+	}
+	if noProxy(req.URL.Hostname(), viper.GetString("proxy.noProxy")) {
+		return nil, nil
+	}
+	return url.Parse(proxy)
+}
+
+// noProxy reports whether host matches the comma separated list of domains,
+// addresses and networks in exclude, or is a loopback address
+func noProxy(host, exclude string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	for _, e := range strings.Split(exclude, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		switch {
+		case e == "":
+		case e == "*":
+			return true
+		case ip != nil && strings.Contains(e, "/"):
+			if _, n, err := net.ParseCIDR(e); err == nil && n.Contains(ip) {
+				return true
+			}
+		case ip != nil:
+			if ip.Equal(net.ParseIP(e)) {
+				return true
+			}
+		default:
+			e = strings.TrimPrefix(e, ".")
+			if host == e || strings.HasSuffix(host, "."+e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func init() {
+	// Provider and notifier requests go through the default transport
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.Proxy = proxyFor
+	}
+}
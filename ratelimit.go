@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+// minUpdateIntervals are the default minimum intervals between updates of a
+// record, for providers whose abuse policies require them
+var minUpdateIntervals = map[string]time.Duration{
+	"dyndns2": time.Minute,
+	"desec":   time.Minute,
+}
+
+// legacyIntervalKeys are the former names of minUpdateInterval
+var legacyIntervalKeys = map[string]string{
+	"desec": "minInterval",
+}
+
+// rateLimitResync is called once an update deferred by a rate limit may be
+// sent, and is set by the run loop to request a sync
+var rateLimitResync = func() {}
+
+// rateLimitedProvider sends updates of each record at most once per
+// interval. Updates within the interval are deferred: the record is left as
+// it is, without running hooks, and a sync is requested once the interval
+// has passed so the update goes through the usual path, with the most recent
+// address.
+type rateLimitedProvider struct {
+	Provider
+	interval time.Duration
+
+	mu        sync.Mutex
+	last      map[string]time.Time
+	scheduled map[string]bool
+}
+
+// withRateLimit wraps p with the minimum update interval under
+// key.minUpdateInterval, or the provider's default, if any
+func withRateLimit(key string, p Provider) (Provider, error) {
+	interval := minUpdateIntervals[key]
+	setting := key + ".minUpdateInterval"
+	if legacy, ok := legacyIntervalKeys[key]; ok && !viper.IsSet(setting) && viper.IsSet(key+"."+legacy) {
+		setting = key + "." + legacy
+	}
+	if viper.IsSet(setting) {
+		d, err := time.ParseDuration(viper.GetString(setting))
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid %s: %v", key, setting, err)
+		}
+		interval = d
+	}
+	if interval <= 0 {
+		return p, nil
+	}
+
+	return &rateLimitedProvider{
+		Provider:  p,
+		interval:  interval,
+		last:      map[string]time.Time{},
+		scheduled: map[string]bool{},
+	}, nil
+}
+
+// rateLimitKey identifies a record, as names with several records are
+// limited per record
+func rateLimitKey(r Record) string {
+	return r.Name + "/" + r.Type + "/" + r.ID
+}
+
+// deferUpdate reports whether an update of r has to wait for the interval
+// to pass, requesting a sync once it has
+func (p *rateLimitedProvider) deferUpdate(r Record) bool {
+	key := rateLimitKey(r)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wait := time.Until(p.last[key].Add(p.interval))
+	if wait <= 0 {
+		return false
+	}
+	if !p.scheduled[key] {
+		p.scheduled[key] = true
+		time.AfterFunc(wait, func() {
+			p.mu.Lock()
+			delete(p.scheduled, key)
+			p.mu.Unlock()
+			rateLimitResync()
+		})
+	}
+	return true
+}
+
+func (p *rateLimitedProvider) UpdateRecord(ctx context.Context, r Record) error {
+	key := rateLimitKey(r)
+	p.mu.Lock()
+	p.last[key] = time.Now()
+	p.mu.Unlock()
+
+	// Updates are deferred until the API allows them again, as if the last
+	// one was sent an interval before
+	err := p.Provider.UpdateRecord(ctx, r)
+	if rl, ok := err.(*provider.RateLimitError); ok {
+		p.mu.Lock()
+		p.last[key] = time.Now().Add(rl.RetryAfter - p.interval)
+		p.mu.Unlock()
+	}
+	return err
+}
+
+// Unwrap returns the provider behind the rate limit, for the optional
+// interfaces the wrapper doesn't implement
+func (p *rateLimitedProvider) Unwrap() Provider {
+	return p.Provider
+}
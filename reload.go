@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// watchConfig signals reload whenever the configuration file changes or the
+// process receives SIGHUP. Pending signals are coalesced, so a burst of writes
+// results in a single reload.
+func watchConfig(reload chan<- struct{}) {
+	notify := func() {
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	}
+
+	// Viper re-reads the file itself before calling back, but can't decrypt it.
+	// Without a configuration file, only SIGHUP applies.
+	if viper.ConfigFileUsed() != "" {
+		viper.OnConfigChange(func(e fsnotify.Event) {
+			log.Infof("configuration: '%s' changed", e.Name)
+			err := readConfig()
+			if err != nil {
+				log.Errorf("configuration: %v", err)
+				return
+			}
+			notify()
+		})
+		viper.WatchConfig()
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info("configuration: received SIGHUP")
+			err := readConfig()
+			if _, ok := err.(viper.ConfigFileNotFoundError); !ok && err != nil {
+				log.Errorf("configuration: %v", err)
+				continue
+			}
+			notify()
+		}
+	}()
+}
+
+// reloadDaemon builds a daemon and its schedule from the current
+// configuration, keeping the settings which only come from the command line
+func reloadDaemon(old *daemon) (*daemon, schedule, error) {
+	err := loadSecrets()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = configureLogging()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d, err := newDaemon()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sched, err := newSchedule()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d.dryRun = old.dryRun
+	return d, sched, nil
+}
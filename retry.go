@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// retryTransport retries requests failing with a network error, 429 or a
+// 5xx status, backing off exponentially with jitter between attempts. A
+// Retry-After header sent with the response takes precedence over the backoff,
+// up to the maximum delay. Requests which aren't idempotent, such as the POST
+// creating a record, are only retried when they can't have been applied.
+type retryTransport struct {
+	base     http.RoundTripper
+	retries  int
+	minDelay time.Duration
+	maxDelay time.Duration
+}
+
+// newRetryTransport returns a retryTransport configured by the settings under
+// key
+func newRetryTransport(key string) *retryTransport {
+	t := &retryTransport{
+		base:     http.DefaultTransport,
+		retries:  4,
+		minDelay: time.Second,
+		maxDelay: 30 * time.Second,
+	}
+
+	if viper.IsSet(key + ".retries") {
+		t.retries = viper.GetInt(key + ".retries")
+	}
+	if d := viper.GetDuration(key + ".minDelay"); d > 0 {
+		t.minDelay = d
+	}
+	if d := viper.GetDuration(key + ".maxDelay"); d > 0 {
+		t.maxDelay = d
+	}
+
+	return t
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		r := req
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return t.base.RoundTrip(req)
+			}
+
+			// The body was consumed by the previous attempt
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = new(http.Request)
+			*r = *req
+			r.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(r)
+		if attempt >= t.retries || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp.Header); ok {
+				delay = after
+				if delay > t.maxDelay {
+					delay = t.maxDelay
+				}
+			}
+
+			// Drain the body so the connection can be reused
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+			log.Debugf("%s %s: %s, retrying in %s", req.Method, req.URL.Path, resp.Status, delay)
+		} else {
+			log.Debugf("%s %s: %v, retrying in %s", req.Method, req.URL.Path, err, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// backoff returns the delay before the given retry attempt, doubling with
+// every attempt up to the maximum and randomised to spread out clients
+// retrying at the same time
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	d := t.minDelay << uint(attempt)
+	if d > t.maxDelay || d <= 0 {
+		d = t.maxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryable reports whether a failed request can be sent again. A POST or
+// PATCH which timed out or failed with a 5xx status may have been applied by
+// the server, so it's only retried when the connection couldn't be made or
+// the request was rate limited.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if !idempotent(req.Method) {
+		if err != nil {
+			oe, ok := err.(*net.OpError)
+			return ok && oe.Op == "dial"
+		}
+		return resp.StatusCode == http.StatusTooManyRequests
+	}
+
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header holding either a number of seconds
+// or an HTTP date
+func retryAfter(h http.Header) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
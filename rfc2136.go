@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+func newRFC2136(key string) (Provider, error) {
+	opts := provider.RFC2136Options{
+		Server:       viper.GetString(key + ".server"),
+		KeyName:      viper.GetString(key + ".keyName"),
+		KeyAlgorithm: viper.GetString(key + ".keyAlgorithm"),
+	}
+	if opts.Server == "" {
+		return nil, fmt.Errorf("rfc2136: missing %s.server", key)
+	}
+
+	if opts.KeyName != "" {
+		secret, err := base64.StdEncoding.DecodeString(viper.GetString(key + ".keySecret"))
+		if err != nil {
+			return nil, fmt.Errorf("rfc2136: invalid %s.keySecret: %v", key, err)
+		}
+		opts.KeySecret = secret
+	}
+
+	return provider.NewRFC2136(opts)
+}
+
+func init() {
+	registerProvider("rfc2136", newRFC2136)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+func newRoute53(key string) (Provider, error) {
+	opts := provider.Route53Options{
+		HostedZoneID:    viper.GetString(key + ".hostedZoneId"),
+		AccessKeyID:     viper.GetString(key + ".accessKeyId"),
+		SecretAccessKey: viper.GetString(key + ".secretAccessKey"),
+		SessionToken:    viper.GetString(key + ".sessionToken"),
+	}
+	if opts.HostedZoneID == "" {
+		return nil, fmt.Errorf("route53: missing %s.hostedZoneId", key)
+	}
+
+	return provider.NewRoute53(opts), nil
+}
+
+func init() {
+	registerProvider("route53", newRoute53)
+}
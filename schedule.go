@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// schedule decides when the daemon runs its next cycle
+type schedule interface {
+	// next returns the time of the first cycle after now
+	next(now time.Time) time.Time
+	String() string
+}
+
+// newSchedule returns the configured schedule: a cron expression under
+// schedule, or else a fixed interval under tick, skipping quiet hours
+func newSchedule() (schedule, error) {
+	var s schedule
+	if viper.IsSet("adaptive") {
+		a, err := newAdaptiveSchedule()
+		if err != nil {
+			return nil, fmt.Errorf("adaptive: %v", err)
+		}
+		s = a
+	} else if expr := viper.GetString("schedule"); expr != "" {
+		c, err := parseCron(expr)
+		if err != nil {
+			return nil, fmt.Errorf("schedule: %v", err)
+		}
+		s = c
+	} else {
+		tick, err := time.ParseDuration(viper.GetString("tick"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tick: %v", err)
+		}
+		if tick <= 0 {
+			return nil, fmt.Errorf("invalid tick: must be positive")
+		}
+		s = interval(tick)
+	}
+
+	// Jitter is applied before quiet hours, so it can't push a cycle into
+	// them
+	if viper.IsSet("jitter") {
+		jitter, err := time.ParseDuration(viper.GetString("jitter"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid jitter: %v", err)
+		}
+		if jitter > 0 {
+			s = &jitterSchedule{schedule: s, max: jitter}
+		}
+	}
+
+	quiet := viper.GetStringSlice("quietHours")
+	if len(quiet) == 0 {
+		return s, nil
+	}
+
+	q := &quietSchedule{schedule: s}
+	for _, hours := range quiet {
+		w, err := parseQuietHours(hours)
+		if err != nil {
+			return nil, fmt.Errorf("quietHours: %v", err)
+		}
+		q.windows = append(q.windows, w)
+	}
+	return q, nil
+}
+
+// scheduleInterval estimates the time between two cycles, for defaults
+// derived from the tick
+func scheduleInterval(s schedule) time.Duration {
+	if q, ok := s.(*quietSchedule); ok {
+		s = q.schedule
+	}
+	if j, ok := s.(*jitterSchedule); ok {
+		s = j.schedule
+	}
+	if a, ok := s.(*adaptiveSchedule); ok {
+		return a.max
+	}
+
+	first := s.next(time.Now())
+	return s.next(first).Sub(first)
+}
+
+// interval runs a cycle every tick
+type interval time.Duration
+
+func (i interval) next(now time.Time) time.Time {
+	return now.Add(time.Duration(i))
+}
+
+func (i interval) String() string {
+	return "every " + time.Duration(i).String()
+}
+
+// jitterSchedule delays every cycle of a schedule by a random amount of up to
+// max, so many instances sharing a schedule don't all hit the detection
+// services and provider APIs at the same second
+type jitterSchedule struct {
+	schedule
+	max time.Duration
+}
+
+func (j *jitterSchedule) next(now time.Time) time.Time {
+	return j.schedule.next(now).Add(time.Duration(rand.Int63n(int64(j.max))))
+}
+
+func (j *jitterSchedule) String() string {
+	return fmt.Sprintf("%s, with up to %s of jitter", j.schedule, j.max)
+}
+
+// adaptiveSchedule checks often right after the public IP changed, when it's
+// likely to change again, and gradually less often as it stays the same
+type adaptiveSchedule struct {
+	min, max time.Duration
+	rampUp   time.Duration // stable time after which max is reached
+}
+
+func newAdaptiveSchedule() (*adaptiveSchedule, error) {
+	// Defaults aren't registered with viper, as they would make adaptive
+	// appear set after it's removed from the configuration
+	defaults := map[string]string{"min": "1m", "max": "30m", "rampUp": "72h"}
+
+	a := &adaptiveSchedule{}
+	for key, d := range map[string]*time.Duration{"min": &a.min, "max": &a.max, "rampUp": &a.rampUp} {
+		s := viper.GetString("adaptive." + key)
+		if s == "" {
+			s = defaults[key]
+		}
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		if v <= 0 {
+			return nil, fmt.Errorf("invalid %s: must be positive", key)
+		}
+		*d = v
+	}
+	if a.max < a.min {
+		return nil, fmt.Errorf("max must not be less than min")
+	}
+
+	return a, nil
+}
+
+// interval grows linearly from min to max over the rampUp period after the
+// last IP change
+func (a *adaptiveSchedule) interval(now time.Time) time.Duration {
+	since := state.lastIPChange()
+	if since.IsZero() {
+		return a.min
+	}
+
+	stable := now.Sub(since)
+	if stable >= a.rampUp {
+		return a.max
+	}
+	if stable <= 0 {
+		return a.min
+	}
+	return a.min + time.Duration(float64(a.max-a.min)*float64(stable)/float64(a.rampUp))
+}
+
+func (a *adaptiveSchedule) next(now time.Time) time.Time {
+	return now.Add(a.interval(now))
+}
+
+func (a *adaptiveSchedule) String() string {
+	return fmt.Sprintf("adaptively every %s to %s", a.min, a.max)
+}
+
+// cronSchedule runs cycles at the minutes matching a standard five field cron
+// expression, in local time
+type cronSchedule struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64 // bit sets of the matching values
+	domRestricted, dowRestricted  bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonths = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDays   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+func parseCron(expr string) (*cronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields (minute hour day month weekday), got %d", len(fields))
+	}
+
+	c := &cronSchedule{expr: expr}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+
+	// Sunday is both 0 and 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	// As in cron, fields starting with * don't restrict the day, even with a
+	// step such as */2
+	c.domRestricted = !strings.HasPrefix(fields[2], "*")
+	c.dowRestricted = !strings.HasPrefix(fields[4], "*")
+
+	if c.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("expression never matches")
+	}
+	return c, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps
+// such as "*/15", "1-5" or "mon,wed,fri" into a bit set
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if name != "" && strings.EqualFold(s, name) {
+				return i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("invalid value '%s', expected %d-%d", s, min, max)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step '%s'", part[i+1:])
+			}
+			step, part = n, part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = value(bounds[0]); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// "5/10" is short for "5-max/10"
+				hi = max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range '%s'", part)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	// As in cron, a day matches either field when both are restricted
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (c *cronSchedule) next(now time.Time) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute()+1, 0, 0, now.Location())
+
+	// Skip whole months, days and hours which don't match, giving up after
+	// five years for expressions such as February 30th
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c *cronSchedule) String() string {
+	return "on cron schedule '" + c.expr + "'"
+}
+
+// quietWindow is a daily time range, in minutes since midnight, which may
+// wrap around midnight
+type quietWindow struct {
+	start, end int
+}
+
+// parseQuietHours parses a range such as "22:00-06:30"
+func parseQuietHours(s string) (quietWindow, error) {
+	bounds := strings.SplitN(s, "-", 2)
+	if len(bounds) != 2 {
+		return quietWindow{}, fmt.Errorf("invalid range '%s', expected HH:MM-HH:MM", s)
+	}
+
+	var w quietWindow
+	for i, b := range bounds {
+		t, err := time.Parse("15:04", strings.TrimSpace(b))
+		if err != nil {
+			return quietWindow{}, fmt.Errorf("invalid range '%s', expected HH:MM-HH:MM", s)
+		}
+		m := t.Hour()*60 + t.Minute()
+		if i == 0 {
+			w.start = m
+		} else {
+			w.end = m
+		}
+	}
+
+	return w, nil
+}
+
+// contains reports whether t falls within the window
+func (w quietWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// quietSchedule skips the cycles of a schedule which fall within quiet hours
+type quietSchedule struct {
+	schedule
+	windows []quietWindow
+}
+
+func (q *quietSchedule) next(now time.Time) time.Time {
+	// Step through the scheduled cycles until one falls outside quiet hours,
+	// giving up after a year of them
+	t := q.schedule.next(now)
+	limit := now.AddDate(1, 0, 0)
+	for q.quiet(t) && t.Before(limit) {
+		t = q.schedule.next(t)
+	}
+	return t
+}
+
+func (q *quietSchedule) quiet(t time.Time) bool {
+	for _, w := range q.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *quietSchedule) String() string {
+	var windows []string
+	for _, w := range q.windows {
+		windows = append(windows, fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60))
+	}
+	return q.schedule.String() + ", except " + strings.Join(windows, ", ")
+}
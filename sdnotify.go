@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sdNotify sends a state update to systemd when running as a Type=notify
+// unit, and does nothing otherwise
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	// Abstract sockets are given with a leading @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Debugf("systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		log.Debugf("systemd: %v", err)
+	}
+}
+
+// sdWatchdog returns the interval at which systemd expects keepalives, or 0
+// when the watchdog isn't enabled for this process
+func sdWatchdog() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	// Ping at half the timeout, as systemd recommends
+	return time.Duration(usec) * time.Microsecond / 2
+}
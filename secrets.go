@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// secretSource returns the secret referenced by ref, and how long it may be
+// used before it has to be fetched again, 0 if it doesn't expire
+type secretSource func(ctx context.Context, ref string) (string, time.Duration, error)
+
+// secretSources resolve <key>_<suffix> settings, and DYN_<KEY>_<SUFFIX>
+// environment variables, into the value of key
+var secretSources = map[string]secretSource{}
+
+func registerSecretSource(suffix string, fn secretSource) {
+	secretSources[suffix] = fn
+}
+
+// secretsRenewal is when secrets with a lease have to be fetched again, zero
+// if none of them expire
+var secretsRenewal struct {
+	sync.Mutex
+	at time.Time
+}
+
+// secretOverrides are the settings loadSecrets set, cleared before secrets
+// are loaded again so removing a reference unsets its secret
+var secretOverrides = map[string]bool{}
+
+// loadSecrets sets every setting which references a secret, the way Docker
+// and Kubernetes mount secrets: e.g. a <key>_file setting or a
+// DYN_<KEY>_FILE environment variable sets key to the contents of a file
+func loadSecrets() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// Setting nil falls back to the configuration file, or the value given
+	// with --set
+	for key := range secretOverrides {
+		viper.Set(key, cliOverrides[key])
+		delete(secretOverrides, key)
+	}
+
+	type ref struct {
+		suffix string
+		ref    string
+	}
+	refs := map[string]ref{}
+	for _, key := range viper.AllKeys() {
+		for suffix := range secretSources {
+			if strings.HasSuffix(key, "_"+suffix) {
+				refs[strings.TrimSuffix(key, "_"+suffix)] = ref{suffix, viper.GetString(key)}
+			}
+		}
+	}
+
+	// Environment variables take precedence over the configuration file, as
+	// with any other setting
+	for _, env := range os.Environ() {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], "DYN_") {
+			continue
+		}
+		for suffix := range secretSources {
+			name := strings.TrimPrefix(kv[0], "DYN_")
+			if strings.HasSuffix(name, "_"+strings.ToUpper(suffix)) {
+				key := strings.ToLower(strings.TrimSuffix(name, "_"+strings.ToUpper(suffix)))
+				refs[strings.Replace(key, "_", ".", -1)] = ref{suffix, kv[1]}
+			}
+		}
+	}
+
+	// Files are read first, as they may hold the credentials of the other
+	// sources
+	var ttl time.Duration
+	for _, files := range []bool{true, false} {
+		for key, r := range refs {
+			if (r.suffix == "file") != files {
+				continue
+			}
+
+			value, t, err := secretSources[r.suffix](ctx, r.ref)
+			if err != nil {
+				return fmt.Errorf("configuration: unable to read %s: %v", key, err)
+			}
+			viper.Set(key, value)
+			secretOverrides[key] = true
+			if t > 0 && (ttl == 0 || t < ttl) {
+				ttl = t
+			}
+		}
+	}
+
+	// Renew secrets two thirds into their lease, leaving time to retry
+	secretsRenewal.Lock()
+	secretsRenewal.at = time.Time{}
+	if ttl > 0 {
+		secretsRenewal.at = time.Now().Add(ttl * 2 / 3)
+	}
+	secretsRenewal.Unlock()
+
+	return nil
+}
+
+// watchSecrets signals reload when secrets have to be fetched again, which
+// happens as part of reloading the configuration
+func watchSecrets(ctx context.Context, reload chan<- struct{}) {
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			secretsRenewal.Lock()
+			due := !secretsRenewal.at.IsZero() && time.Now().After(secretsRenewal.at)
+			secretsRenewal.Unlock()
+			if !due {
+				continue
+			}
+
+			log.Info("configuration: renewing secrets")
+			select {
+			case reload <- struct{}{}:
+			default:
+			}
+		}
+	}()
+}
+
+func readSecretFile(ctx context.Context, path string) (string, time.Duration, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", 0, err
+	}
+	return strings.TrimSpace(string(data)), 0, nil
+}
+
+func init() {
+	registerSecretSource("file", readSecretFile)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// dyndns2Server accepts updates in the dyndns2 protocol spoken by consumer
+// routers, and applies them to the configured records through the provider
+type dyndns2Server struct {
+	daemon   *daemon
+	username string
+	password string
+	records  map[string]*dynIP // configured records by fully qualified name
+}
+
+func serveCommand(fs *flag.FlagSet) int {
+	d, ok := newConfiguredDaemon(fs)
+	if !ok {
+		return exitConfigError
+	}
+
+	s := &dyndns2Server{
+		daemon:   d,
+		username: viper.GetString("serve.username"),
+		password: viper.GetString("serve.password"),
+		records:  map[string]*dynIP{},
+	}
+	if s.username == "" || s.password == "" {
+		log.Error("serve: serve.username and serve.password are required")
+		return exitConfigError
+	}
+
+	for _, z := range d.zones {
+		for _, r := range z.Records {
+			dyn := &dynIP{
+				Provider: d.providerFor(r),
+				Zone:     z.Name,
+				Name:     r.Name,
+				DryRun:   d.dryRun,
+				TTL:      r.TTL,
+				Proxied:  r.Proxied,
+				Hooks:    updateHooks(d.providerFor(r)),
+			}
+			s.records[strings.ToLower(dyn.FQDN())] = dyn
+		}
+	}
+
+	addr := viper.GetString("serve.listen")
+	if addr == "" {
+		addr = ":8245"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nic/update", s.update)
+	mux.HandleFunc("/v3/update", s.update)
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	ctx, cancel := shutdownContext()
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	cert, key := viper.GetString("serve.tls.cert"), viper.GetString("serve.tls.key")
+	log.Infof("serve: accepting dyndns2 updates on %s", addr)
+
+	var err error
+	if cert != "" {
+		err = srv.ListenAndServeTLS(cert, key)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Errorf("serve: %v", err)
+		return exitConfigError
+	}
+
+	return exitOK
+}
+
+// update handles an update request, answering with one dyndns2 return code
+// per hostname
+func (s *dyndns2Server) update(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	user, pass, ok := r.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(s.username)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(pass), []byte(s.password)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="dyn"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "badauth")
+		return
+	}
+
+	// Default to the address of the client, as routers commonly omit myip
+	myip := r.FormValue("myip")
+	if myip == "" {
+		myip, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	ip := net.ParseIP(myip)
+	if ip == nil {
+		fmt.Fprintln(w, "911")
+		return
+	}
+
+	hostnames := strings.Split(r.FormValue("hostname"), ",")
+	for _, hostname := range hostnames {
+		fmt.Fprintln(w, s.updateHost(r.Context(), strings.TrimSpace(hostname), ip))
+	}
+}
+
+func (s *dyndns2Server) updateHost(ctx context.Context, hostname string, ip net.IP) string {
+	if !strings.Contains(hostname, ".") {
+		return "notfqdn"
+	}
+	configured, ok := s.records[strings.ToLower(strings.TrimSuffix(hostname, "."))]
+	if !ok {
+		return "nohost"
+	}
+
+	if !viper.GetBool("detector.allowPrivate") && checkPublicIP(ip) != nil {
+		log.Warnf("serve: rejecting non-public address %s for %s", ip, hostname)
+		return "dnserr"
+	}
+
+	t := "AAAA"
+	if ip.To4() != nil {
+		t = "A"
+	}
+
+	dyn := *configured
+	dyn.Type = t
+	dyn.IP = ip
+
+	ctx, cancel := s.daemon.withTimeout(ctx)
+	defer cancel()
+
+	err := s.daemon.syncRecord(ctx, &dyn)
+	state.observeRecord(dyn.FQDN(), t, s.daemon.syncedContent(&dyn, err), dyn.Changed, err)
+	state.save()
+	if err != nil {
+		return "911"
+	}
+
+	if !dyn.Changed {
+		return "nochg " + ip.String()
+	}
+
+	old := ""
+	if dyn.RemoteIP != nil {
+		old = dyn.RemoteIP.String()
+	}
+	recordHistory(historyEntry{Event: historyRecordUpdated, Type: t, Record: dyn.FQDN(), OldIP: old, NewIP: ip.String()})
+	notify(s.daemon.notifiers, event{Type: eventIPChanged, OldIP: old, NewIP: ip.String(), Records: []string{dyn.FQDN()}})
+
+	return "good " + ip.String()
+}
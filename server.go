@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// agentRequest asks the run loop to point the records of an agent at ip,
+// replying with the number of records or the error
+type agentRequest struct {
+	agent string
+	ip    net.IP
+	done  chan agentResult
+}
+
+type agentResult struct {
+	records int
+	err     error
+}
+
+// serveAgents accepts the public IPs reported by agents on server.listen,
+// and has the run loop sync the records assigned to them. Agents are
+// authenticated by their token under server.agents.<name>.token, and the
+// server must use TLS unless server.insecure is set, e.g. behind a reverse
+// proxy terminating it.
+func serveAgents(ctx context.Context, ctl *control) error {
+	addr := viper.GetString("server.listen")
+	cert, key := viper.GetString("server.tls.cert"), viper.GetString("server.tls.key")
+	if cert == "" && !viper.GetBool("server.insecure") {
+		return fmt.Errorf("server: server.tls.cert and server.tls.key are required")
+	}
+
+	tokens := map[string]string{}
+	var names []string
+	for name := range viper.GetStringMap("server.agents") {
+		token := viper.GetString("server.agents." + name + ".token")
+		if token == "" {
+			return fmt.Errorf("server: missing server.agents.%s.token", name)
+		}
+		tokens[name] = token
+		names = append(names, name)
+	}
+	if len(tokens) == 0 {
+		return fmt.Errorf("server: no agents configured under server.agents")
+	}
+	sort.Strings(names)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/report", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		// Every token is compared, so timing doesn't tell which agents exist
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		agent := ""
+		for _, name := range names {
+			if subtle.ConstantTimeCompare([]byte(got), []byte(tokens[name])) == 1 {
+				agent = name
+			}
+		}
+		if agent == "" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+
+		var report agentReport
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&report)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid report"})
+			return
+		}
+
+		records := 0
+		for _, s := range []string{report.IPv4, report.IPv6} {
+			if s == "" {
+				continue
+			}
+			ip := net.ParseIP(s)
+			if ip == nil || (s == report.IPv4) != (ip.To4() != nil) {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid address " + s})
+				return
+			}
+
+			req := agentRequest{agent: agent, ip: ip, done: make(chan agentResult, 1)}
+			select {
+			case ctl.report <- req:
+			case <-r.Context().Done():
+				return
+			}
+
+			result := <-req.done
+			if result.err != nil {
+				writeJSON(w, http.StatusBadGateway, map[string]string{"error": result.err.Error()})
+				return
+			}
+			records += result.records
+		}
+
+		if records == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no records are assigned to agent " + agent})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"records": records})
+	})
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("server: %v", err)
+	}
+
+	srv := &http.Server{Handler: mux}
+	closeWhenDone(ctx, srv)
+
+	go func() {
+		log.Infof("server: accepting reports of %d agents on %s", len(names), addr)
+		var err error
+		if cert != "" {
+			err = srv.ServeTLS(l, cert, key)
+		} else {
+			err = srv.Serve(l)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("server: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// syncAgent points the records assigned to an agent at the address it
+// reported, returning the number of records
+func (d *daemon) syncAgent(ctx context.Context, agent string, ip net.IP) (int, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	t := "AAAA"
+	if ip.To4() != nil {
+		t = "A"
+	}
+
+	if !viper.GetBool("detector.allowPrivate") {
+		err := checkPublicIP(ip)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Agents may report both addresses while only records of one type are
+	// assigned to them
+	targets := d.agentTargets(agent, t)
+	if len(targets) == 0 {
+		return 0, nil
+	}
+
+	total, failed := d.syncRecords(ctx, t, ip, targets)
+	if failed > 0 {
+		return total, &syncError{Failed: failed, Total: total}
+	}
+	return total, nil
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+)
+
+// runService only applies to Windows
+func runService(args []string) (bool, int) {
+	return false, 0
+}
+
+func serviceCommand(fs *flag.FlagSet) int {
+	log.Error("service: Windows services are only supported on Windows, use systemd or another supervisor instead")
+	return exitConfigError
+}
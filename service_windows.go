@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "dyn"
+
+// runService runs the command line as a Windows service when started by the
+// service control manager, returning false when running interactively
+func runService(args []string) (bool, int) {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil || interactive {
+		return false, 0
+	}
+
+	// Logs go to the event log, as services have no console
+	elog, err := eventlog.Open(serviceName)
+	if err == nil {
+		defer elog.Close()
+		hook := &eventlogHook{elog: elog}
+		extraLogHooks = append(extraLogHooks, hook)
+		log.AddHook(hook)
+	}
+
+	s := &windowsService{args: args}
+	err = svc.Run(serviceName, s)
+	if err != nil {
+		log.Errorf("service: %v", err)
+		return true, exitConfigError
+	}
+
+	return true, s.code
+}
+
+type windowsService struct {
+	args []string
+	code int
+}
+
+func (s *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	done := make(chan int, 1)
+	go func() {
+		done <- runCLI(s.args)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case s.code = <-done:
+			return false, uint32(s.code)
+
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				close(serviceStop)
+				s.code = <-done
+				return false, uint32(s.code)
+			}
+		}
+	}
+}
+
+// eventlogHook writes log entries to the Windows event log
+type eventlogHook struct {
+	elog *eventlog.Log
+}
+
+func (h *eventlogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *eventlogHook) Fire(e *log.Entry) error {
+	msg, err := e.String()
+	if err != nil {
+		return err
+	}
+
+	switch e.Level {
+	case log.PanicLevel, log.FatalLevel, log.ErrorLevel:
+		return h.elog.Error(1, msg)
+	case log.WarnLevel:
+		return h.elog.Warning(1, msg)
+	}
+	return h.elog.Info(1, msg)
+}
+
+// serviceCommand installs, removes, starts or stops the Windows service
+func serviceCommand(fs *flag.FlagSet) int {
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: dyn service [flags] install|uninstall|start|stop")
+		return exitConfigError
+	}
+
+	var err error
+	switch fs.Arg(0) {
+	case "install":
+		err = installService(fs)
+	case "uninstall":
+		err = uninstallService()
+	case "start", "stop":
+		err = controlService(fs.Arg(0))
+	default:
+		err = fmt.Errorf("unknown action '%s', expected install, uninstall, start or stop", fs.Arg(0))
+	}
+	if err != nil {
+		log.Errorf("service: %v", err)
+		return exitConfigError
+	}
+
+	return exitOK
+}
+
+func installService(fs *flag.FlagSet) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// The service runs the daemon with the configuration file it was
+	// installed with
+	args := []string{"run"}
+	if path, _ := fs.GetString("config"); path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		args = append(args, "--config", abs)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+
+	s, err = m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "dyn dynamic DNS client",
+		Description: "Keeps DNS records in sync with the public IP address",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	err = eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil {
+		s.Delete()
+		return fmt.Errorf("unable to register event log source: %v", err)
+	}
+
+	fmt.Printf("service %s installed\n", serviceName)
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+
+	err = s.Delete()
+	if err != nil {
+		return err
+	}
+	eventlog.Remove(serviceName)
+
+	fmt.Printf("service %s uninstalled\n", serviceName)
+	return nil
+}
+
+func controlService(action string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+
+	if action == "start" {
+		return s.Start()
+	}
+	_, err = s.Control(svc.Stop)
+	return err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// services are the servers and watchers running alongside the daemon: the
+// health checks, API, agent server, network, Kubernetes and Docker watchers
+// and the leader election. They depend on the configuration and the daemon,
+// so they're stopped and started again on reload.
+type services struct {
+	lead *leadership
+
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// closingServers tracks the servers being closed, so services are only
+// started again once their addresses are released
+var closingServers sync.WaitGroup
+
+// closeWhenDone closes srv once ctx is done
+func closeWhenDone(ctx context.Context, srv *http.Server) {
+	closingServers.Add(1)
+	go func() {
+		defer closingServers.Done()
+		<-ctx.Done()
+		srv.Close()
+	}()
+}
+
+// startServices starts the services configured for d until ctx is done or
+// they're stopped. The leader election lead, which may be nil, is built
+// beforehand so an invalid one doesn't leave records without a leader. The
+// services started are returned along with any error.
+func startServices(ctx context.Context, d *daemon, sched schedule, ctl *control, lead *leadership) (*services, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &services{lead: lead, cancel: cancel}
+
+	// With several instances, only the elected one updates records, syncing
+	// as soon as it takes over
+	if lead != nil {
+		s.done.Add(1)
+		go func() {
+			defer s.done.Done()
+			lead.run(ctx, ctl.requestSync)
+		}()
+	}
+
+	if addr := viper.GetString("health.listen"); addr != "" {
+		maxAge := viper.GetDuration("health.maxAge")
+		if maxAge <= 0 {
+			maxAge = 3*scheduleInterval(sched) + viper.GetDuration("cycleTimeout")
+		}
+		serveHealth(ctx, addr, maxAge)
+	}
+
+	// Sync as soon as the network changes, rather than on the next check
+	if viper.GetBool("network.watch") || viper.GetBool("network.networkManager") {
+		err := watchNetwork(ctx, ctl)
+		if err != nil {
+			log.Warn(err)
+		}
+	}
+
+	if addr := viper.GetString("api.listen"); addr != "" {
+		err := serveAPI(ctx, addr, ctl)
+		if err != nil {
+			return s, err
+		}
+	}
+
+	// Sync as soon as a published Service or Ingress changes
+	if d.kubernetes != nil {
+		d.watchKubernetes(ctx, ctl.requestSync)
+	}
+
+	// Register the records of containers as soon as they start
+	if d.docker != nil {
+		d.watchDocker(ctx, ctl.requestSync)
+	}
+
+	if viper.IsSet("server.listen") {
+		err := serveAgents(ctx, ctl)
+		if err != nil {
+			return s, err
+		}
+	}
+
+	return s, nil
+}
+
+// stop stops the services, waiting for the leader to resign and the servers
+// to close
+func (s *services) stop() {
+	s.cancel()
+	s.done.Wait()
+	closingServers.Wait()
+}
+
+// restartServices stops s and starts the services again with the current
+// configuration and d. The previous services keep running when the leader
+// election is invalid.
+func restartServices(ctx context.Context, s *services, d *daemon, sched schedule, ctl *control) (*services, error) {
+	lead, err := newLeadership()
+	if err != nil {
+		return s, fmt.Errorf("configuration: %v", err)
+	}
+
+	s.stop()
+	return startServices(ctx, d, sched, ctl, lead)
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySyncSignal relays SIGUSR1, which requests an immediate sync, to c
+func notifySyncSignal(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
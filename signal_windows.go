@@ -0,0 +1,9 @@
+package main
+
+import (
+	"os"
+)
+
+// notifySyncSignal does nothing, as Windows has no SIGUSR1. Use the API to
+// request a sync instead.
+func notifySyncSignal(c chan<- os.Signal) {}
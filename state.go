@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// savedIP is the last detected public IP of a record type
+type savedIP struct {
+	IP      string    `json:"ip"`
+	Since   time.Time `json:"since"`   // first detected
+	Checked time.Time `json:"checked"` // last detected
+}
+
+// savedRecord is the last known state of a record
+type savedRecord struct {
+	Name    string     `json:"name"`
+	Type    string     `json:"type"`
+	Content string     `json:"content,omitempty"`
+	Updated *time.Time `json:"updated,omitempty"` // last changed by dyn
+	Checked time.Time  `json:"checked"`
+	Error   string     `json:"error,omitempty"`
+
+	// Seconds until the last update was visible on every name server, when
+	// propagation is checked
+	Propagation *float64 `json:"propagationSeconds,omitempty"`
+	Propagated  *bool    `json:"propagated,omitempty"`
+
+	// Value the record was changed to outside of dyn, when drift is detected
+	Drifted string `json:"drifted,omitempty"`
+}
+
+// stateStore keeps the last detected IPs and record states, persisted to a
+// JSON file when one is configured so restarts don't lose them
+type stateStore struct {
+	mu   sync.Mutex
+	path string
+
+	IPs      map[string]*savedIP     `json:"ips"`
+	LastSync *time.Time              `json:"lastSync,omitempty"`
+	Records  map[string]*savedRecord `json:"records"`
+	Pending  map[string]*pendingIP   `json:"pending,omitempty"` // IPs awaiting debounce
+	Changes  []time.Time             `json:"changes,omitempty"` // recent IP changes
+	Flapping bool                    `json:"flapping,omitempty"`
+}
+
+// maxSavedChanges bounds the recent IP changes kept for flapping detection
+const maxSavedChanges = 1000
+
+var state = stateStore{
+	IPs:     map[string]*savedIP{},
+	Records: map[string]*savedRecord{},
+	Pending: map[string]*pendingIP{},
+}
+
+// open loads the state file at path, which is created on the next save if it
+// doesn't exist yet
+func (s *stateStore) open(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.path = path
+	if path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return err
+	}
+	if s.IPs == nil {
+		s.IPs = map[string]*savedIP{}
+	}
+	if s.Records == nil {
+		s.Records = map[string]*savedRecord{}
+	}
+	if s.Pending == nil {
+		s.Pending = map[string]*pendingIP{}
+	}
+
+	return nil
+}
+
+// observeIP records the detected IP for a record type, returning the
+// previously detected IP and whether it changed
+func (s *stateStore) observeIP(recordType string, ip net.IP) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	prev, ok := s.IPs[recordType]
+	if ok && prev.IP == ip.String() {
+		prev.Checked = now
+		log.Debugf("public %s IP %s unchanged since %s", recordType, ip, prev.Since.Format(time.RFC3339))
+		return prev.IP, false
+	}
+
+	s.IPs[recordType] = &savedIP{IP: ip.String(), Since: now, Checked: now}
+	if !ok {
+		return "", true
+	}
+	s.Changes = append(s.Changes, now)
+	if len(s.Changes) > maxSavedChanges {
+		s.Changes = s.Changes[len(s.Changes)-maxSavedChanges:]
+	}
+	return prev.IP, true
+}
+
+// lastIPChange returns when the most recent of the detected IPs was first
+// detected, zero before any detection
+func (s *stateStore) lastIPChange() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var last time.Time
+	for _, ip := range s.IPs {
+		if ip.Since.After(last) {
+			last = ip.Since
+		}
+	}
+	return last
+}
+
+// recordContent returns the last value dyn saw or set for a record
+func (s *stateStore) recordContent(name, recordType string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.Records[name+"/"+recordType]
+	if !ok {
+		return ""
+	}
+	return r.Content
+}
+
+// observePropagation records how long the last update of a record took to
+// propagate, or that it didn't
+func (s *stateStore) observePropagation(name, recordType string, delay time.Duration, propagated bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.Records[name+"/"+recordType]
+	if !ok {
+		return
+	}
+	seconds := delay.Seconds()
+	r.Propagation = &seconds
+	r.Propagated = &propagated
+}
+
+// observeRecord records the outcome of syncing a record
+func (s *stateStore) observeRecord(name, recordType, content string, changed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := name + "/" + recordType
+	r, ok := s.Records[key]
+	if !ok {
+		r = &savedRecord{Name: name, Type: recordType}
+		s.Records[key] = r
+	}
+
+	now := time.Now()
+	r.Checked = now
+	r.Error = ""
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+	if content != "" {
+		r.Content = content
+	}
+	if changed {
+		r.Updated = &now
+	}
+}
+
+// synced records a cycle in which every record synced
+func (s *stateStore) synced() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.LastSync = &now
+}
+
+// save writes the state file, replacing it atomically
+func (s *stateStore) save() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		log.Errorf("state: %v", err)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".state")
+	if err != nil {
+		log.Errorf("state: %v", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		log.Errorf("state: %v", err)
+	}
+}
+
+// snapshot returns a copy of the state, safe to use without holding the lock
+func (s *stateStore) snapshot() *stateStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := &stateStore{
+		IPs:      map[string]*savedIP{},
+		LastSync: s.LastSync,
+		Records:  map[string]*savedRecord{},
+		Changes:  append([]time.Time(nil), s.Changes...),
+		Flapping: s.Flapping,
+	}
+	for k, v := range s.IPs {
+		ip := *v
+		c.IPs[k] = &ip
+	}
+	for k, v := range s.Records {
+		r := *v
+		c.Records[k] = &r
+	}
+
+	return c
+}
+
+// sortSavedRecords sorts records by name and type
+func sortSavedRecords(rs []*savedRecord) {
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].Name != rs[j].Name {
+			return rs[i].Name < rs[j].Name
+		}
+		return rs[i].Type < rs[j].Type
+	})
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	dynsync "github.com/ianmuscat/dyn/pkg/sync"
+)
+
+// Records are synced through pkg/sync, so other programs can sync them the
+// same way, while detection and scheduling are left to the daemon
+type (
+	dynIP          = dynsync.Target
+	detectionError = dynsync.DetectionError
+	syncError      = dynsync.SyncError
+)
+
+var cycleError = dynsync.CycleError
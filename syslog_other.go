@@ -0,0 +1,16 @@
+//go:build windows || nacl || plan9
+// +build windows nacl plan9
+
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// newSyslogHook is unavailable, as Go's syslog package isn't supported on this
+// platform
+func newSyslogHook(network, raddr, tag string) (log.Hook, func() error, error) {
+	return nil, nil, fmt.Errorf("syslog is not supported on this platform")
+}
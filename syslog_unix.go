@@ -0,0 +1,21 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package main
+
+import (
+	"log/syslog"
+
+	log "github.com/sirupsen/logrus"
+	lsyslog "github.com/sirupsen/logrus/hooks/syslog"
+)
+
+// newSyslogHook connects to the syslog daemon at raddr over network, or to
+// the local one when raddr is empty
+func newSyslogHook(network, raddr, tag string) (log.Hook, func() error, error) {
+	hook, err := lsyslog.NewSyslogHook(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hook, hook.Writer.Close, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// durationKeys lists the configuration keys holding durations, checked by
+// validateConfig when they are set
+var durationKeys = []string{
+	"tick",
+	"cycleTimeout",
+	"health.maxAge",
+	"desec.minInterval",
+	"desec.minUpdateInterval",
+	"debounce.duration",
+	"flapping.window",
+	"propagation.interval",
+	"propagation.timeout",
+	"cloudflare.cache",
+	"cloudflare.timeout",
+	"hooks.timeout",
+	"leader.ttl",
+}
+
+// validateConfig checks the loaded configuration and the provider
+// credentials, returning every problem found. Credentials are verified with a
+// read-only lookup of the first record in each zone.
+func validateConfig(ctx context.Context) []error {
+	var problems []error
+
+	if viper.GetString("tick") == "" {
+		problems = append(problems, fmt.Errorf("tick is required"))
+	}
+	for _, key := range durationKeys {
+		if !viper.IsSet(key) {
+			continue
+		}
+		d, err := time.ParseDuration(viper.GetString(key))
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %v", key, err))
+		} else if d < 0 || (d == 0 && key == "tick") {
+			problems = append(problems, fmt.Errorf("%s: must be positive", key))
+		}
+	}
+
+	_, err := newSchedule()
+	if err != nil {
+		problems = append(problems, err)
+	}
+
+	switch viper.GetString("failures.action") {
+	case "exit", "alert":
+	default:
+		problems = append(problems, fmt.Errorf("failures.action: expected exit or alert"))
+	}
+
+	switch viper.GetString("dns.duplicates") {
+	case "", "first", "all", "collapse":
+	default:
+		problems = append(problems, fmt.Errorf("dns.duplicates: expected first, all or collapse"))
+	}
+
+	switch viper.GetString("drift.mode") {
+	case "", "alert", "restore":
+	default:
+		problems = append(problems, fmt.Errorf("drift.mode: expected alert or restore"))
+	}
+
+	switch viper.GetString("leader.backend") {
+	case "", "file", "consul", "etcd", "kubernetes":
+	default:
+		problems = append(problems, fmt.Errorf("leader.backend: expected file, consul, etcd or kubernetes"))
+	}
+
+	// Constructing the daemon checks the provider, detector, mode and zones
+	d, err := newDaemon()
+	if err != nil {
+		return append(problems, err)
+	}
+
+	for _, z := range d.zones {
+		if z.Name == "" {
+			problems = append(problems, fmt.Errorf("zones: zone name must not be empty"))
+			continue
+		}
+
+		r := z.Records[0]
+		dyn := dynIP{
+			Provider: d.providerFor(r),
+			Zone:     z.Name,
+			Name:     r.Name,
+			Type:     r.types[0],
+		}
+		err = dyn.Lookup(ctx)
+		if err != nil && err != errRecordNotFound {
+			problems = append(problems, fmt.Errorf("provider: unable to read %s: %v", dyn.FQDN(), err))
+		}
+	}
+
+	err = d.verifyCredentials(ctx)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("provider: %v", err))
+	}
+
+	return problems
+}
+
+// verifyCredentials checks the credentials of the providers able to for every
+// zone they manage, returning the first credentialError. Other errors are
+// logged, so an unreachable API doesn't stop the daemon from starting.
+func (d *daemon) verifyCredentials(ctx context.Context) error {
+	type check struct {
+		verifier Verifier
+		zone     string
+	}
+	checked := map[check]bool{}
+
+	for _, z := range d.zones {
+		for _, r := range z.Records {
+			v, ok := unwrapProvider(d.providerFor(r)).(Verifier)
+			if !ok || checked[check{v, z.Name}] {
+				continue
+			}
+			checked[check{v, z.Name}] = true
+
+			err := v.Verify(ctx, z.Name)
+			if _, ok := err.(*credentialError); ok {
+				return err
+			}
+			if err != nil {
+				log.Warnf("provider: unable to verify the credentials for %s: %v", z.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
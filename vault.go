@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const kubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultLogin holds the Vault token obtained by logging in, shared by every
+// secret read
+var vaultLogin struct {
+	sync.Mutex
+	token   string
+	renewAt time.Time
+	ttl     time.Duration
+}
+
+// vaultAddress returns the Vault server address, from vault.address or
+// VAULT_ADDR
+func vaultAddress() (string, error) {
+	addr := viper.GetString("vault.address")
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return "", fmt.Errorf("missing vault.address")
+	}
+	return strings.TrimSuffix(addr, "/"), nil
+}
+
+func vaultDo(ctx context.Context, method, path, token string, body, out interface{}) error {
+	addr, err := vaultAddress()
+	if err != nil {
+		return err
+	}
+
+	req, err := newJSONRequest(method, addr+"/v1/"+strings.TrimPrefix(path, "/"), body)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if ns := viper.GetString("vault.namespace"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	return httpJSON(ctx, req, out)
+}
+
+// vaultToken returns a token to read secrets with, logging in with the
+// configured auth method when there is no token yet or it is due for renewal.
+// The token's TTL is returned as well, 0 if it doesn't expire.
+func vaultToken(ctx context.Context) (string, time.Duration, error) {
+	vaultLogin.Lock()
+	defer vaultLogin.Unlock()
+
+	if vaultLogin.token != "" && (vaultLogin.renewAt.IsZero() || time.Now().Before(vaultLogin.renewAt)) {
+		return vaultLogin.token, vaultLogin.ttl, nil
+	}
+
+	method := viper.GetString("vault.auth")
+	var body map[string]string
+	switch method {
+	case "", "token":
+		token := viper.GetString("vault.token")
+		if token == "" {
+			token = os.Getenv("VAULT_TOKEN")
+		}
+		if token == "" {
+			return "", 0, fmt.Errorf("missing vault.token")
+		}
+		vaultLogin.token = token
+		return token, 0, nil
+
+	case "approle":
+		body = map[string]string{
+			"role_id":   viper.GetString("vault.roleId"),
+			"secret_id": viper.GetString("vault.secretId"),
+		}
+
+	case "kubernetes":
+		jwt, err := ioutil.ReadFile(kubernetesTokenPath)
+		if err != nil {
+			return "", 0, fmt.Errorf("kubernetes auth: %v", err)
+		}
+		body = map[string]string{
+			"role": viper.GetString("vault.role"),
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}
+
+	default:
+		return "", 0, fmt.Errorf("unknown vault.auth '%s', expected one of token, approle or kubernetes", method)
+	}
+
+	mount := viper.GetString("vault.mount")
+	if mount == "" {
+		mount = method
+	}
+
+	var resp struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	err := vaultDo(ctx, "POST", "auth/"+mount+"/login", "", body, &resp)
+	if err != nil {
+		return "", 0, fmt.Errorf("%s login: %v", method, err)
+	}
+
+	ttl := time.Duration(resp.Auth.LeaseDuration) * time.Second
+	vaultLogin.token = resp.Auth.ClientToken
+	vaultLogin.ttl = ttl
+	vaultLogin.renewAt = time.Time{}
+	if ttl > 0 {
+		vaultLogin.renewAt = time.Now().Add(ttl * 2 / 3)
+	}
+
+	return vaultLogin.token, ttl, nil
+}
+
+// readVaultSecret reads a secret referenced as <path>#<field>, the field
+// defaulting to "value". Secrets of KV version 2 engines are read from their
+// data/ path, e.g. secret/data/dyn#apiKey.
+func readVaultSecret(ctx context.Context, ref string) (string, time.Duration, error) {
+	path, field := ref, "value"
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		path, field = ref[:i], ref[i+1:]
+	}
+
+	token, ttl, err := vaultToken(ctx)
+	if err != nil {
+		return "", 0, fmt.Errorf("vault: %v", err)
+	}
+
+	var resp struct {
+		LeaseDuration int                    `json:"lease_duration"`
+		Data          map[string]interface{} `json:"data"`
+	}
+	err = vaultDo(ctx, "GET", path, token, nil, &resp)
+	if isHTTPStatus(err, http.StatusNotFound) {
+		return "", 0, fmt.Errorf("vault: secret %s not found", path)
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("vault: %v", err)
+	}
+
+	// KV version 2 nests the secret under data, next to its metadata
+	data := resp.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+
+	value, ok := data[field]
+	if !ok {
+		return "", 0, fmt.Errorf("vault: secret %s has no field %s", path, field)
+	}
+
+	// Dynamic secrets have to be read again before their lease expires
+	if lease := time.Duration(resp.LeaseDuration) * time.Second; lease > 0 && (ttl == 0 || lease < ttl) {
+		ttl = lease
+	}
+
+	return fmt.Sprint(value), ttl, nil
+}
+
+func init() {
+	registerSecretSource("vault", readVaultSecret)
+}
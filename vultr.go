@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ianmuscat/dyn/pkg/provider"
+	"github.com/spf13/viper"
+)
+
+func newVultr(key string) (Provider, error) {
+	token := viper.GetString(key + ".token")
+	if token == "" {
+		return nil, fmt.Errorf("vultr: missing %s.token", key)
+	}
+
+	return provider.NewVultr(token), nil
+}
+
+func init() {
+	registerProvider("vultr", newVultr)
+}
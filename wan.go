@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/ianmuscat/dyn/pkg/detect"
+	"github.com/spf13/viper"
+)
+
+// bindableDetectors are the detector types which make their own connections,
+// and so can be bound to an uplink
+var bindableDetectors = map[string]bool{
+	"dns":    true,
+	"google": true,
+	"akamai": true,
+	"http":   true,
+	"stun":   true,
+}
+
+// newBinding returns the binding set as interface or source under key, nil
+// when neither is
+func newBinding(key string) (*detect.Binding, error) {
+	b := &detect.Binding{Interface: viper.GetString(key + ".interface")}
+	if s := viper.GetString(key + ".source"); s != "" {
+		b.Source = net.ParseIP(s)
+		if b.Source == nil {
+			return nil, fmt.Errorf("detector: invalid %s.source %s", key, s)
+		}
+	}
+	if b.Interface == "" && b.Source == nil {
+		return nil, nil
+	}
+	return b, nil
+}
+
+// newWANDetector returns the detector of the public IP of the uplink under
+// wans.<name>, which holds its type and settings along with the interface or
+// source address detection is bound to
+func newWANDetector(name string) (Detector, error) {
+	key := "wans." + name
+	if !viper.IsSet(key) {
+		return nil, fmt.Errorf("unknown WAN %s, missing %s", name, key)
+	}
+
+	t := viper.GetString(key + ".type")
+	if t == "" {
+		t = viper.GetString("detector.type")
+	}
+	if !bindableDetectors[t] {
+		return nil, fmt.Errorf("%s: the %s detector can't be bound to an uplink, set %s.type", key, t, key)
+	}
+	if viper.GetString(key+".interface") == "" && viper.GetString(key+".source") == "" {
+		return nil, fmt.Errorf("%s: missing interface or source", key)
+	}
+
+	return detectors[t](key)
+}